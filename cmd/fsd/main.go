@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/exantech/monero-fastsync/internal/app/fsd"
 	"github.com/exantech/monero-fastsync/internal/app/fsd/server"
+	"github.com/exantech/monero-fastsync/internal/app/syncer"
+	"github.com/exantech/monero-fastsync/internal/app/syncer/worker"
 	"github.com/exantech/monero-fastsync/internal/pkg/logging"
 	"github.com/exantech/monero-fastsync/internal/pkg/metrics"
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
@@ -71,11 +74,22 @@ func main() {
 			metricsConf.Graphite.Host, metricsConf.Graphite.Port)
 	}
 
-	logging.Log.Infof("Connecting to DB: host=%s, port=%d, database=%s, user=%s",
-		conf.BlockchainDb.Host, conf.BlockchainDb.Port, conf.BlockchainDb.Database, conf.BlockchainDb.User)
+	if conf.BlockchainDb.GetDriver() == utils.DriverBolt {
+		logging.Log.Infof("Opening DB file: %s", conf.BlockchainDb.Path)
+	} else {
+		logging.Log.Infof("Connecting to DB: host=%s, port=%d, database=%s, user=%s",
+			conf.BlockchainDb.Host, conf.BlockchainDb.Port, conf.BlockchainDb.Database, conf.BlockchainDb.User)
+	}
 
 	logging.Log.Infof("Using %s network", strings.ToUpper(conf.Network))
 
+	// the syncer goes first, since it saves genesis into the empty DB
+	ctx, cancel := context.WithCancel(context.Background())
+	var syncDone <-chan error
+	if len(conf.SyncerConfig) != 0 {
+		syncDone = startSyncer(ctx, conf.SyncerConfig, conf.BlockchainDb, conf.Network)
+	}
+
 	db, err := server.NewDbWorker(conf.BlockchainDb)
 	if err != nil {
 		logging.Log.Fatalf("Failed to connect to DB: %s", err.Error())
@@ -96,7 +110,31 @@ func main() {
 		logging.Log.Fatal(http.ListenAndServe(conf.Pprof, nil))
 	}()
 
-	queue := server.NewJobsQueue(server.NewScanner(db), db, conf.ProcessBlocks, conf.ResultBlocks, conf.JobLifetime)
+	var cache *server.BlocksCache
+	if conf.BlocksCacheMb > 0 {
+		logging.Log.Infof("Using blocks cache of %d MB", conf.BlocksCacheMb)
+		cache = server.NewBlocksCache(db, conf.BlocksCacheMb*1024*1024)
+		db = cache
+	}
+
+	queue := server.NewJobsQueue(server.NewScanner(db, conf.ScanThreads), db, conf.ProcessBlocks, conf.ResultBlocks, conf.BatchJobs,
+		conf.JobLifetime)
+
+	if cache != nil {
+		queue.AddChainListener(cache)
+	}
+
+	// bolt has no notifications, new blocks are noticed by polling only
+	if conf.BlockchainDb.GetDriver() == utils.DriverPostgres {
+		if err = queue.ListenChainEvents(conf.BlockchainDb); err != nil {
+			logging.Log.Fatalf("Failed to listen blockchain events: %s", err.Error())
+		}
+	}
+
+	if conf.PrescanWindow > 0 {
+		logging.Log.Infof("Prescanning wallets requested within %s", conf.PrescanWindow)
+		queue.EnablePrescan(conf.PrescanWindow, conf.PrescanMax)
+	}
 
 	err = queue.StartWorkers(conf.Workers)
 	if err != nil {
@@ -108,8 +146,56 @@ func main() {
 	logging.Log.Infof("Starting server on %s", conf.Server)
 	handler.StartAsync(conf.Server)
 
-	<-sig
+	select {
+	case <-sig:
+	case err = <-syncDone:
+		if err != nil {
+			logging.Log.Fatalf("Sync worker finished with error: %s", err.Error())
+		}
+
+		logging.Log.Info("Sync worker stopped")
+	}
 
+	cancel()
 	queue.Stop()
 	logging.Log.Infof("Server stopped by signal")
 }
+
+// Runs the sync loop in this process, which is the only way for the syncer and fsd to share a bolt file
+func startSyncer(ctx context.Context, path string, settings utils.DbSettings, network string) <-chan error {
+	conf := syncer.MakeDefaultConfig()
+	if err := utils.ReadYamlConfig(path, &conf); err != nil {
+		logging.Log.Fatalf("Couldn't read syncer config file: %s", err.Error())
+	}
+
+	// blockchain_db and network of the syncer's config are ignored, both must be the same as fsd's ones
+	conf.BlockchainDb = settings
+	conf.Network = network
+
+	db, err := worker.NewDbOperator(conf.BlockchainDb)
+	if err != nil {
+		logging.Log.Fatalf("Couldn't make syncer's db operator: %s", err.Error())
+	}
+
+	w, _, err := syncer.MakeWorker(conf, db, true)
+	if err != nil {
+		logging.Log.Fatalf("Couldn't make sync worker: %s", err.Error())
+	}
+
+	saved, err := db.GetBlockHash(0)
+	if err != nil {
+		logging.Log.Fatalf("Failed to get genesis block: %s", err.Error())
+	}
+
+	if err = w.CheckGenesis(ctx, saved == nil); err != nil {
+		logging.Log.Fatalf("Error on checking genesis block hash compliance: %s", err.Error())
+	}
+
+	if err = w.CheckCheckpoints(); err != nil {
+		logging.Log.Fatalf("Error on checking checkpoints: %s. DB contains blockchain other than the network's one, "+
+			"it should be resynchronized", err.Error())
+	}
+
+	logging.Log.Infof("Starting sync loop with syncer config %s", path)
+	return w.RunSyncLoop(ctx)
+}
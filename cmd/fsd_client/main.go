@@ -38,7 +38,7 @@ func main() {
 
 		ki := rpc.WalletKeysInfo{}
 		ki.CreatedAt = 110000
-		ki.SetWalletKeys(utils.WalletKeys{viewKey, spendKey})
+		ki.SetWalletKeys(utils.WalletKeys{ViewSecretKey: viewKey, SpendPublicKey: spendKey})
 
 		if lastHash == nil {
 			req.Params.SetShortChain([]moneroutil.Hash{genesis.GetGenesisBlockInfo("stagenet").Hash})
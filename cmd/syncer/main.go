@@ -9,12 +9,14 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/exantech/monero-fastsync/internal/app/syncer"
 	"github.com/exantech/monero-fastsync/internal/app/syncer/worker"
 	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/migrations"
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 	"github.com/exantech/monero-fastsync/pkg/genesis"
 )
@@ -40,12 +42,32 @@ func main() {
 		return
 	}
 
+	command := flag.Arg(0)
+	switch command {
+	case "":
+	case "import":
+		if flag.NArg() != 2 {
+			log.Fatalf("Usage: %s [options] import <path to blockchain.raw>", os.Args[0])
+		}
+	case "confirm-reorg":
+		if flag.NArg() != 2 {
+			log.Fatalf("Usage: %s [options] confirm-reorg <reorganization id>", os.Args[0])
+		}
+	case "migrate":
+		if flag.NArg() != 2 {
+			log.Fatalf("Usage: %s [options] migrate up|down|status", os.Args[0])
+		}
+	default:
+		flag.Usage()
+		log.Fatalf("Unknown command: %s", command)
+	}
+
 	if len(*configPath) == 0 {
 		flag.Usage()
 		log.Fatalf("Config path is required")
 	}
 
-	var conf syncer.Config
+	conf := syncer.MakeDefaultConfig()
 	if err := utils.ReadYamlConfig(*configPath, &conf); err != nil {
 		log.Fatalf("Couldn't read config file: %s", err.Error())
 	}
@@ -63,21 +85,33 @@ func main() {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	if command == "migrate" {
+		if conf.BlockchainDb.GetDriver() != utils.DriverPostgres {
+			logging.Log.Fatalf("Migrations are applicable to postgres only")
+		}
+
+		migrate(ctx, cancel, sig, conf.BlockchainDb, flag.Arg(1))
+		return
+	}
+
 	logging.Log.Info("Creating new db connection")
 	db, err := worker.NewDbOperator(conf.BlockchainDb)
 	if err != nil {
 		logging.Log.Fatalf("Couldn't make db fetcher: %s", err.Error())
 	}
 
-	node, err := worker.NewNodeFetcher(conf.NodeAddress)
-	if err != nil {
-		logging.Log.Fatalf("Couldn't make node fetcher: %s", err.Error())
+	if command == "confirm-reorg" {
+		confirmReorg(db, flag.Arg(1))
+		return
 	}
 
-	logging.Log.Infof("Using %s network", strings.ToUpper(conf.Network))
+	// new blocks notifications are needed only by the sync loop
+	w, release, err := syncer.MakeWorker(conf, db, command == "")
+	if err != nil {
+		logging.Log.Fatalf("Couldn't make sync worker: %s", err.Error())
+	}
 
-	genesisInfo := genesis.GetGenesisBlockInfo(conf.Network)
-	w := worker.NewWorker(db, node, genesisInfo)
+	defer release()
 
 	logging.Log.Info("Checking genesis block hash")
 	if err = w.CheckGenesis(ctx, *initDb); err != nil {
@@ -91,6 +125,23 @@ func main() {
 		return
 	}
 
+	logging.Log.Info("Checking saved blocks against checkpoints")
+	if err = w.CheckCheckpoints(); err != nil {
+		logging.Log.Fatalf("Error on checking checkpoints: %s. DB contains blockchain other than the network's one, "+
+			"it should be resynchronized", err.Error())
+	}
+
+	if command == "import" {
+		checkpointsInfo, err := syncer.LoadCheckpoints(conf)
+		if err != nil {
+			logging.Log.Fatalf("Couldn't load checkpoints: %s", err.Error())
+		}
+
+		runImport(ctx, cancel, sig, worker.NewImporter(db, genesis.GetGenesisBlockInfo(conf.Network), checkpointsInfo),
+			flag.Arg(1))
+		return
+	}
+
 	logging.Log.Info("Starting sync loop")
 	done := w.RunSyncLoop(ctx)
 
@@ -113,3 +164,94 @@ loop:
 		}
 	}
 }
+
+// Allows the running syncer to apply the reorganization deeper than max_reorg_depth
+func confirmReorg(db worker.DbOperator, idArg string) {
+	id, err := strconv.ParseInt(idArg, 10, 64)
+	if err != nil {
+		logging.Log.Fatalf("Bad reorganization id: %s", idArg)
+	}
+
+	if err = db.ConfirmReorg(id); err != nil {
+		logging.Log.Fatalf("Failed to confirm reorganization: %s", err.Error())
+	}
+
+	logging.Log.Infof("Reorganization %d confirmed, it will be applied on the next syncer's poll", id)
+}
+
+// Applies pending migrations, reverts the last one or shows the migrations
+func migrate(ctx context.Context, cancel context.CancelFunc, sig <-chan os.Signal, settings utils.DbSettings, action string) {
+	db, err := utils.NewDb(settings)
+	if err != nil {
+		logging.Log.Fatalf("Couldn't connect to db: %s", err.Error())
+	}
+
+	defer db.Close()
+
+	go func() {
+		<-sig
+		logging.Log.Info("Interrupting migration")
+		cancel()
+	}()
+
+	switch action {
+	case "up":
+		if err = migrations.Up(ctx, db); err != nil {
+			logging.Log.Fatalf("Migration failed: %s", err.Error())
+		}
+
+		logging.Log.Infof("DB schema is migrated to version %d", migrations.ExpectedVersion())
+	case "down":
+		if err = migrations.Down(ctx, db); err != nil {
+			logging.Log.Fatalf("Migration failed: %s", err.Error())
+		}
+
+		version, err := migrations.GetVersion(ctx, db)
+		if err != nil {
+			logging.Log.Fatalf("Couldn't get DB schema version: %s", err.Error())
+		}
+
+		logging.Log.Infof("DB schema is reverted to version %d", version)
+	case "status":
+		statuses, err := migrations.Status(ctx, db)
+		if err != nil {
+			logging.Log.Fatalf("Couldn't get migrations status: %s", err.Error())
+		}
+
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied at " + s.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%3d %-24s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		logging.Log.Fatalf("Unknown migrate action: %s", action)
+	}
+}
+
+func runImport(ctx context.Context, cancel context.CancelFunc, sig <-chan os.Signal, importer *worker.Importer, path string) {
+	logging.Log.Infof("Importing blocks from %s", path)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- importer.ImportFile(ctx, path)
+	}()
+
+	for {
+		select {
+		case <-sig:
+			logging.Log.Info("Interrupting import")
+			cancel()
+		case err := <-done:
+			if err == utils.ErrInterrupted {
+				logging.Log.Info("Import interrupted, it may be resumed with the same file")
+			} else if err != nil {
+				logging.Log.Fatalf("Import failed: %s", err.Error())
+			}
+
+			return
+		}
+	}
+}
@@ -1,16 +1,25 @@
 module github.com/exantech/monero-fastsync
 
+go 1.27.1
+
 require (
 	github.com/cyberdelia/go-metrics-graphite v0.0.0-20161219230853-39f87cc3b432
-	github.com/ebfe/keccak v0.0.0-20150115210727-5cc570678d1b // indirect
 	github.com/exantech/moneroproto v0.0.0-20191125161008-04b324ee344e
 	github.com/exantech/moneroutil v0.0.0-20181016132018-c9292e639cb7
 	github.com/lib/pq v1.2.0
 	github.com/marpaia/graphite-golang v0.0.0-20190519024811-caf161d2c2b1
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/rcrowley/go-metrics v0.0.0-20190826022208-cac0b30c2563
-	github.com/stretchr/objx v0.2.0 // indirect
 	github.com/stretchr/testify v1.4.0
-	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	go.etcd.io/bbolt v1.3.5
 	gopkg.in/yaml.v2 v2.2.7
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/ebfe/keccak v0.0.0-20150115210727-5cc570678d1b // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.2.0 // indirect
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
+	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+)
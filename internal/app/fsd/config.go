@@ -15,9 +15,15 @@ type Config struct {
 	BlockchainDb  utils.DbSettings `yaml:"blockchain_db"`
 	Network       string           `yaml:"network"`
 	Workers       int              `yaml:"workers"`
+	ScanThreads   int              `yaml:"scan_threads"`
 	ProcessBlocks int              `yaml:"process_blocks"`
 	ResultBlocks  int              `yaml:"result_blocks"`
+	BatchJobs     int              `yaml:"batch_jobs"`
+	BlocksCacheMb int              `yaml:"blocks_cache_mb"`
 	JobLifetime   time.Duration    `yaml:"job_lifetime"`
+	PrescanWindow time.Duration    `yaml:"prescan_window"`
+	PrescanMax    int              `yaml:"prescan_max_wallets"`
+	SyncerConfig  string           `yaml:"syncer_config"` // optional, the syncer runs inside fsd then
 }
 
 type MetricsConfig struct {
@@ -31,7 +37,11 @@ func MakeDefaultConfig() Config {
 		Workers:       10,
 		ProcessBlocks: 2000,
 		ResultBlocks:  1000,
+		BatchJobs:     20,
+		BlocksCacheMb: 256,
 		JobLifetime:   time.Minute,
+		PrescanWindow: 24 * time.Hour,
+		PrescanMax:    1000,
 	}
 }
 
@@ -42,6 +52,10 @@ func (c *Config) Validate() error {
 		return errors.New(fmt.Sprintf("unknown network: %s", c.Network))
 	}
 
+	if err := c.BlockchainDb.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("blockchain_db: %s", err.Error()))
+	}
+
 	return nil
 }
 
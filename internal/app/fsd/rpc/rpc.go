@@ -24,9 +24,11 @@ type WalletChainInfoV1 struct {
 }
 
 type WalletKeysInfo struct {
-	ViewSecretKey  []byte `monerobinkv:"view_secret_key"`
-	SpendPublicKey []byte `monerobinkv:"spend_public_key"`
-	CreatedAt      uint64 `monerobinkv:"created_at"`
+	ViewSecretKey   []byte `monerobinkv:"view_secret_key"`
+	SpendPublicKey  []byte `monerobinkv:"spend_public_key"`
+	CreatedAt       uint64 `monerobinkv:"created_at"`
+	SubaddressMajor uint32 `monerobinkv:"subaddress_major"` // subaddress accounts lookahead
+	SubaddressMinor uint32 `monerobinkv:"subaddress_minor"` // subaddresses per account lookahead
 }
 
 func (w *WalletKeysInfo) GetWalletKeys() (utils.WalletKeys, error) {
@@ -44,6 +46,18 @@ func (w *WalletKeysInfo) GetWalletKeys() (utils.WalletKeys, error) {
 	return res, err
 }
 
+func (w *WalletKeysInfo) GetLookahead() utils.SubaddressLookahead {
+	return utils.SubaddressLookahead{
+		Major: w.SubaddressMajor,
+		Minor: w.SubaddressMinor,
+	}
+}
+
+func (w *WalletKeysInfo) SetLookahead(lookahead utils.SubaddressLookahead) {
+	w.SubaddressMajor = lookahead.Major
+	w.SubaddressMinor = lookahead.Minor
+}
+
 func (w *WalletKeysInfo) SetWalletKeys(keys utils.WalletKeys) {
 	w.ViewSecretKey = keys.ViewSecretKey.Serialize()
 	w.SpendPublicKey = keys.SpendPublicKey.Serialize()
@@ -85,9 +99,18 @@ func (g *GetMyBlocksResponse) SetStatus(status string) {
 }
 
 type WalletBlocksResult struct {
-	StartHeight uint64            `monerobinkv:"start_height"`
-	TotalHeight uint64            `monerobinkv:"total_height"`
-	Blocks      []WalletBlockInfo `monerobinkv:"blocks"`
+	StartHeight uint64                `monerobinkv:"start_height"`
+	TotalHeight uint64                `monerobinkv:"total_height"`
+	Blocks      []WalletBlockInfo     `monerobinkv:"blocks"`
+	Accounts    []AccountBlocksResult `monerobinkv:"accounts"`
+}
+
+// Blocks of a single account when more than one key was requested.
+// Accounts go in the same order as keys in the request
+type AccountBlocksResult struct {
+	StartHeight   uint64            `monerobinkv:"start_height"`
+	ScannedHeight uint64            `monerobinkv:"scanned_height"`
+	Blocks        []WalletBlockInfo `monerobinkv:"blocks"`
 }
 
 type WalletBlockInfo struct {
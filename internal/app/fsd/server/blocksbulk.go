@@ -58,6 +58,25 @@ func (l *BlocksBulkList) TrimBlocks(topHeight uint64) {
 	}
 }
 
+// Removes blocks from the height, the list still starts where it did even if all of its blocks are gone
+func (l *BlocksBulkList) InvalidateFrom(height uint64) {
+	e := l.l.Front()
+	if e == nil {
+		return
+	}
+
+	start := e.Value.(*blocksBulk).start
+	if height == 0 {
+		l.l.Init()
+	} else {
+		l.TrimBlocks(height - 1)
+	}
+
+	if l.l.Len() == 0 {
+		l.AddBlocks(start, []*WalletBlock{})
+	}
+}
+
 func (l *BlocksBulkList) BlocksAvailable(start uint64) int {
 	var e *list.Element
 	for e = l.l.Front(); e != nil; e = e.Next() {
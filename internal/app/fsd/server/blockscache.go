@@ -0,0 +1,220 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+)
+
+const (
+	cacheChunkSize = 100
+	// rough per-item overhead of slices and structs
+	cacheItemOverhead = 64
+)
+
+// Receives notifications about blockchain changes
+type ChainListener interface {
+	// Called when blocks starting from the height were added, replaced or removed
+	InvalidateFrom(height uint64)
+}
+
+// BlocksCache is a DbWorker which keeps recently requested preparsed blocks in memory.
+// Blocks are stored in chunks of cacheChunkSize blocks keyed by the first chunk's height.
+// The chunk containing the top block may be incomplete, it is dropped as soon as the top moves.
+type BlocksCache struct {
+	DbWorker
+	lock       *sync.Mutex
+	chunks     map[uint64]*list.Element
+	lru        *list.List
+	size       int
+	maxSize    int
+	generation uint64 // incremented on every invalidation
+}
+
+type cachedChunk struct {
+	start  uint64
+	blocks []PreparsedBlock
+	size   int
+}
+
+func NewBlocksCache(db DbWorker, maxSize int) *BlocksCache {
+	return &BlocksCache{
+		DbWorker: db,
+		lock:     new(sync.Mutex),
+		chunks:   make(map[uint64]*list.Element),
+		lru:      list.New(),
+		maxSize:  maxSize,
+	}
+}
+
+func (c *BlocksCache) GetBlocksAbove(startHeight uint64, maxCount int) ([]PreparsedBlock, error) {
+	if maxCount <= 0 {
+		return []PreparsedBlock{}, nil
+	}
+
+	endHeight := startHeight + uint64(maxCount)
+	firstChunk := chunkStart(startHeight)
+
+	chunks, generation := c.getChunks(firstChunk, endHeight)
+
+	// load missing chunks, adjacent ones are loaded by a single query
+	for i := 0; i < len(chunks); {
+		if chunks[i] != nil {
+			i++
+			continue
+		}
+
+		from := firstChunk + uint64(i)*cacheChunkSize
+		count := 1
+		for i+count < len(chunks) && chunks[i+count] == nil {
+			count++
+		}
+
+		loaded, err := c.loadChunks(from, count, generation)
+		if err != nil {
+			return nil, err
+		}
+
+		copy(chunks[i:], loaded)
+		i += count
+	}
+
+	res := make([]PreparsedBlock, 0, maxCount)
+	for _, chunk := range chunks {
+		for _, block := range chunk.blocks {
+			if block.Height >= startHeight && block.Height < endHeight {
+				res = append(res, block)
+			}
+		}
+
+		if len(chunk.blocks) < cacheChunkSize {
+			// top reached
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (c *BlocksCache) InvalidateFrom(height uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+
+	removed := 0
+	for start, e := range c.chunks {
+		if start+cacheChunkSize <= height {
+			continue
+		}
+
+		c.size -= e.Value.(*cachedChunk).size
+		c.lru.Remove(e)
+		delete(c.chunks, start)
+		removed++
+	}
+
+	logging.Log.Debugf("Blocks cache invalidated from height %d, %d chunks removed", height, removed)
+}
+
+// Returns cached chunks covering heights from the first chunk till the end height, nil for missing ones
+func (c *BlocksCache) getChunks(firstChunk uint64, endHeight uint64) ([]*cachedChunk, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	chunks := make([]*cachedChunk, 0, (endHeight-firstChunk+cacheChunkSize-1)/cacheChunkSize)
+	for start := firstChunk; start < endHeight; start += cacheChunkSize {
+		e, ok := c.chunks[start]
+		if !ok {
+			chunks = append(chunks, nil)
+			continue
+		}
+
+		c.lru.MoveToFront(e)
+		chunk := e.Value.(*cachedChunk)
+		chunks = append(chunks, chunk)
+
+		if len(chunk.blocks) < cacheChunkSize {
+			// there are no blocks above the top
+			break
+		}
+	}
+
+	return chunks, c.generation
+}
+
+func (c *BlocksCache) loadChunks(from uint64, count int, generation uint64) ([]*cachedChunk, error) {
+	blocks, err := c.DbWorker.GetBlocksAbove(from, count*cacheChunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]*cachedChunk, count)
+	for i := range chunks {
+		chunks[i] = &cachedChunk{
+			start:  from + uint64(i)*cacheChunkSize,
+			blocks: make([]PreparsedBlock, 0, cacheChunkSize),
+		}
+	}
+
+	for _, block := range blocks {
+		chunk := chunks[(block.Height-from)/cacheChunkSize]
+		chunk.blocks = append(chunk.blocks, block)
+		chunk.size += estimateBlockSize(&block)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// the blockchain has changed while loading, the chunks may be stale
+	if generation != c.generation {
+		return chunks, nil
+	}
+
+	for _, chunk := range chunks {
+		c.put(chunk)
+	}
+
+	return chunks, nil
+}
+
+// must be locked from outside
+func (c *BlocksCache) put(chunk *cachedChunk) {
+	if len(chunk.blocks) == 0 || chunk.size > c.maxSize {
+		return
+	}
+
+	if e, ok := c.chunks[chunk.start]; ok {
+		c.size -= e.Value.(*cachedChunk).size
+		c.lru.Remove(e)
+	}
+
+	for c.size+chunk.size > c.maxSize {
+		oldest := c.lru.Back()
+		evicted := oldest.Value.(*cachedChunk)
+
+		c.size -= evicted.size
+		c.lru.Remove(oldest)
+		delete(c.chunks, evicted.start)
+	}
+
+	c.chunks[chunk.start] = c.lru.PushFront(chunk)
+	c.size += chunk.size
+}
+
+func chunkStart(height uint64) uint64 {
+	return height - height%cacheChunkSize
+}
+
+func estimateBlockSize(block *PreparsedBlock) int {
+	size := cacheItemOverhead + len(block.Header) + len(block.Hash)
+	for _, tx := range block.Txs {
+		size += cacheItemOverhead + len(tx.Hash) + len(tx.Blob) + len(tx.ViewTags)
+		size += len(tx.OutputKeys) * 32
+		size += (len(tx.PubKeys) + len(tx.AdditionalPubKeys)) * 32
+		size += (len(tx.OutputIndices) + len(tx.UsedInputs)) * 8
+	}
+
+	return size
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testBlocksDb struct {
+	DbWorker
+	top     uint64
+	queries int
+}
+
+func (d *testBlocksDb) GetBlocksAbove(startHeight uint64, maxCount int) ([]PreparsedBlock, error) {
+	d.queries++
+
+	res := make([]PreparsedBlock, 0, maxCount)
+	for h := startHeight; h < startHeight+uint64(maxCount) && h <= d.top; h++ {
+		res = append(res, PreparsedBlock{
+			BlockEntry: BlockEntry{Height: h, Header: make([]byte, 10)},
+			Txs:        []PreparsedTx{{Blob: make([]byte, 100)}},
+		})
+	}
+
+	return res, nil
+}
+
+func TestBlocksCacheHit(t *testing.T) {
+	db := &testBlocksDb{top: 1000}
+	cache := NewBlocksCache(db, 1024*1024)
+
+	blocks, err := cache.GetBlocksAbove(150, 200)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, len(blocks))
+	assert.Equal(t, uint64(150), blocks[0].Height)
+	assert.Equal(t, uint64(349), blocks[199].Height)
+	assert.Equal(t, 1, db.queries)
+
+	blocks, err = cache.GetBlocksAbove(120, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, len(blocks))
+	assert.Equal(t, uint64(120), blocks[0].Height)
+	assert.Equal(t, 1, db.queries)
+
+	// only the missing chunks are loaded
+	blocks, err = cache.GetBlocksAbove(300, 200)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, len(blocks))
+	assert.Equal(t, uint64(300), blocks[0].Height)
+	assert.Equal(t, uint64(499), blocks[199].Height)
+	assert.Equal(t, 2, db.queries)
+}
+
+func TestBlocksCacheTop(t *testing.T) {
+	db := &testBlocksDb{top: 1049}
+	cache := NewBlocksCache(db, 1024*1024)
+
+	blocks, _ := cache.GetBlocksAbove(1000, 100)
+	assert.Equal(t, 50, len(blocks))
+	assert.Equal(t, 1, db.queries)
+
+	blocks, _ = cache.GetBlocksAbove(1040, 100)
+	assert.Equal(t, 10, len(blocks))
+	assert.Equal(t, 1, db.queries)
+
+	db.top = 1050
+	cache.InvalidateFrom(1050)
+
+	blocks, _ = cache.GetBlocksAbove(1040, 100)
+	assert.Equal(t, 11, len(blocks))
+	assert.Equal(t, uint64(1050), blocks[10].Height)
+	assert.Equal(t, 2, db.queries)
+}
+
+func TestBlocksCacheInvalidate(t *testing.T) {
+	db := &testBlocksDb{top: 1000}
+	cache := NewBlocksCache(db, 1024*1024)
+
+	cache.GetBlocksAbove(0, 300)
+	assert.Equal(t, 3, len(cache.chunks))
+
+	cache.InvalidateFrom(150)
+	assert.Equal(t, 1, len(cache.chunks))
+
+	blocks, _ := cache.GetBlocksAbove(0, 300)
+	assert.Equal(t, []uint64{0, 299}, []uint64{blocks[0].Height, blocks[299].Height})
+	assert.Equal(t, 2, db.queries)
+
+	cache.InvalidateFrom(0)
+	assert.Equal(t, 0, len(cache.chunks))
+	assert.Equal(t, 0, cache.size)
+}
+
+func TestBlocksCacheEviction(t *testing.T) {
+	db := &testBlocksDb{top: 1000}
+
+	blocks, _ := db.GetBlocksAbove(0, cacheChunkSize)
+	chunkSize := 0
+	for i := range blocks {
+		chunkSize += estimateBlockSize(&blocks[i])
+	}
+
+	cache := NewBlocksCache(db, chunkSize*2)
+	cache.GetBlocksAbove(0, 100)
+	cache.GetBlocksAbove(100, 100)
+	cache.GetBlocksAbove(0, 100) // chunk 0 becomes the most recent one
+	cache.GetBlocksAbove(200, 100)
+
+	assert.Equal(t, 2, len(cache.chunks))
+	assert.Contains(t, cache.chunks, uint64(0))
+	assert.Contains(t, cache.chunks, uint64(200))
+	assert.Equal(t, chunkSize*2, cache.size)
+}
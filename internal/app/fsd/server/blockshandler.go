@@ -8,6 +8,13 @@ import (
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 )
 
+const (
+	// default wallet's lookahead is 50 x 200, larger tables are too expensive to build on request
+	maxSubaddressLookahead = 50 * 200
+	// monero's short chain is logarithmic, so even a huge chain is far below this
+	maxShortChainLength = 1000
+)
+
 var (
 	ErrRequestError  = errors.New("request error")
 	ErrInternalError = errors.New("internal error")
@@ -38,9 +45,11 @@ func (b *BlocksHandler) HandleGetBlocks(req *rpc.WalletChainInfoV1) (*rpc.Wallet
 		return nil, ErrRequestError
 	}
 
-	if len(accounts) > 1 {
-		logging.Log.Error("More than one key is not supported yet")
-		return nil, ErrRequestError
+	for _, a := range accounts {
+		if uint64(a.Lookahead.Major)*uint64(a.Lookahead.Minor) > maxSubaddressLookahead {
+			logging.Log.Errorf("Too big subaddress lookahead: %d x %d", a.Lookahead.Major, a.Lookahead.Minor)
+			return nil, ErrRequestError
+		}
 	}
 
 	chain, err := req.GetShortChain()
@@ -54,27 +63,46 @@ func (b *BlocksHandler) HandleGetBlocks(req *rpc.WalletChainInfoV1) (*rpc.Wallet
 		return nil, ErrRequestError
 	}
 
+	if len(chain) > maxShortChainLength {
+		logging.Log.Errorf("Too long short chain: %d", len(chain))
+		return nil, ErrRequestError
+	}
+
 	common, err := b.dbWorker.GetChainIntersection(chain)
 	if err != nil {
 		logging.Log.Errorf("Failed to get common block: %s", err.Error())
 		return nil, ErrInternalError
 	}
 
-	progress, err := b.dbWorker.GetOrCreateKeyProgress(accounts[0])
-	if err != nil {
-		logging.Log.Errorf("Failed to get wallets progress: %s", err.Error())
-		return nil, ErrInternalError
+	wallets := make([]utils.WalletEntry, 0, len(accounts))
+	for _, account := range accounts {
+		progress, err := b.dbWorker.GetOrCreateKeyProgress(account)
+		if err != nil {
+			logging.Log.Errorf("Failed to get wallets progress: %s", err.Error())
+			return nil, ErrInternalError
+		}
+
+		wallets = append(wallets, progress)
 	}
 
-	listener := b.queue.AddJob(progress, common.Height)
+	listeners := b.queue.AddJobs(wallets, common.Height)
 
-	blocks, err := listener.Wait()
-	if err != nil {
-		logging.Log.Errorf("Failed to get wallet's blocks: %s", err.Error())
-		return nil, ErrInternalError
-	}
+	results := make([]rpc.AccountBlocksResult, 0, len(listeners))
+	for _, listener := range listeners {
+		blocks, err := listener.Wait()
+		if err != nil {
+			logging.Log.Errorf("Failed to get wallet's blocks: %s", err.Error())
+			return nil, ErrInternalError
+		}
+
+		logging.Log.Infof("Processed %d blocks", len(blocks))
 
-	logging.Log.Infof("Processed %d blocks", len(blocks))
+		results = append(results, rpc.AccountBlocksResult{
+			StartHeight:   common.Height,
+			ScannedHeight: listener.ScannedHeight(),
+			Blocks:        convertWalletBlocks(blocks),
+		})
+	}
 
 	topHeight, err := b.dbWorker.GetTopBlockHeight()
 	if err != nil {
@@ -82,22 +110,32 @@ func (b *BlocksHandler) HandleGetBlocks(req *rpc.WalletChainInfoV1) (*rpc.Wallet
 		return nil, ErrInternalError
 	}
 
+	// the first account is returned in the top level fields for compatibility with single account clients
 	res := &rpc.WalletBlocksResult{
 		StartHeight: common.Height,
 		TotalHeight: topHeight,
-		Blocks:      make([]rpc.WalletBlockInfo, len(blocks)),
+		Blocks:      results[0].Blocks,
 	}
 
+	if len(results) > 1 {
+		res.Accounts = results
+	}
+
+	return res, nil
+}
+
+func convertWalletBlocks(blocks []*WalletBlock) []rpc.WalletBlockInfo {
+	res := make([]rpc.WalletBlockInfo, len(blocks))
 	for i := range blocks {
-		res.Blocks[i].Hash = blocks[i].Hash.Serialize()
-		res.Blocks[i].SetOutputIndices(blocks[i].OutputIndices)
-		res.Blocks[i].Timestamp = blocks[i].Timestamp
+		res[i].Hash = blocks[i].Hash.Serialize()
+		res[i].SetOutputIndices(blocks[i].OutputIndices)
+		res[i].Timestamp = blocks[i].Timestamp
 		if blocks[i].Bce != nil {
-			res.Blocks[i].Bce = *blocks[i].Bce
+			res[i].Bce = *blocks[i].Bce
 		}
 	}
 
-	return res, nil
+	return res
 }
 
 func accountsInfoFromWalletKeysInfo(ws []rpc.WalletKeysInfo) ([]utils.AccountInfo, error) {
@@ -112,6 +150,7 @@ func accountsInfoFromWalletKeysInfo(ws []rpc.WalletKeysInfo) ([]utils.AccountInf
 		}
 
 		a.CreatedAt = w.CreatedAt
+		a.Lookahead = w.GetLookahead()
 		res = append(res, a)
 	}
 
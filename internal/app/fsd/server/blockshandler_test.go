@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/app/fsd/rpc"
+)
+
+// recordingDriver is a database/sql driver which remembers every statement with its arguments
+// and returns no rows, so it's seen whether a request reaches the database. Queries themselves
+// are checked against real postgres in dbworker_pg_test.go
+type recordingDriver struct {
+	lock    sync.Mutex
+	queries []recordedQuery
+}
+
+type recordedQuery struct {
+	query string
+	args  []driver.Value
+}
+
+var (
+	testRecorder     = &recordingDriver{}
+	registerRecorder sync.Once
+)
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{driver: d}, nil
+}
+
+func (d *recordingDriver) record(query string, args []driver.Value) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.queries = append(d.queries, recordedQuery{query: query, args: args})
+}
+
+func (d *recordingDriver) takeQueries() []recordedQuery {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	res := d.queries
+	d.queries = nil
+	return res
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{driver: c.driver, query: query}, nil
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return &recordingTx{}, nil
+}
+
+// WalletsDb starts transactions with explicit isolation level
+func (c *recordingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return &recordingTx{}, nil
+}
+
+type recordingTx struct{}
+
+func (t *recordingTx) Commit() error {
+	return nil
+}
+
+func (t *recordingTx) Rollback() error {
+	return nil
+}
+
+type recordingStmt struct {
+	driver *recordingDriver
+	query  string
+}
+
+func (s *recordingStmt) Close() error {
+	return nil
+}
+
+func (s *recordingStmt) NumInput() int {
+	return -1
+}
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.driver.record(s.query, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.driver.record(s.query, args)
+	return &emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (r *emptyRows) Columns() []string {
+	return []string{"height", "hash"}
+}
+
+func (r *emptyRows) Close() error {
+	return nil
+}
+
+func (r *emptyRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
+
+func newRecordingWalletsDb(t *testing.T) *WalletsDb {
+	registerRecorder.Do(func() {
+		sql.Register("fsd-recording", testRecorder)
+	})
+
+	db, err := sql.Open("fsd-recording", "")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	testRecorder.takeQueries()
+	return &WalletsDb{db: db}
+}
+
+func makeHostileHash(s string) moneroutil.Hash {
+	h := moneroutil.Hash{}
+	copy(h[:], s)
+	return h
+}
+
+func makeTestChainRequest(chain []moneroutil.Hash) *rpc.WalletChainInfoV1 {
+	buf := bytes.NewBuffer(nil)
+	for _, h := range chain {
+		buf.Write(h[:])
+	}
+
+	return makeTestRawChainRequest(buf.Bytes())
+}
+
+func makeTestRawChainRequest(shortChain []byte) *rpc.WalletChainInfoV1 {
+	return &rpc.WalletChainInfoV1{
+		Keys: []rpc.WalletKeysInfo{{
+			ViewSecretKey:  make([]byte, 32),
+			SpendPublicKey: make([]byte, 32),
+		}},
+		ShortChain: shortChain,
+	}
+}
+
+func hostileShortChain() []moneroutil.Hash {
+	return []moneroutil.Hash{
+		makeHostileHash("'); DROP TABLE blocks; --"),
+		makeHostileHash("' OR '1'='1"),
+		makeHostileHash("\x00\\'\"$1::text[]"),
+		makeHostileHash("'); DROP TABLE blocks; --"),
+	}
+}
+
+func TestHandleGetBlocksMalformedShortChain(t *testing.T) {
+	db := newRecordingWalletsDb(t)
+	handler := NewBlocksHandler(db, nil)
+
+	tooLong := make([]moneroutil.Hash, maxShortChainLength+1)
+	for i := range tooLong {
+		tooLong[i] = makeHostileHash("'")
+	}
+
+	requests := []*rpc.WalletChainInfoV1{
+		makeTestRawChainRequest(nil),
+		makeTestRawChainRequest([]byte("'); DROP TABLE blocks; --")),
+		makeTestRawChainRequest(append(make([]byte, 32), '\'')),
+		makeTestChainRequest(tooLong),
+	}
+
+	for _, req := range requests {
+		_, err := handler.HandleGetBlocks(req)
+		assert.Equal(t, ErrRequestError, err)
+	}
+
+	assert.Empty(t, testRecorder.takeQueries())
+}
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/exantech/moneroutil"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/boltdb"
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+// Embedded storage for single-node deployments, the syncer writes the same file with its BoltOperator
+type BoltWorker struct {
+	store *boltdb.Store
+}
+
+func NewBoltWorker(path string) (*BoltWorker, error) {
+	store, err := boltdb.NewStore(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltWorker{
+		store: store,
+	}, nil
+}
+
+func (w *BoltWorker) GetChainIntersection(chain []moneroutil.Hash) (utils.HeightInfo, error) {
+	hi := utils.HeightInfo{}
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		// the first known hash of the chain is taken, not the highest one, like postgres implementation does
+		for _, hash := range chain {
+			if height, ok := boltdb.GetBlockHeight(tx, hash); ok {
+				hi.Height = height
+				hi.Hash = hash
+				return nil
+			}
+		}
+
+		return boltdb.ErrNotFound
+	})
+
+	return hi, err
+}
+
+func (w *BoltWorker) GetTopBlockHeight() (uint64, error) {
+	var height uint64
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		var ok bool
+		if height, ok = boltdb.TopHeight(tx); !ok {
+			return boltdb.ErrNotFound
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to get top block height: %s", err.Error())
+		return 0, err
+	}
+
+	return height, nil
+}
+
+func (w *BoltWorker) GetBlocksAbove(startHeight uint64, maxCount int) ([]PreparsedBlock, error) {
+	blocks := make([]PreparsedBlock, 0, maxCount)
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		// blocks without transactions are skipped, like the join in postgres implementation does
+		return boltdb.ForEachTransaction(tx, startHeight, startHeight+uint64(maxCount),
+			func(height uint64, t boltdb.Transaction) error {
+				if len(blocks) == 0 || blocks[len(blocks)-1].Height != height {
+					b, err := boltdb.GetBlock(tx, height)
+					if err != nil {
+						return err
+					}
+
+					blocks = append(blocks, PreparsedBlock{
+						BlockEntry: BlockEntry{
+							Height: height,
+							Header: b.Header,
+							Hash:   b.Hash,
+						},
+						Txs: []PreparsedTx{},
+					})
+				}
+
+				ptx := PreparsedTx{
+					Hash:          t.Hash,
+					Blob:          t.Blob,
+					OutputKeys:    t.OutputKeys,
+					ViewTags:      t.ViewTags,
+					OutputIndices: t.OutputIndices,
+					UsedInputs:    t.UsedInputs,
+				}
+
+				if t.HasPubKeys {
+					ptx.PubKeys = nonNilKeys(t.PubKeys)
+					ptx.AdditionalPubKeys = nonNilKeys(t.AdditionalPubKeys)
+				}
+
+				if ptx.OutputKeys == nil {
+					ptx.OutputKeys = []moneroutil.Key{}
+				}
+
+				if ptx.OutputIndices == nil {
+					ptx.OutputIndices = []uint64{}
+				}
+
+				if ptx.UsedInputs == nil {
+					ptx.UsedInputs = []uint64{}
+				}
+
+				blocks[len(blocks)-1].Txs = append(blocks[len(blocks)-1].Txs, ptx)
+				return nil
+			})
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to read blocks above %d: %s", startHeight, err.Error())
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+func (w *BoltWorker) GetBlockEntry(height uint64) (BlockEntry, error) {
+	be := BlockEntry{}
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		b, err := boltdb.GetBlock(tx, height)
+		if err != nil {
+			return err
+		}
+
+		be.Height = height
+		be.Hash = b.Hash
+		be.Header = b.Header
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to get block entry at height %d: %s", height, err.Error())
+		return be, err
+	}
+
+	return be, nil
+}
+
+func (w *BoltWorker) GetWalletBlocks(walletId uint32, startHeight uint64, maxBlocks int) ([]PreSerializedBlock, error) {
+	blocks := make([]PreSerializedBlock, 0, maxBlocks)
+	endHeight := startHeight + uint64(maxBlocks)
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		walletBlocks := tx.Bucket(boltdb.WalletBlocksBucket)
+		txs := tx.Bucket(boltdb.TransactionsBucket).Cursor()
+
+		c := tx.Bucket(boltdb.BlocksBucket).Cursor()
+		for k, v := c.Seek(boltdb.Uint64Key(startHeight)); k != nil; k, v = c.Next() {
+			height := binary.BigEndian.Uint64(k)
+			if height >= endHeight {
+				break
+			}
+
+			var b boltdb.Block
+			if err := boltdb.Decode(v, &b); err != nil {
+				return err
+			}
+
+			block := PreSerializedBlock{
+				Height: height,
+				Hash:   b.Hash,
+				Txs:    []ExtSerializedTx{},
+			}
+
+			// only blocks with the wallet's outputs or inputs are returned with their contents
+			if walletBlocks.Get(boltdb.WalletBlockKey(walletId, height)) != nil {
+				block.Header = b.Header
+
+				prefix := boltdb.Uint64Key(height)
+				for tk, tv := txs.Seek(prefix); tk != nil && binary.BigEndian.Uint64(tk) == height; tk, tv = txs.Next() {
+					var t boltdb.Transaction
+					if err := boltdb.Decode(tv, &t); err != nil {
+						return err
+					}
+
+					indices := t.OutputIndices
+					if indices == nil {
+						indices = []uint64{}
+					}
+
+					block.Txs = append(block.Txs, ExtSerializedTx{
+						Hash:          t.Hash,
+						Blob:          t.Blob,
+						OutputIndices: indices,
+					})
+				}
+			}
+
+			blocks = append(blocks, block)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to read wallet blocks: %s", err.Error())
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+func (w *BoltWorker) GetWalletOutputs(walletId uint32) ([]OutputHeight, error) {
+	outputs := make([]OutputHeight, 0, 200)
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		prefix := boltdb.Uint32Key(walletId)
+
+		c := tx.Bucket(boltdb.WalletOutputsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && binary.BigEndian.Uint32(k) == walletId; k, v = c.Next() {
+			var wo boltdb.WalletOutput
+			if err := boltdb.Decode(v, &wo); err != nil {
+				return err
+			}
+
+			outputs = append(outputs, OutputHeight{
+				OutputIndex: binary.BigEndian.Uint64(k[len(prefix):]),
+				Height:      wo.Height,
+				Subaddress:  wo.Subaddress,
+			})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to read wallet's outputs: %s", err.Error())
+		return nil, err
+	}
+
+	return outputs, nil
+}
+
+func (w *BoltWorker) SaveWalletBlocks(walletId uint32, blocks []moneroutil.Hash, outputs []OutputHeight) error {
+	err := w.store.Update(func(tx *bolt.Tx) error {
+		walletBlocks := tx.Bucket(boltdb.WalletBlocksBucket)
+		for _, hash := range blocks {
+			height, ok := boltdb.GetBlockHeight(tx, hash)
+			if !ok {
+				continue
+			}
+
+			if err := walletBlocks.Put(boltdb.WalletBlockKey(walletId, height), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		walletOutputs := tx.Bucket(boltdb.WalletOutputsBucket)
+		heights := tx.Bucket(boltdb.OutputsHeightsBucket)
+		for _, o := range outputs {
+			data, err := boltdb.Encode(boltdb.WalletOutput{Height: o.Height, Subaddress: o.Subaddress})
+			if err != nil {
+				return err
+			}
+
+			if err = walletOutputs.Put(boltdb.WalletOutputKey(walletId, o.OutputIndex), data); err != nil {
+				return err
+			}
+
+			if err = heights.Put(boltdb.OutputHeightKey(o.Height, walletId, o.OutputIndex), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to save wallet's blocks and outputs: %s", err.Error())
+		return err
+	}
+
+	logging.Log.Debugf("Saved %d outputs for wallet %d", len(outputs), walletId)
+	return nil
+}
+
+func (w *BoltWorker) SaveWalletProgress(walletId uint32, hash moneroutil.Hash) error {
+	err := w.store.Update(func(tx *bolt.Tx) error {
+		height, ok := boltdb.GetBlockHeight(tx, hash)
+		if !ok {
+			// the block was trimmed meanwhile
+			return nil
+		}
+
+		wallet, err := boltdb.GetWallet(tx, walletId)
+		if err != nil {
+			return err
+		}
+
+		wallet.HasProgress = true
+		wallet.ScannedHeight = height
+		return boltdb.PutWallet(tx, walletId, wallet)
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to update wallet's progress: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (w *BoltWorker) GetTopScannedHeightInfo(walletId uint32) (utils.HeightInfo, error) {
+	res := utils.HeightInfo{}
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		wallet, err := boltdb.GetWallet(tx, walletId)
+		if err != nil {
+			return err
+		}
+
+		if !wallet.HasProgress {
+			return boltdb.ErrNotFound
+		}
+
+		b, err := boltdb.GetBlock(tx, wallet.ScannedHeight)
+		if err != nil {
+			return err
+		}
+
+		res.Height = wallet.ScannedHeight
+		res.Hash = b.Hash
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to get top scanned height info: %s", err.Error())
+		return res, err
+	}
+
+	return res, nil
+}
+
+func (w *BoltWorker) GetOrCreateKeyProgress(account utils.AccountInfo) (utils.WalletEntry, error) {
+	res := utils.WalletEntry{
+		Keys:      account.Keys,
+		Lookahead: account.Lookahead,
+	}
+
+	err := w.store.Update(func(tx *bolt.Tx) error {
+		walletKeys := tx.Bucket(boltdb.WalletKeysBucket)
+		keysKey := boltdb.WalletKeysKey(account.Keys)
+
+		if v := walletKeys.Get(keysKey); v != nil {
+			res.Id = binary.BigEndian.Uint32(v)
+
+			wallet, err := boltdb.GetWallet(tx, res.Id)
+			if err != nil {
+				return err
+			}
+
+			if !wallet.HasProgress {
+				return boltdb.ErrNotFound
+			}
+
+			res.ScannedHeight = wallet.ScannedHeight
+
+			if !wallet.Lookahead.Covers(account.Lookahead) {
+				// scanned blocks may have outputs of the new subaddresses
+				if err = resetBoltWalletProgress(tx, res.Id); err != nil {
+					return err
+				}
+
+				logging.Log.Infof("Subaddress lookahead of wallet %d grew from %d x %d to %d x %d, rescanning from %d",
+					res.Id, wallet.Lookahead.Major, wallet.Lookahead.Minor, account.Lookahead.Major, account.Lookahead.Minor,
+					wallet.CreatedAt)
+				wallet.ScannedHeight = wallet.CreatedAt
+				res.ScannedHeight = wallet.CreatedAt
+			}
+
+			wallet.Lookahead = account.Lookahead
+			wallet.LastRequestAt = time.Now().Unix()
+			return boltdb.PutWallet(tx, res.Id, wallet)
+		}
+
+		res.ScannedHeight = account.CreatedAt
+
+		// the wallet is created only if its creation block is known, like postgres implementation does
+		if _, err := boltdb.GetBlock(tx, account.CreatedAt); err != nil {
+			logging.Log.Errorf("Couldn't insert wallet: no block at height %d", account.CreatedAt)
+			return nil
+		}
+
+		seq, err := tx.Bucket(boltdb.WalletsBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+
+		res.Id = uint32(seq)
+		wallet := boltdb.Wallet{
+			Keys:          account.Keys,
+			CreatedAt:     account.CreatedAt,
+			Lookahead:     account.Lookahead,
+			LastRequestAt: time.Now().Unix(),
+			HasProgress:   true,
+			ScannedHeight: account.CreatedAt,
+		}
+
+		if err = boltdb.PutWallet(tx, res.Id, wallet); err != nil {
+			return err
+		}
+
+		return walletKeys.Put(keysKey, boltdb.Uint32Key(res.Id))
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to get or create wallet (%s, %s): %s",
+			account.Keys.ViewSecretKey.String(), account.Keys.SpendPublicKey.String(), err.Error())
+		return res, err
+	}
+
+	return res, nil
+}
+
+// Forgets wallet's found blocks and outputs
+func resetBoltWalletProgress(tx *bolt.Tx, walletId uint32) error {
+	prefix := boltdb.Uint32Key(walletId)
+
+	c := tx.Bucket(boltdb.WalletBlocksBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	heights := tx.Bucket(boltdb.OutputsHeightsBucket)
+	c = tx.Bucket(boltdb.WalletOutputsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Seek(prefix) {
+		var o boltdb.WalletOutput
+		if err := boltdb.Decode(v, &o); err != nil {
+			return err
+		}
+
+		if err := heights.Delete(boltdb.OutputHeightKey(o.Height, walletId, binary.BigEndian.Uint64(k[4:]))); err != nil {
+			return err
+		}
+
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns wallets requested since the given time, most recent first
+func (w *BoltWorker) GetRecentWallets(since time.Time, maxCount int) ([]utils.WalletEntry, error) {
+	type recentWallet struct {
+		entry       utils.WalletEntry
+		requestedAt int64
+	}
+
+	recent := make([]recentWallet, 0, maxCount)
+
+	err := w.store.View(func(tx *bolt.Tx) error {
+		return boltdb.ForEachWallet(tx, func(id uint32, wallet boltdb.Wallet) error {
+			if wallet.LastRequestAt < since.Unix() {
+				return nil
+			}
+
+			if !wallet.HasProgress {
+				// progress block was removed by chain split, the wallet will be rescanned on request
+				return nil
+			}
+
+			recent = append(recent, recentWallet{
+				entry: utils.WalletEntry{
+					Id:            id,
+					Keys:          wallet.Keys,
+					ScannedHeight: wallet.ScannedHeight,
+					Lookahead:     wallet.Lookahead,
+				},
+				requestedAt: wallet.LastRequestAt,
+			})
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to query recent wallets: %s", err.Error())
+		return nil, err
+	}
+
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].requestedAt > recent[j].requestedAt
+	})
+
+	res := make([]utils.WalletEntry, 0, maxCount)
+	for i := 0; i < len(recent) && i < maxCount; i++ {
+		res = append(res, recent[i].entry)
+	}
+
+	return res, nil
+}
+
+func nonNilKeys(keys []moneroutil.Key) []moneroutil.Key {
+	if keys == nil {
+		return []moneroutil.Key{}
+	}
+
+	return keys
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/boltdb"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+// Makes the worker on a temporary file with blocks up to the height, every odd block has a transaction
+func newTestBoltWorker(t *testing.T, height uint64) (*BoltWorker, func()) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewBoltWorker(filepath.Join(dir, "blockchain.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	err = w.store.Update(func(tx *bolt.Tx) error {
+		for h := uint64(0); h < height; h++ {
+			err := boltdb.PutBlock(tx, h, boltdb.Block{Hash: testBoltHash(h), Header: []byte{byte(h)}})
+			if err != nil {
+				return err
+			}
+
+			if h%2 == 0 {
+				continue
+			}
+
+			data, err := boltdb.Encode(boltdb.Transaction{
+				Hash:          moneroutil.Hash{byte(h), 1},
+				Blob:          []byte{byte(h), 1},
+				OutputIndices: []uint64{h},
+			})
+			if err != nil {
+				return err
+			}
+
+			if err = tx.Bucket(boltdb.TransactionsBucket).Put(boltdb.TransactionKey(h, 0), data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		w.store.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return w, func() {
+		w.store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testBoltHash(height uint64) moneroutil.Hash {
+	return moneroutil.Hash{byte(height), byte(height >> 8), 0xff}
+}
+
+func TestBoltBlocks(t *testing.T) {
+	w, cleanup := newTestBoltWorker(t, 20)
+	defer cleanup()
+
+	top, err := w.GetTopBlockHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(19), top)
+
+	be, err := w.GetBlockEntry(5)
+	assert.NoError(t, err)
+	assert.Equal(t, BlockEntry{Height: 5, Hash: testBoltHash(5), Header: []byte{5}}, be)
+
+	_, err = w.GetBlockEntry(20)
+	assert.Error(t, err)
+
+	// blocks without transactions are skipped
+	blocks, err := w.GetBlocksAbove(4, 5)
+	assert.NoError(t, err)
+	if assert.Len(t, blocks, 2) {
+		assert.Equal(t, uint64(5), blocks[0].Height)
+		assert.Equal(t, uint64(7), blocks[1].Height)
+		assert.Equal(t, []uint64{7}, blocks[1].Txs[0].OutputIndices)
+		assert.Nil(t, blocks[1].Txs[0].PubKeys)
+	}
+
+	// the first known hash is taken, even if it isn't the highest one
+	hi, err := w.GetChainIntersection([]moneroutil.Hash{{1}, testBoltHash(3), testBoltHash(10)})
+	assert.NoError(t, err)
+	assert.Equal(t, utils.HeightInfo{Height: 3, Hash: testBoltHash(3)}, hi)
+
+	_, err = w.GetChainIntersection([]moneroutil.Hash{{1}})
+	assert.Error(t, err)
+}
+
+func TestBoltWallets(t *testing.T) {
+	w, cleanup := newTestBoltWorker(t, 20)
+	defer cleanup()
+
+	account := utils.AccountInfo{
+		Keys:      utils.WalletKeys{ViewSecretKey: moneroutil.Key{1}, SpendPublicKey: moneroutil.Key{2}},
+		CreatedAt: 3,
+		Lookahead: utils.SubaddressLookahead{Major: 2, Minor: 10},
+	}
+
+	entry, err := w.GetOrCreateKeyProgress(account)
+	assert.NoError(t, err)
+	assert.NotEqual(t, uint32(0), entry.Id)
+	assert.Equal(t, uint64(3), entry.ScannedHeight)
+
+	err = w.SaveWalletBlocks(entry.Id, []moneroutil.Hash{testBoltHash(5), testBoltHash(6)}, []OutputHeight{
+		{OutputIndex: 5, Height: 5, Subaddress: utils.SubaddressIndex{Major: 1, Minor: 3}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, w.SaveWalletProgress(entry.Id, testBoltHash(9)))
+
+	top, err := w.GetTopScannedHeightInfo(entry.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.HeightInfo{Height: 9, Hash: testBoltHash(9)}, top)
+
+	outputs, err := w.GetWalletOutputs(entry.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []OutputHeight{{OutputIndex: 5, Height: 5, Subaddress: utils.SubaddressIndex{Major: 1, Minor: 3}}}, outputs)
+
+	// only the wallet's blocks have their headers and transactions
+	blocks, err := w.GetWalletBlocks(entry.Id, 4, 4)
+	assert.NoError(t, err)
+	if assert.Len(t, blocks, 4) {
+		assert.Nil(t, blocks[0].Header)
+		assert.Equal(t, []byte{5}, blocks[1].Header)
+		assert.Equal(t, []ExtSerializedTx{{Hash: moneroutil.Hash{5, 1}, Blob: []byte{5, 1}, OutputIndices: []uint64{5}}}, blocks[1].Txs)
+		assert.Equal(t, []byte{6}, blocks[2].Header)
+		assert.Empty(t, blocks[2].Txs)
+		assert.Nil(t, blocks[3].Header)
+		assert.Empty(t, blocks[3].Txs)
+	}
+
+	// existing wallet keeps its progress
+	account.Lookahead.Minor = 5
+	again, err := w.GetOrCreateKeyProgress(account)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Id, again.Id)
+	assert.Equal(t, uint64(9), again.ScannedHeight)
+
+	// but is rescanned from its creation when the lookahead grows
+	account.Lookahead.Minor = 20
+	again, err = w.GetOrCreateKeyProgress(account)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Id, again.Id)
+	assert.Equal(t, uint64(3), again.ScannedHeight)
+
+	outputs, err = w.GetWalletOutputs(entry.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, outputs)
+
+	err = w.store.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, 0, tx.Bucket(boltdb.WalletBlocksBucket).Stats().KeyN)
+		assert.Equal(t, 0, tx.Bucket(boltdb.OutputsHeightsBucket).Stats().KeyN)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	recent, err := w.GetRecentWallets(time.Now().Add(-time.Hour), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, recent, 1) {
+		assert.Equal(t, again, recent[0])
+	}
+
+	recent, err = w.GetRecentWallets(time.Now().Add(time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, recent)
+}
@@ -1,14 +1,14 @@
 package server
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"fmt"
+	"time"
 
 	"github.com/lib/pq"
 
 	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/migrations"
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 	"github.com/exantech/moneroutil"
 )
@@ -24,6 +24,7 @@ type DbWorker interface {
 	GetTopScannedHeightInfo(walletId uint32) (utils.HeightInfo, error)
 	GetOrCreateKeyProgress(account utils.AccountInfo) (utils.WalletEntry, error)
 	GetTopBlockHeight() (uint64, error)
+	GetRecentWallets(since time.Time, maxCount int) ([]utils.WalletEntry, error)
 }
 
 type WalletsDb struct {
@@ -31,11 +32,20 @@ type WalletsDb struct {
 }
 
 func NewDbWorker(settings utils.DbSettings) (DbWorker, error) {
+	if settings.GetDriver() == utils.DriverBolt {
+		return NewBoltWorker(settings.Path)
+	}
+
 	db, err := utils.NewDb(settings)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = migrations.CheckVersion(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &WalletsDb{
 		db: db,
 	}, nil
@@ -56,8 +66,12 @@ type PreparsedTx struct {
 	Hash          moneroutil.Hash
 	Blob          []byte
 	OutputKeys    []moneroutil.Key
+	ViewTags      []byte // nil if outputs have no view tags
 	OutputIndices []uint64
 	UsedInputs    []uint64
+	// public keys extracted from extra by syncer, PubKeys is nil for transactions saved before the extraction
+	PubKeys           []moneroutil.Key
+	AdditionalPubKeys []moneroutil.Key
 }
 
 type PreSerializedBlock struct {
@@ -76,42 +90,19 @@ type ExtSerializedTx struct {
 type OutputHeight struct {
 	OutputIndex uint64
 	Height      uint64
-}
-
-func toStringList(hashes []moneroutil.Hash) string {
-	b := bytes.NewBufferString("")
-	for i, h := range hashes {
-		if i != 0 {
-			b.WriteString(", '")
-			b.WriteString(h.String())
-			b.WriteString("'")
-		} else {
-			b.WriteString("'")
-			b.WriteString(h.String())
-			b.WriteString("'")
-		}
-	}
-
-	return b.String()
+	Subaddress  utils.SubaddressIndex
 }
 
 func (w *WalletsDb) GetChainIntersection(chain []moneroutil.Hash) (utils.HeightInfo, error) {
-	hashStrings := toStringList(chain)
-
 	// sometimes first hash in monero shortchain isn't topmost one
 	// in this case we have to preserve order in select result
-	row := w.db.QueryRow(fmt.Sprintf(`SELECT height, hash FROM blocks
-	WHERE hash IN (%s)
-	ORDER BY array_position(array[%s], hash::text) ASC`, hashStrings, hashStrings))
+	row := w.db.QueryRow(`SELECT height, hash FROM blocks
+	WHERE hash = ANY($1::bytea[])
+	ORDER BY array_position($1::bytea[], hash) ASC
+	LIMIT 1`, utils.DbHashes(chain))
 
 	hi := utils.HeightInfo{}
-	var hs string
-	if err := row.Scan(&hi.Height, &hs); err != nil {
-		return hi, err
-	}
-
-	var err error
-	hi.Hash, err = moneroutil.HexToHash(hs)
+	err := row.Scan(&hi.Height, (*utils.DbHash)(&hi.Hash))
 	return hi, err
 }
 
@@ -126,12 +117,13 @@ func (w *WalletsDb) GetTopBlockHeight() (uint64, error) {
 }
 
 func (w *WalletsDb) GetBlocksAbove(startHeight uint64, maxCount int) ([]PreparsedBlock, error) {
+	// range is set on transactions' height, so only the partitions holding it are scanned
 	rows, err := w.db.Query(
 		`SELECT b.height, b.hash, b.header, t.hash, t.blob, t.output_keys, 
-					t.output_indices, t.used_inputs
+					t.output_view_tags, t.output_indices, t.used_inputs, t.pub_keys, t.additional_pub_keys
 			  FROM transactions t
 			  LEFT JOIN blocks b ON t.block_height = b.height
-			  WHERE b.height >= $1 AND b.height < $2
+			  WHERE t.block_height >= $1 AND t.block_height < $2
 			  ORDER BY b.height, t.index_in_block ASC`, startHeight, startHeight+uint64(maxCount))
 
 	if err != nil {
@@ -143,23 +135,24 @@ func (w *WalletsDb) GetBlocksAbove(startHeight uint64, maxCount int) ([]Preparse
 	blocks := make([]PreparsedBlock, 0, maxCount)
 	for rows.Next() {
 		var height uint64
-		var blockHash string
+		var blockHash moneroutil.Hash
 		var blockHeader []byte
-		var txHash string
-		var txBlob []byte
-		var outputKeys []string
+		var tx PreparsedTx
 		var outputIndices []int64 // libpq doesn't support reading of []uint64
 		var usedInputs []int64    // libpq doesn't support reading of []uint64
 
 		err = rows.Scan(
 			&height,
-			&blockHash,
+			(*utils.DbHash)(&blockHash),
 			&blockHeader,
-			&txHash,
-			&txBlob,
-			pq.Array(&outputKeys),
+			(*utils.DbHash)(&tx.Hash),
+			&tx.Blob,
+			(*utils.DbKeys)(&tx.OutputKeys),
+			&tx.ViewTags,
 			pq.Array(&outputIndices),
-			pq.Array(&usedInputs))
+			pq.Array(&usedInputs),
+			(*utils.DbKeys)(&tx.PubKeys),
+			(*utils.DbKeys)(&tx.AdditionalPubKeys))
 
 		if err != nil {
 			logging.Log.Errorf("Failed to scan results on scanning blocks: %s", err.Error())
@@ -167,17 +160,11 @@ func (w *WalletsDb) GetBlocksAbove(startHeight uint64, maxCount int) ([]Preparse
 		}
 
 		if len(blocks) == 0 || blocks[len(blocks)-1].Height != height {
-			h, err := moneroutil.HexToHash(blockHash)
-			if err != nil {
-				logging.Log.Errorf("Failed to decode block hash (%s) from DB: %s", blockHash, err.Error())
-				return nil, err
-			}
-
 			block := PreparsedBlock{
 				BlockEntry: BlockEntry{
 					Height: height,
 					Header: blockHeader,
-					Hash:   h,
+					Hash:   blockHash,
 				},
 				Txs: []PreparsedTx{},
 			}
@@ -185,23 +172,8 @@ func (w *WalletsDb) GetBlocksAbove(startHeight uint64, maxCount int) ([]Preparse
 			blocks = append(blocks, block)
 		}
 
-		h, err := moneroutil.HexToHash(txHash)
-		if err != nil {
-			logging.Log.Errorf("Failed to decode transaction hash (%s) from DB: %s", txHash, err.Error())
-		}
-
-		keys, err := convertStringsToKeys(outputKeys)
-		if err != nil {
-			logging.Log.Errorf("Failed to decode output keys for transaction %s from DB: %s", txHash, err.Error())
-		}
-
-		tx := PreparsedTx{
-			Hash:          h,
-			Blob:          txBlob,
-			OutputKeys:    keys,
-			OutputIndices: convertInts64toUints(outputIndices),
-			UsedInputs:    convertInts64toUints(usedInputs),
-		}
+		tx.OutputIndices = convertInts64toUints(outputIndices)
+		tx.UsedInputs = convertInts64toUints(usedInputs)
 
 		blocks[len(blocks)-1].Txs = append(blocks[len(blocks)-1].Txs, tx)
 	}
@@ -221,27 +193,20 @@ func (w *WalletsDb) GetBlockEntry(height uint64) (BlockEntry, error) {
 			  FROM blocks b
 			  WHERE b.height = $1`, height)
 
-	var hash string
-	if err := row.Scan(&be.Height, &hash, &be.Header); err != nil {
+	if err := row.Scan(&be.Height, (*utils.DbHash)(&be.Hash), &be.Header); err != nil {
 		logging.Log.Errorf("Failed to get block entry at height %d: %s", height, err.Error())
 		return be, err
 	}
 
-	var err error
-	be.Hash, err = moneroutil.HexToHash(hash)
-	if err != nil {
-		logging.Log.Errorf("Failed to parse block hash string from db (%s): %s", hash, err.Error())
-		return be, err
-	}
-
 	return be, nil
 }
 
 func (w *WalletsDb) GetWalletBlocks(walletId uint32, startHeight uint64, maxBlocks int) ([]PreSerializedBlock, error) {
+	// the range is repeated in the join to prune transactions partitions
 	rows, err := w.db.Query(
 		`SELECT wb.wallet_id, b.height, b.hash, b.header, t.hash, t.blob, t.output_indices
 FROM blocks b
-LEFT JOIN transactions t ON t.block_height = b.height
+LEFT JOIN transactions t ON t.block_height = b.height AND t.block_height >= $1 AND t.block_height < $2
 LEFT JOIN wallets_blocks wb ON wb.block_id = b.id AND wb.wallet_id = $3
 WHERE b.height >= $1 AND b.height < $2
 ORDER BY b.height, t.index_in_block ASC`, startHeight, startHeight+uint64(maxBlocks), walletId)
@@ -256,16 +221,16 @@ ORDER BY b.height, t.index_in_block ASC`, startHeight, startHeight+uint64(maxBlo
 	for rows.Next() {
 		var wId sql.NullInt64
 		var height uint64
-		var blockHash string
+		var blockHash moneroutil.Hash
 		var blockHeader []byte
-		var txHash sql.NullString
+		var txHash utils.NullHash
 		var txBlob []byte
 		var outputIndices []int64
 
 		err = rows.Scan(
 			&wId,
 			&height,
-			&blockHash,
+			(*utils.DbHash)(&blockHash),
 			&blockHeader,
 			&txHash,
 			&txBlob,
@@ -277,12 +242,6 @@ ORDER BY b.height, t.index_in_block ASC`, startHeight, startHeight+uint64(maxBlo
 		}
 
 		if len(blocks) == 0 || blocks[len(blocks)-1].Height != height {
-			h, err := moneroutil.HexToHash(blockHash)
-			if err != nil {
-				logging.Log.Errorf("Failed to decode block hash (%s) from DB: %s", blockHash, err.Error())
-				return nil, err
-			}
-
 			header := blockHeader
 			if !wId.Valid {
 				header = nil
@@ -291,7 +250,7 @@ ORDER BY b.height, t.index_in_block ASC`, startHeight, startHeight+uint64(maxBlo
 			block := PreSerializedBlock{
 				Height: height,
 				Header: header,
-				Hash:   h,
+				Hash:   blockHash,
 				Txs:    []ExtSerializedTx{},
 			}
 
@@ -303,17 +262,12 @@ ORDER BY b.height, t.index_in_block ASC`, startHeight, startHeight+uint64(maxBlo
 			continue
 		}
 
-		h, err := moneroutil.HexToHash(txHash.String)
-		if err != nil {
-			logging.Log.Errorf("Failed to decode transaction hash (%s) from DB: %s", txHash, err.Error())
-		}
-
 		if !wId.Valid {
 			continue
 		}
 
 		tx := ExtSerializedTx{
-			Hash:          h,
+			Hash:          txHash.Hash,
 			Blob:          txBlob,
 			OutputIndices: convertInts64toUints(outputIndices),
 		}
@@ -329,7 +283,8 @@ ORDER BY b.height, t.index_in_block ASC`, startHeight, startHeight+uint64(maxBlo
 }
 
 func (w *WalletsDb) GetWalletOutputs(walletId uint32) ([]OutputHeight, error) {
-	rows, err := w.db.Query(`SELECT output, block_height FROM wallets_outputs WHERE wallet_id = $1`, walletId)
+	rows, err := w.db.Query(`SELECT output, block_height, subaddress_major, subaddress_minor
+								FROM wallets_outputs WHERE wallet_id = $1`, walletId)
 	if err != nil {
 		return nil, err
 	}
@@ -338,18 +293,19 @@ func (w *WalletsDb) GetWalletOutputs(walletId uint32) ([]OutputHeight, error) {
 
 	outputs := make([]OutputHeight, 0, 200)
 	for rows.Next() {
-		var height uint64
-		var output uint64
+		o := OutputHeight{}
 
 		err = rows.Scan(
-			&output,
-			&height)
+			&o.OutputIndex,
+			&o.Height,
+			&o.Subaddress.Major,
+			&o.Subaddress.Minor)
 		if err != nil {
 			logging.Log.Errorf("Failed to scan results on scanning wallet's outputs: %s", err.Error())
 			return nil, err
 		}
 
-		outputs = append(outputs, OutputHeight{output, height})
+		outputs = append(outputs, o)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -360,8 +316,6 @@ func (w *WalletsDb) GetWalletOutputs(walletId uint32) ([]OutputHeight, error) {
 }
 
 func (w *WalletsDb) SaveWalletBlocks(walletId uint32, blocks []moneroutil.Hash, outputs []OutputHeight) error {
-	hashStrs := toStringList(blocks)
-
 	tx, err := w.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
 		logging.Log.Errorf("Failed to begin transaction for saving wallet outputs: %s", err.Error())
@@ -370,10 +324,8 @@ func (w *WalletsDb) SaveWalletBlocks(walletId uint32, blocks []moneroutil.Hash,
 
 	defer tx.Rollback()
 
-	q := fmt.Sprintf("INSERT INTO wallets_blocks (wallet_id, block_id) (SELECT %d, id FROM blocks WHERE hash IN (%s))",
-		walletId, hashStrs)
-
-	ir, err := tx.Exec(q)
+	ir, err := tx.Exec(`INSERT INTO wallets_blocks (wallet_id, block_id)
+		(SELECT $1, id FROM blocks WHERE hash = ANY($2::bytea[]))`, walletId, utils.DbHashes(blocks))
 	if err != nil {
 		logging.Log.Errorf("Failed to insert wallet's blocks: %s", err.Error())
 		return err
@@ -387,7 +339,8 @@ func (w *WalletsDb) SaveWalletBlocks(walletId uint32, blocks []moneroutil.Hash,
 
 	logging.Log.Debugf("Inserted %d wallet's blocks", rows)
 
-	ostmt, err := tx.PrepareContext(context.Background(), "INSERT INTO wallets_outputs (wallet_id, output, block_height) VALUES ($1, $2, $3)")
+	ostmt, err := tx.PrepareContext(context.Background(), "INSERT INTO wallets_outputs (wallet_id, output, block_height, subaddress_major, subaddress_minor)"+
+		" VALUES ($1, $2, $3, $4, $5)")
 	if err != nil {
 		logging.Log.Errorf("Failed to prepare statement for saving wallet outputs: %s", err.Error())
 		return err
@@ -396,7 +349,7 @@ func (w *WalletsDb) SaveWalletBlocks(walletId uint32, blocks []moneroutil.Hash,
 	defer ostmt.Close()
 
 	for _, o := range outputs {
-		_, err = ostmt.Exec(walletId, o.OutputIndex, o.Height)
+		_, err = ostmt.Exec(walletId, o.OutputIndex, o.Height, o.Subaddress.Major, o.Subaddress.Minor)
 		if err != nil {
 			logging.Log.Errorf("Couldn't insert output into db: %s", err.Error())
 			return err
@@ -424,7 +377,7 @@ func (w *WalletsDb) SaveWalletProgress(walletId uint32, hash moneroutil.Hash) er
 UPDATE wallets
 SET last_checked_block_id = last_block_id.id
 FROM last_block_id
-WHERE wallets.id = $2`, hash.String(), walletId)
+WHERE wallets.id = $2`, utils.DbHash(hash), walletId)
 	if err != nil {
 		logging.Log.Errorf("Failed to update wallet's progress: %s", err.Error())
 		return err
@@ -446,19 +399,11 @@ func (w *WalletsDb) GetTopScannedHeightInfo(walletId uint32) (utils.HeightInfo,
 							LEFT JOIN blocks b on wallets.last_checked_block_id = b.id
 							WHERE wallets.id = $1`, walletId)
 
-	var hash string
-	if err := r.Scan(&res.Height, &hash); err != nil {
+	if err := r.Scan(&res.Height, (*utils.DbHash)(&res.Hash)); err != nil {
 		logging.Log.Errorf("Failed to scan get top scanned height info: %s", err.Error())
 		return res, err
 	}
 
-	var err error
-	res.Hash, err = moneroutil.HexToHash(hash)
-	if err != nil {
-		logging.Log.Errorf("Failed to parse hash hex while getting top scanned height info: %s [%s]", err.Error(), hash)
-		return res, err
-	}
-
 	return res, nil
 }
 
@@ -473,12 +418,14 @@ func (w *WalletsDb) GetOrCreateKeyProgress(account utils.AccountInfo) (utils.Wal
 
 	defer tx.Rollback()
 
-	r := tx.QueryRow(`SELECT w.id, b.height FROM wallets w
+	var lookahead utils.SubaddressLookahead
+	var createdAt uint64
+	r := tx.QueryRow(`SELECT w.id, b.height, w.subaddress_major, w.subaddress_minor, w.created_at FROM wallets w
 							LEFT JOIN blocks b ON w.last_checked_block_id = b.id
 							WHERE secret_view_key = $1 AND public_spend_key = $2`,
-		account.Keys.ViewSecretKey.String(), account.Keys.SpendPublicKey.String())
+		utils.DbKey(account.Keys.ViewSecretKey), utils.DbKey(account.Keys.SpendPublicKey))
 
-	err = r.Scan(&res.Id, &res.ScannedHeight)
+	err = r.Scan(&res.Id, &res.ScannedHeight, &lookahead.Major, &lookahead.Minor, &createdAt)
 	if err != nil && err != sql.ErrNoRows {
 		logging.Log.Errorf("Failed to query wallet (%s, %s): %s",
 			account.Keys.ViewSecretKey.String(), account.Keys.SpendPublicKey.String(), err.Error())
@@ -486,14 +433,41 @@ func (w *WalletsDb) GetOrCreateKeyProgress(account utils.AccountInfo) (utils.Wal
 	}
 
 	if err != sql.ErrNoRows {
+		if !lookahead.Covers(account.Lookahead) {
+			// scanned blocks may have outputs of the new subaddresses
+			if err = resetWalletProgress(tx, res.Id, createdAt); err != nil {
+				logging.Log.Errorf("Failed to reset wallet's progress on lookahead growth: %s", err.Error())
+				return res, err
+			}
+
+			logging.Log.Infof("Subaddress lookahead of wallet %d grew from %d x %d to %d x %d, rescanning from %d",
+				res.Id, lookahead.Major, lookahead.Minor, account.Lookahead.Major, account.Lookahead.Minor, createdAt)
+			res.ScannedHeight = createdAt
+		}
+
+		_, err = tx.Exec(`UPDATE wallets SET subaddress_major = $1, subaddress_minor = $2, last_request_at = $3
+							WHERE id = $4`,
+			account.Lookahead.Major, account.Lookahead.Minor, time.Now().Unix(), res.Id)
+		if err != nil {
+			logging.Log.Errorf("Failed to update wallet's subaddress lookahead and request time: %s", err.Error())
+			return res, err
+		}
+
 		res.Keys = account.Keys
-		tx.Commit()
+		res.Lookahead = account.Lookahead
+		if err = tx.Commit(); err != nil {
+			logging.Log.Errorf("Failed to commit wallet's progress: %s", err.Error())
+			return res, err
+		}
+
 		return res, nil
 	}
 
-	row := tx.QueryRow(`INSERT INTO wallets (secret_view_key, public_spend_key, created_at, last_checked_block_id)
-					(SELECT $1, $2, $3, id FROM blocks WHERE height = $3 limit 1) RETURNING wallets.id`,
-		account.Keys.ViewSecretKey.String(), account.Keys.SpendPublicKey.String(), account.CreatedAt)
+	row := tx.QueryRow(`INSERT INTO wallets (secret_view_key, public_spend_key, created_at, last_checked_block_id,
+					subaddress_major, subaddress_minor, last_request_at)
+					(SELECT $1, $2, $3, id, $4, $5, $6 FROM blocks WHERE height = $3 limit 1) RETURNING wallets.id`,
+		utils.DbKey(account.Keys.ViewSecretKey), utils.DbKey(account.Keys.SpendPublicKey), account.CreatedAt,
+		account.Lookahead.Major, account.Lookahead.Minor, time.Now().Unix())
 
 	var id uint32
 	if err = row.Scan(&id); err != nil {
@@ -502,6 +476,7 @@ func (w *WalletsDb) GetOrCreateKeyProgress(account utils.AccountInfo) (utils.Wal
 
 	res.ScannedHeight = account.CreatedAt
 	res.Keys = account.Keys
+	res.Lookahead = account.Lookahead
 	res.Id = uint32(id)
 
 	tx.Commit()
@@ -509,19 +484,65 @@ func (w *WalletsDb) GetOrCreateKeyProgress(account utils.AccountInfo) (utils.Wal
 	return res, nil
 }
 
-func convertStringsToKeys(strs []string) ([]moneroutil.Key, error) {
-	keys := make([]moneroutil.Key, 0, len(strs))
-	for _, s := range strs {
-		k, err := moneroutil.HexToKey(s)
+// Forgets wallet's found blocks and outputs and moves its progress back to its creation height
+func resetWalletProgress(tx *sql.Tx, walletId uint32, createdAt uint64) error {
+	if _, err := tx.Exec("DELETE FROM wallets_blocks WHERE wallet_id = $1", walletId); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM wallets_outputs WHERE wallet_id = $1", walletId); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE wallets SET last_checked_block_id = (SELECT id FROM blocks WHERE height = $1)
+							WHERE id = $2`, createdAt, walletId)
+	return err
+}
+
+// Returns wallets requested since the given time, most recent first
+func (w *WalletsDb) GetRecentWallets(since time.Time, maxCount int) ([]utils.WalletEntry, error) {
+	rows, err := w.db.Query(`SELECT w.id, w.secret_view_key, w.public_spend_key, b.height,
+							w.subaddress_major, w.subaddress_minor
+							FROM wallets w
+							LEFT JOIN blocks b ON w.last_checked_block_id = b.id
+							WHERE w.last_request_at >= $1
+							ORDER BY w.last_request_at DESC
+							LIMIT $2`, since.Unix(), maxCount)
+
+	if err != nil {
+		logging.Log.Errorf("Failed to query recent wallets: %s", err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := make([]utils.WalletEntry, 0, maxCount)
+	for rows.Next() {
+		var we utils.WalletEntry
+		var scannedHeight sql.NullInt64
+
+		err = rows.Scan(&we.Id, (*utils.DbKey)(&we.Keys.ViewSecretKey), (*utils.DbKey)(&we.Keys.SpendPublicKey),
+			&scannedHeight, &we.Lookahead.Major, &we.Lookahead.Minor)
 		if err != nil {
-			logging.Log.Errorf("Failed to decode key %s, error: %s", s, err.Error())
+			logging.Log.Errorf("Failed to scan recent wallets: %s", err.Error())
 			return nil, err
 		}
 
-		keys = append(keys, k)
+		if !scannedHeight.Valid {
+			// progress block was removed by chain split, the wallet will be rescanned on request
+			continue
+		}
+
+		we.ScannedHeight = uint64(scannedHeight.Int64)
+		res = append(res, we)
 	}
 
-	return keys, nil
+	if err = rows.Err(); err != nil {
+		logging.Log.Errorf("Failed to read recent wallets: %s", err.Error())
+		return nil, err
+	}
+
+	return res, nil
 }
 
 func convertInts64toUints(ints []int64) []uint64 {
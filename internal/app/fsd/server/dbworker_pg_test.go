@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/migrations"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+// Connection string of a throwaway database, its public schema is recreated by the tests.
+// Tests using it are skipped if it isn't set
+const testPostgresEnv = "FASTSYNC_TEST_POSTGRES"
+
+// Makes the worker on the migrated empty schema with blocks up to the height
+func newTestPostgresWalletsDb(t *testing.T, height uint64) *WalletsDb {
+	dsn := os.Getenv(testPostgresEnv)
+	if len(dsn) == 0 {
+		t.Skipf("%s isn't set", testPostgresEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"DROP SCHEMA public CASCADE", "CREATE SCHEMA public"} {
+		if _, err = db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = migrations.Up(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	for h := uint64(0); h < height; h++ {
+		_, err = db.Exec(`INSERT INTO blocks (height, hash, header, "timestamp") VALUES ($1, $2, $3, 0)`,
+			h, utils.DbHash(testBoltHash(h)), []byte{byte(h)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &WalletsDb{db: db}
+}
+
+func countTestRows(t *testing.T, w *WalletsDb, query string, args ...interface{}) int {
+	var n int
+	if err := w.db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestPostgresChainIntersection(t *testing.T) {
+	w := newTestPostgresWalletsDb(t, 10)
+	defer w.db.Close()
+
+	// the first known hash in the client's order is taken, even if it isn't the highest one
+	hi, err := w.GetChainIntersection([]moneroutil.Hash{{1}, testBoltHash(3), testBoltHash(8), testBoltHash(3)})
+	assert.NoError(t, err)
+	assert.Equal(t, utils.HeightInfo{Height: 3, Hash: testBoltHash(3)}, hi)
+
+	hi, err = w.GetChainIntersection([]moneroutil.Hash{testBoltHash(8), testBoltHash(3)})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), hi.Height)
+
+	// hashes are bound as data, whatever bytes they have
+	_, err = w.GetChainIntersection(hostileShortChain())
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.Equal(t, 10, countTestRows(t, w, "SELECT count(*) FROM blocks"))
+}
+
+func TestPostgresWalletBlocks(t *testing.T) {
+	w := newTestPostgresWalletsDb(t, 10)
+	defer w.db.Close()
+
+	account := utils.AccountInfo{
+		Keys:      utils.WalletKeys{ViewSecretKey: moneroutil.Key{1}, SpendPublicKey: moneroutil.Key{2}},
+		CreatedAt: 2,
+		Lookahead: utils.SubaddressLookahead{Major: 2, Minor: 10},
+	}
+
+	entry, err := w.GetOrCreateKeyProgress(account)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), entry.ScannedHeight)
+
+	// unknown and duplicated hashes don't add rows
+	hashes := append(hostileShortChain(), testBoltHash(5), testBoltHash(6), testBoltHash(5))
+	err = w.SaveWalletBlocks(entry.Id, hashes, []OutputHeight{{OutputIndex: 5, Height: 5}})
+	assert.NoError(t, err)
+	assert.NoError(t, w.SaveWalletProgress(entry.Id, testBoltHash(9)))
+	assert.Equal(t, 2, countTestRows(t, w, "SELECT count(*) FROM wallets_blocks WHERE wallet_id = $1", entry.Id))
+
+	// growing lookahead rescans the wallet from its creation
+	account.Lookahead.Minor = 20
+	again, err := w.GetOrCreateKeyProgress(account)
+	assert.NoError(t, err)
+	assert.Equal(t, entry.Id, again.Id)
+	assert.Equal(t, uint64(2), again.ScannedHeight)
+	assert.Equal(t, 0, countTestRows(t, w, "SELECT count(*) FROM wallets_blocks WHERE wallet_id = $1", entry.Id))
+	assert.Equal(t, 0, countTestRows(t, w, "SELECT count(*) FROM wallets_outputs WHERE wallet_id = $1", entry.Id))
+
+	top, err := w.GetTopScannedHeightInfo(entry.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), top.Height)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+const (
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = time.Minute
+)
+
+// chainNotifier listens for blockchain events sent by the syncer, so the top is updated
+// right after new blocks are saved instead of waiting for the next poll
+type chainNotifier struct {
+	listener *pq.Listener
+	updater  *bcHeightUpdater
+	stopCh   chan struct{}
+}
+
+func newChainNotifier(settings utils.DbSettings, updater *bcHeightUpdater) (*chainNotifier, error) {
+	listener := pq.NewListener(utils.ConnectionString(settings), listenerMinReconnect, listenerMaxReconnect,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				logging.Log.Warningf("Chain events listener error: %s", err.Error())
+			}
+		})
+
+	if err := listener.Listen(utils.ChainEventsChannel); err != nil {
+		logging.Log.Errorf("Failed to listen %s channel: %s", utils.ChainEventsChannel, err.Error())
+		listener.Close()
+		return nil, err
+	}
+
+	return &chainNotifier{
+		listener: listener,
+		updater:  updater,
+		stopCh:   make(chan struct{}),
+	}, nil
+}
+
+func (n *chainNotifier) runLoop() {
+	ticker := time.Tick(listenerPingInterval)
+	for {
+		select {
+		case notification := <-n.listener.Notify:
+			if notification == nil {
+				// connection was re-established, events could be lost meanwhile
+				logging.Log.Info("Chain events listener reconnected")
+				n.updater.updateTopBlockInfo()
+				continue
+			}
+
+			n.handleEvent(notification.Extra)
+		case <-ticker:
+			go n.listener.Ping()
+		case <-n.stopCh:
+			logging.Log.Debug("Stop signal received, stopping chain events listener loop")
+			n.listener.Close()
+			return
+		}
+	}
+}
+
+func (n *chainNotifier) stop() {
+	n.stopCh <- struct{}{}
+}
+
+func (n *chainNotifier) handleEvent(payload string) {
+	event, err := utils.ParseChainEvent(payload)
+	if err != nil {
+		logging.Log.Errorf("Failed to parse chain event '%s': %s", payload, err.Error())
+		return
+	}
+
+	logging.Log.Debugf("Chain event received: %s at height %d", event.Type, event.Height)
+
+	switch event.Type {
+	case utils.ChainEventTop:
+		n.updater.updateTopBlockInfo()
+	case utils.ChainEventTrim:
+		n.updater.trimmed(event.Height)
+	default:
+		logging.Log.Warningf("Unknown chain event type: %s", event.Type)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+// prescanner adds background jobs for recently active wallets when the blockchain top moves,
+// so their progress is already at the top when they connect next time
+type prescanner struct {
+	jq         *jobsQueue
+	window     time.Duration
+	maxWallets int
+	notifyCh   chan struct{}
+	stopCh     chan struct{}
+}
+
+func newPrescanner(jq *jobsQueue, window time.Duration, maxWallets int) *prescanner {
+	return &prescanner{
+		jq:         jq,
+		window:     window,
+		maxWallets: maxWallets,
+		notifyCh:   make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+func (p *prescanner) InvalidateFrom(height uint64) {
+	// prescan is already scheduled if the channel is full
+	select {
+	case p.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (p *prescanner) runLoop() {
+	for {
+		select {
+		case <-p.notifyCh:
+			p.prescan()
+		case <-p.stopCh:
+			logging.Log.Debug("Stop signal received, stopping prescanner loop")
+			return
+		}
+	}
+}
+
+func (p *prescanner) stop() {
+	p.stopCh <- struct{}{}
+}
+
+func (p *prescanner) prescan() {
+	wallets, err := p.jq.db.GetRecentWallets(time.Now().Add(-p.window), p.maxWallets)
+	if err != nil {
+		logging.Log.Errorf("Failed to get recently active wallets for prescan: %s", err.Error())
+		return
+	}
+
+	topHeight := atomic.LoadUint64(&p.jq.blockchainHeight)
+	outdated := make([]utils.WalletEntry, 0, len(wallets))
+	for _, w := range wallets {
+		if w.ScannedHeight < topHeight {
+			outdated = append(outdated, w)
+		}
+	}
+
+	added := p.jq.AddBackgroundJobs(outdated)
+	logging.Log.Debugf("Prescan: %d recently active wallets, %d outdated, %d jobs added", len(wallets), len(outdated), added)
+}
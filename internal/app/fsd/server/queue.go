@@ -9,6 +9,10 @@ import (
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 )
 
+// background job is dropped after this time even if its wallet isn't scanned up to the top,
+// the next prescan continues from the saved progress
+const backgroundJobLifetime = time.Hour
+
 type jobsQueue struct {
 	lock             *sync.Mutex
 	cond             *sync.Cond
@@ -21,8 +25,11 @@ type jobsQueue struct {
 	topUpdater       *bcHeightUpdater
 	workerBlocks     int
 	resultBlocks     int
+	batchJobs        int // max jobs scanned in one pass
 	jobLifetime      time.Duration
 	jj               *jobJanitor
+	ps               *prescanner    // nil if prescan is disabled
+	cn               *chainNotifier // nil if chain events aren't listened
 }
 
 type job struct {
@@ -35,9 +42,15 @@ type job struct {
 	lastQuery        time.Time
 	blockchainHeight uint64
 	stopJob          bool
+	siblings         []*job // jobs of the same request, processed together
+	background       bool   // added by prescan, nobody waits for its blocks
 }
 
-func NewJobsQueue(scanner Scanner, db DbWorker, workerBlocks int, resultBlocks int, jobLifetime time.Duration) *jobsQueue {
+func NewJobsQueue(scanner Scanner, db DbWorker, workerBlocks int, resultBlocks int, batchJobs int, jobLifetime time.Duration) *jobsQueue {
+	if batchJobs < 1 {
+		batchJobs = 1
+	}
+
 	jq := &jobsQueue{
 		lock:         new(sync.Mutex),
 		jobs:         make([]*job, 0, 100),
@@ -46,6 +59,7 @@ func NewJobsQueue(scanner Scanner, db DbWorker, workerBlocks int, resultBlocks i
 		db:           db,
 		workerBlocks: workerBlocks,
 		resultBlocks: resultBlocks,
+		batchJobs:    batchJobs,
 		jobLifetime:  jobLifetime,
 	}
 
@@ -57,6 +71,9 @@ func NewJobsQueue(scanner Scanner, db DbWorker, workerBlocks int, resultBlocks i
 }
 
 func (q *jobsQueue) StartWorkers(count int) error {
+	// the queue wakes up workers, so it's notified last when other listeners have already dropped stale data
+	q.AddChainListener(q)
+
 	err := q.topUpdater.updateTopBlockInfo()
 	if err != nil {
 		logging.Log.Errorf("Failed to start workers, error on updating top block height: %s", err.Error())
@@ -69,8 +86,8 @@ func (q *jobsQueue) StartWorkers(count int) error {
 	for i := 0; i < count; i++ {
 		w := &worker{q, q.scanner, q.db, q.workerBlocks}
 
+		q.wg.Add(1)
 		go func() {
-			q.wg.Add(1)
 			defer q.wg.Done()
 
 			w.run()
@@ -79,24 +96,66 @@ func (q *jobsQueue) StartWorkers(count int) error {
 
 	logging.Log.Debugf("Workers started")
 
+	q.wg.Add(1)
 	go func() {
-		q.wg.Add(1)
 		defer q.wg.Done()
 
 		q.topUpdater.runLoop()
 	}()
 
+	q.wg.Add(1)
 	go func() {
-		q.wg.Add(1)
 		defer q.wg.Done()
 
 		q.jj.runLoop()
 	}()
 
+	if q.ps != nil {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+
+			q.ps.runLoop()
+		}()
+	}
+
+	if q.cn != nil {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+
+			q.cn.runLoop()
+		}()
+	}
+
 	return nil
 }
 
+// Subscribes for blockchain events sent by the syncer. Must be called before the workers are started
+func (q *jobsQueue) ListenChainEvents(settings utils.DbSettings) error {
+	cn, err := newChainNotifier(settings, q.topUpdater)
+	if err != nil {
+		return err
+	}
+
+	q.cn = cn
+	return nil
+}
+
+// Enables background scanning of wallets requested within the window when the blockchain top moves.
+// Must be called before the workers are started
+func (q *jobsQueue) EnablePrescan(window time.Duration, maxWallets int) {
+	q.ps = newPrescanner(q, window, maxWallets)
+	q.AddChainListener(q.ps)
+}
+
 func (q *jobsQueue) Stop() {
+	if q.cn != nil {
+		logging.Log.Info("Stopping chain events listener...")
+		q.cn.stop()
+		logging.Log.Info("Chain events listener stopped")
+	}
+
 	logging.Log.Info("Stopping updater...")
 	q.topUpdater.stop()
 	logging.Log.Info("Updater stopped")
@@ -105,6 +164,12 @@ func (q *jobsQueue) Stop() {
 	q.jj.stop()
 	logging.Log.Info("Job janitor stopped")
 
+	if q.ps != nil {
+		logging.Log.Info("Stopping prescanner...")
+		q.ps.stop()
+		logging.Log.Info("Prescanner stopped")
+	}
+
 	q.lock.Lock()
 	q.stopped = true
 	q.cond.Broadcast()
@@ -116,21 +181,70 @@ func (q *jobsQueue) Stop() {
 }
 
 func (q *jobsQueue) AddJob(wallet utils.WalletEntry, startHeight uint64) *blocksListener {
+	return q.AddJobs([]utils.WalletEntry{wallet}, startHeight)[0]
+}
+
+// Adds jobs for several wallets requested at once. Such jobs are processed together,
+// so the blocks range is scanned once for all of them.
+func (q *jobsQueue) AddJobs(wallets []utils.WalletEntry, startHeight uint64) []*blocksListener {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
 	topHeight := atomic.LoadUint64(&q.blockchainHeight)
+	jobs := make([]*job, 0, len(wallets))
+	for _, wallet := range wallets {
+		j := q.findJob(wallet.Keys)
+		if j != nil {
+			j.updateJob(time.Now(), topHeight, startHeight, wallet.Lookahead)
+		} else {
+			j = q.addNewJob(wallet, startHeight)
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	listeners := make([]*blocksListener, 0, len(jobs))
+	for _, j := range jobs {
+		j.setSiblings(jobs)
+		listeners = append(listeners, &blocksListener{j, startHeight, q.resultBlocks})
+	}
+
+	q.cond.Broadcast()
+	return listeners
+}
+
+// Adds jobs scanning wallets from their progress for wallets which have no jobs yet.
+// Returns the number of added jobs
+func (q *jobsQueue) AddBackgroundJobs(wallets []utils.WalletEntry) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	added := 0
+	for _, wallet := range wallets {
+		if q.findJob(wallet.Keys) != nil {
+			continue
+		}
+
+		q.addNewJob(wallet, wallet.ScannedHeight+1).background = true
+		added++
+	}
+
+	if added != 0 {
+		q.cond.Broadcast()
+	}
+
+	return added
+}
+
+// must be locked from outside
+func (q *jobsQueue) findJob(keys utils.WalletKeys) *job {
 	for _, j := range q.jobs {
-		if j.wallet.Keys.SpendPublicKey == wallet.Keys.SpendPublicKey && j.wallet.Keys.ViewSecretKey == wallet.Keys.ViewSecretKey {
-			j.updateJob(time.Now(), topHeight, startHeight)
-			q.cond.Signal()
-			return &blocksListener{j, startHeight, q.resultBlocks}
+		if j.wallet.Keys.SpendPublicKey == keys.SpendPublicKey && j.wallet.Keys.ViewSecretKey == keys.ViewSecretKey {
+			return j
 		}
 	}
 
-	newJob := q.addNewJob(wallet, startHeight)
-	q.cond.Signal()
-	return &blocksListener{newJob, startHeight, q.resultBlocks}
+	return nil
 }
 
 // must be locked from outside
@@ -143,35 +257,38 @@ func (q *jobsQueue) addNewJob(wallet utils.WalletEntry, startHeight uint64) *job
 	return newJob
 }
 
-func (q *jobsQueue) jobDone(job *job) {
+func (q *jobsQueue) jobsDone(jobs []*job) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
-	i := 0
-	for _, j := range q.jobs {
-		if j == job {
-			break
-		}
+	for _, job := range jobs {
+		i := 0
+		for _, j := range q.jobs {
+			if j == job {
+				break
+			}
 
-		i++
-	}
+			i++
+		}
 
-	// job janitor can delete the job from the queue
-	if i < len(q.jobs) {
-		// move job to the end of the queue
-		q.jobs = append(q.jobs[0:i], q.jobs[i+1:]...)
-	}
+		// job janitor can delete the job from the queue
+		if i < len(q.jobs) {
+			// move job to the end of the queue
+			q.jobs = append(q.jobs[0:i], q.jobs[i+1:]...)
+		}
 
-	q.jobs = append(q.jobs, job)
+		q.jobs = append(q.jobs, job)
 
-	job.lock.Lock()
-	job.inProgress = false
-	job.lock.Unlock()
+		job.lock.Lock()
+		job.inProgress = false
+		job.lock.Unlock()
+	}
 
-	q.cond.Signal()
+	q.cond.Broadcast()
 }
 
-func (q *jobsQueue) waitJob() (*job, bool) {
+// Returns a free job along with its free siblings
+func (q *jobsQueue) waitJobs() ([]*job, bool) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
@@ -189,19 +306,64 @@ func (q *jobsQueue) waitJob() (*job, bool) {
 		return nil, true
 	}
 
-	freeJob.inProgress = true
-	return freeJob, false
+	jobs := []*job{freeJob}
+	for _, s := range freeJob.getSiblings() {
+		if s != freeJob && q.isJobFree(s) {
+			jobs = append(jobs, s)
+		}
+	}
+
+	for _, j := range jobs {
+		j.inProgress = true
+	}
+
+	jobs = append(jobs, q.findOverlappingJobs(freeJob, q.batchJobs-len(jobs))...)
+	return jobs, false
 }
 
-// must be locked from outside
-func (q *jobsQueue) findFreeJob() *job {
+// Returns free jobs which missing blocks range overlaps with the given job's one, so they can be
+// scanned in the same pass. Found jobs are marked as in progress. Must be locked from outside
+func (q *jobsQueue) findOverlappingJobs(base *job, maxJobs int) []*job {
+	if maxJobs <= 0 {
+		return nil
+	}
+
+	from, to := q.missingRange(base)
+
+	res := make([]*job, 0, maxJobs)
 	for _, j := range q.jobs {
-		bcHeight := atomic.LoadUint64(&q.blockchainHeight)
-		nextBlock, _ := j.FindMissingBlocks()
+		if len(res) == maxJobs {
+			break
+		}
+
+		if !q.isJobFree(j) {
+			continue
+		}
+
+		jobFrom, jobTo := q.missingRange(j)
+		if jobFrom < to && from < jobTo {
+			j.inProgress = true
+			res = append(res, j)
+		}
+	}
+
+	return res
+}
+
+// Returns the range of blocks [from, to) a worker will scan for the job
+func (q *jobsQueue) missingRange(j *job) (uint64, uint64) {
+	start, count := j.FindMissingBlocks()
+	if count > q.workerBlocks || count == 0 {
+		count = q.workerBlocks
+	}
 
-		synced := j.BlocksAvailable(bcHeight) != 0 && nextBlock >= bcHeight
+	return start, start + uint64(count)
+}
 
-		if !j.inProgress && !synced && time.Now().Sub(j.lastQuery) < q.jobLifetime {
+// must be locked from outside
+func (q *jobsQueue) findFreeJob() *job {
+	for _, j := range q.jobs {
+		if q.isJobFree(j) {
 			return j
 		}
 	}
@@ -209,6 +371,25 @@ func (q *jobsQueue) findFreeJob() *job {
 	return nil
 }
 
+// must be locked from outside
+func (q *jobsQueue) isJobFree(j *job) bool {
+	bcHeight := atomic.LoadUint64(&q.blockchainHeight)
+	nextBlock, _ := j.FindMissingBlocks()
+
+	synced := j.BlocksAvailable(bcHeight) != 0 && nextBlock >= bcHeight
+
+	return !j.inProgress && !synced && isJobAlive(j, q.jobLifetime)
+}
+
+// Background jobs of far behind wallets take longer than a request waits, so they have their own lifetime
+func isJobAlive(j *job, lifetime time.Duration) bool {
+	if j.background {
+		lifetime = backgroundJobLifetime
+	}
+
+	return time.Now().Sub(j.lastQuery) < lifetime
+}
+
 func newJob(wallet utils.WalletEntry, startHeight uint64) *job {
 	j := &job{
 		wallet:     wallet,
@@ -234,6 +415,16 @@ func (l *blocksListener) Wait() ([]*WalletBlock, error) {
 	return l.job.waitBlocks(l.returnFrom, l.maxBlocks)
 }
 
+// Returns the height till which wallet's blocks are available starting from the requested height
+func (l *blocksListener) ScannedHeight() uint64 {
+	available := l.job.BlocksAvailable(l.returnFrom)
+	if available == 0 {
+		return l.returnFrom
+	}
+
+	return l.returnFrom + uint64(available) - 1
+}
+
 type worker struct {
 	queue     *jobsQueue
 	scanner   Scanner
@@ -243,40 +434,60 @@ type worker struct {
 
 func (w *worker) run() {
 	for {
-		job, stop := w.queue.waitJob()
+		jobs, stop := w.queue.waitJobs()
 		if stop {
 			return
 		}
 
-		w.processJob(job)
-		w.queue.jobDone(job)
+		w.processJobs(jobs)
+		w.queue.jobsDone(jobs)
 	}
 }
 
-func (w *worker) processJob(job *job) {
-	top, err := w.db.GetTopScannedHeightInfo(job.wallet.Id)
-	if err != nil {
-		job.setError(err) //TODO: turn error off after use!
-		return
-	}
+func (w *worker) processJobs(jobs []*job) {
+	tasks := make([]ScanTask, 0, len(jobs))
+	scanJobs := make([]*job, 0, len(jobs))
+	for _, job := range jobs {
+		top, err := w.db.GetTopScannedHeightInfo(job.wallet.Id)
+		if err != nil {
+			job.setError(err) //TODO: turn error off after use!
+			continue
+		}
 
-	// in case if chain split occurred we trim top detached blocks
-	job.trimHeight(top.Height)
+		// in case if chain split occurred we trim top detached blocks
+		job.trimHeight(top.Height)
 
-	job.wallet.ScannedHeight = top.Height
+		job.wallet.ScannedHeight = top.Height
 
-	start, count := job.FindMissingBlocks()
-	if count > w.maxBlocks || count == 0 {
-		count = w.maxBlocks
+		start, count := job.FindMissingBlocks()
+		if count > w.maxBlocks || count == 0 {
+			count = w.maxBlocks
+		}
+
+		tasks = append(tasks, ScanTask{
+			Wallet:      job.wallet,
+			StartHeight: start,
+			MaxBlocks:   count,
+		})
+		scanJobs = append(scanJobs, job)
+	}
+
+	if len(tasks) == 0 {
+		return
 	}
 
-	blocks, err := w.scanner.GetBlocks(start, job.wallet, count)
+	blocks, err := w.scanner.GetBlocks(tasks)
 	if err != nil {
-		job.setError(err) //TODO: turn error off after use!
+		for _, job := range scanJobs {
+			job.setError(err) //TODO: turn error off after use!
+		}
+
 		return
 	}
 
-	job.setBlocks(start, blocks)
+	for i, job := range scanJobs {
+		job.setBlocks(tasks[i].StartHeight, blocks[i])
+	}
 }
 
 func (j *job) waitBlocks(from uint64, maxCount int) ([]*WalletBlock, error) {
@@ -312,6 +523,13 @@ func (j *job) trimHeight(height uint64) {
 	j.blocks.TrimBlocks(height)
 }
 
+func (j *job) invalidateFrom(height uint64) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.blocks.InvalidateFrom(height)
+}
+
 func (j *job) FindMissingBlocks() (uint64, int) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
@@ -339,9 +557,44 @@ func (j *job) setBlocks(start uint64, blocks []*WalletBlock) {
 	defer j.lock.Unlock()
 
 	j.blocks.AddBlocks(start, blocks)
+	if j.background {
+		j.dropScannedBlocks()
+	}
+
 	j.cond.Broadcast()
 }
 
+// Keeps only the last scanned block, so the job is still seen as synced. Scanner has already saved
+// the progress, so background job doesn't need to hold the blocks. Must be locked from outside
+func (j *job) dropScannedBlocks() {
+	next, _ := j.blocks.FindMissingBlocks()
+	if next == 0 {
+		return
+	}
+
+	last := j.blocks.GetBlocks(next-1, 1)
+	if len(last) == 0 {
+		return
+	}
+
+	j.blocks = NewBlocksBulkList()
+	j.blocks.AddBlocks(next-1, last)
+}
+
+func (j *job) setSiblings(jobs []*job) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.siblings = jobs
+}
+
+func (j *job) getSiblings() []*job {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.siblings
+}
+
 func (j *job) setBcHeight(bcHeight uint64) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
@@ -349,24 +602,53 @@ func (j *job) setBcHeight(bcHeight uint64) {
 	j.blockchainHeight = bcHeight
 }
 
-func (j *job) updateJob(lastQuery time.Time, bcHeight uint64, startHeight uint64) {
+func (j *job) updateJob(lastQuery time.Time, bcHeight uint64, startHeight uint64, lookahead utils.SubaddressLookahead) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
 	j.lastQuery = lastQuery
+	j.background = false
+	if !j.wallet.Lookahead.Covers(lookahead) {
+		// blocks found with the smaller lookahead may miss outputs, the wallet is rescanned
+		j.blocks = NewBlocksBulkList()
+	}
+
+	j.wallet.Lookahead = lookahead
 	j.blockchainHeight = bcHeight
 	j.blocks.AddBlocks(startHeight, []*WalletBlock{})
 }
 
+// Subscribes the listener to blockchain changes. Must be called before the workers are started
+func (q *jobsQueue) AddChainListener(l ChainListener) {
+	q.topUpdater.listeners = append(q.topUpdater.listeners, l)
+}
+
+// Trims jobs' blocks starting from the height and wakes up workers, since there may be new blocks to scan.
+// Zero height means the split height is unknown, all the blocks are dropped then
+func (q *jobsQueue) InvalidateFrom(height uint64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, j := range q.jobs {
+		j.invalidateFrom(height)
+	}
+
+	q.cond.Broadcast()
+}
+
 type bcHeightUpdater struct {
+	lock      *sync.Mutex
 	topHeight *uint64
 	db        DbWorker
 	interval  time.Duration
 	stopCh    chan struct{}
+	top       *utils.HeightInfo // nil until the first update
+	listeners []ChainListener
 }
 
 func newBcHeightUpdater(topHeight *uint64, db DbWorker, interval time.Duration) *bcHeightUpdater {
 	return &bcHeightUpdater{
+		lock:      new(sync.Mutex),
 		topHeight: topHeight,
 		db:        db,
 		interval:  interval,
@@ -392,16 +674,92 @@ func (u *bcHeightUpdater) stop() {
 }
 
 func (u *bcHeightUpdater) updateTopBlockInfo() error {
-	height, err := u.db.GetTopBlockHeight()
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	top, err := u.readTop()
 	if err != nil {
-		logging.Log.Errorf("Failed to get top block height: %s", err.Error())
 		return err
 	}
 
-	atomic.StoreUint64(u.topHeight, height)
+	prev := u.top
+	u.top = &top
+
+	atomic.StoreUint64(u.topHeight, top.Height)
+
+	if prev == nil || *prev == top {
+		return nil
+	}
+
+	invalidFrom := prev.Height + 1
+	if u.isReorganized(*prev, top) {
+		// we don't know where exactly the chain has split, so everything is considered changed
+		logging.Log.Infof("Blockchain reorganization detected, previous top: %d (%s), new top: %d (%s)",
+			prev.Height, prev.Hash.String(), top.Height, top.Hash.String())
+		invalidFrom = 0
+	}
+
+	u.notifyListeners(invalidFrom)
 	return nil
 }
 
+// Handles blockchain trimmed from the height, when the split height is known exactly
+func (u *bcHeightUpdater) trimmed(height uint64) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	logging.Log.Infof("Blockchain trimmed from height %d", height)
+
+	top, err := u.readTop()
+	if err != nil {
+		// the top will be detected as reorganized on the next update
+		u.notifyListeners(height)
+		return err
+	}
+
+	u.top = &top
+	atomic.StoreUint64(u.topHeight, top.Height)
+
+	u.notifyListeners(height)
+	return nil
+}
+
+func (u *bcHeightUpdater) readTop() (utils.HeightInfo, error) {
+	height, err := u.db.GetTopBlockHeight()
+	if err != nil {
+		logging.Log.Errorf("Failed to get top block height: %s", err.Error())
+		return utils.HeightInfo{}, err
+	}
+
+	entry, err := u.db.GetBlockEntry(height)
+	if err != nil {
+		logging.Log.Errorf("Failed to get top block entry: %s", err.Error())
+		return utils.HeightInfo{}, err
+	}
+
+	return utils.HeightInfo{Height: height, Hash: entry.Hash}, nil
+}
+
+func (u *bcHeightUpdater) notifyListeners(height uint64) {
+	for _, l := range u.listeners {
+		l.InvalidateFrom(height)
+	}
+}
+
+func (u *bcHeightUpdater) isReorganized(prev utils.HeightInfo, top utils.HeightInfo) bool {
+	if top.Height <= prev.Height {
+		return true
+	}
+
+	entry, err := u.db.GetBlockEntry(prev.Height)
+	if err != nil {
+		logging.Log.Warningf("Failed to get block entry at previous top height %d: %s", prev.Height, err.Error())
+		return true
+	}
+
+	return entry.Hash != prev.Hash
+}
+
 type jobJanitor struct {
 	jq          *jobsQueue
 	stopCh      chan struct{}
@@ -441,7 +799,7 @@ func (jj *jobJanitor) clean() {
 
 	fresh := make([]*job, 0, len(jj.jq.jobs))
 	for _, j := range jj.jq.jobs {
-		if time.Now().Sub(j.lastQuery) < jj.jobLifetime {
+		if isJobAlive(j, jj.jobLifetime) {
 			fresh = append(fresh, j)
 		}
 	}
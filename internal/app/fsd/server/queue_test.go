@@ -0,0 +1,219 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+func makeTestQueue(workerBlocks int, batchJobs int, starts ...uint64) *jobsQueue {
+	q := NewJobsQueue(nil, nil, workerBlocks, 100, batchJobs, time.Minute)
+	q.blockchainHeight = 10000
+
+	for i, start := range starts {
+		q.addNewJob(utils.WalletEntry{Id: uint32(i)}, start)
+	}
+
+	return q
+}
+
+func getJobIds(jobs []*job) []uint32 {
+	ids := make([]uint32, 0, len(jobs))
+	for _, j := range jobs {
+		ids = append(ids, j.wallet.Id)
+	}
+
+	return ids
+}
+
+func TestWaitJobsBatchesOverlapping(t *testing.T) {
+	q := makeTestQueue(100, 3, 0, 50, 500, 60, 70)
+
+	jobs, stop := q.waitJobs()
+	assert.False(t, stop)
+	assert.Equal(t, []uint32{0, 1, 3}, getJobIds(jobs))
+
+	jobs, _ = q.waitJobs()
+	assert.Equal(t, []uint32{2}, getJobIds(jobs))
+
+	jobs, _ = q.waitJobs()
+	assert.Equal(t, []uint32{4}, getJobIds(jobs))
+}
+
+func TestWaitJobsBatchingDisabled(t *testing.T) {
+	q := makeTestQueue(100, 1, 0, 50)
+
+	jobs, _ := q.waitJobs()
+	assert.Equal(t, []uint32{0}, getJobIds(jobs))
+
+	jobs, _ = q.waitJobs()
+	assert.Equal(t, []uint32{1}, getJobIds(jobs))
+}
+
+func TestWaitJobsSiblingsFirst(t *testing.T) {
+	q := makeTestQueue(100, 2, 0, 50, 1000)
+	q.jobs[0].setSiblings([]*job{q.jobs[0], q.jobs[2]})
+
+	jobs, _ := q.waitJobs()
+	assert.Equal(t, []uint32{0, 2}, getJobIds(jobs))
+}
+
+func TestAddBackgroundJobs(t *testing.T) {
+	q := makeTestQueue(100, 1, 0)
+
+	wallets := []utils.WalletEntry{
+		{Id: 0},
+		{Id: 1, Keys: utils.WalletKeys{ViewSecretKey: moneroutil.Key{1}}, ScannedHeight: 500},
+	}
+
+	assert.Equal(t, 1, q.AddBackgroundJobs(wallets))
+	assert.Equal(t, 2, len(q.jobs))
+
+	start, _ := q.jobs[1].FindMissingBlocks()
+	assert.Equal(t, uint64(501), start)
+
+	assert.Equal(t, 0, q.AddBackgroundJobs(wallets))
+}
+
+func TestBackgroundJobLifetime(t *testing.T) {
+	q := makeTestQueue(100, 1, 0)
+	q.AddBackgroundJobs([]utils.WalletEntry{{Id: 1, Keys: utils.WalletKeys{ViewSecretKey: moneroutil.Key{1}}}})
+
+	// background job outlives request's jobs
+	for _, j := range q.jobs {
+		j.lastQuery = time.Now().Add(-2 * time.Minute)
+	}
+
+	q.jj.clean()
+	assert.Equal(t, []uint32{1}, getJobIds(q.jobs))
+
+	bg := q.jobs[0]
+	assert.True(t, q.isJobFree(bg))
+
+	bg.lastQuery = time.Now().Add(-backgroundJobLifetime)
+	assert.False(t, q.isJobFree(bg))
+	q.jj.clean()
+	assert.Empty(t, q.jobs)
+}
+
+func TestBackgroundJobDropsBlocks(t *testing.T) {
+	q := makeTestQueue(100, 1)
+	q.AddBackgroundJobs([]utils.WalletEntry{{Id: 1, ScannedHeight: 9}})
+
+	bg := q.jobs[0]
+	bg.setBlocks(10, make([]*WalletBlock, 50))
+	bg.setBlocks(60, make([]*WalletBlock, 50))
+
+	// only the last block is kept to continue from it
+	assert.Equal(t, 0, bg.BlocksAvailable(10))
+	assert.Equal(t, 1, bg.BlocksAvailable(109))
+	next, _ := bg.FindMissingBlocks()
+	assert.Equal(t, uint64(110), next)
+
+	// a request makes it a usual job, which keeps the blocks
+	q.AddJobs([]utils.WalletEntry{{Id: 1}}, 100)
+	assert.False(t, bg.background)
+	bg.setBlocks(110, make([]*WalletBlock, 10))
+	assert.Equal(t, 11, bg.BlocksAvailable(109))
+}
+
+type testChainDb struct {
+	DbWorker
+	hashes []moneroutil.Hash // index is height
+}
+
+func (d *testChainDb) GetTopBlockHeight() (uint64, error) {
+	return uint64(len(d.hashes) - 1), nil
+}
+
+func (d *testChainDb) GetBlockEntry(height uint64) (BlockEntry, error) {
+	return BlockEntry{Height: height, Hash: d.hashes[height]}, nil
+}
+
+func (d *testChainDb) setChain(length int, fork byte) {
+	d.hashes = make([]moneroutil.Hash, length)
+	for i := range d.hashes {
+		d.hashes[i] = moneroutil.Hash{byte(i), fork}
+	}
+}
+
+type testChainListener struct {
+	invalidated []uint64
+}
+
+func (l *testChainListener) InvalidateFrom(height uint64) {
+	l.invalidated = append(l.invalidated, height)
+}
+
+func TestBcHeightUpdater(t *testing.T) {
+	db := &testChainDb{}
+	db.setChain(10, 0)
+
+	var height uint64
+	listener := &testChainListener{}
+	u := newBcHeightUpdater(&height, db, time.Minute)
+	u.listeners = append(u.listeners, listener)
+
+	assert.NoError(t, u.updateTopBlockInfo())
+	assert.Equal(t, uint64(9), height)
+	assert.Empty(t, listener.invalidated)
+
+	// nothing changed
+	assert.NoError(t, u.updateTopBlockInfo())
+	assert.Empty(t, listener.invalidated)
+
+	db.setChain(12, 0)
+	assert.NoError(t, u.updateTopBlockInfo())
+	assert.Equal(t, uint64(11), height)
+	assert.Equal(t, []uint64{10}, listener.invalidated)
+
+	// reorganization noticed by polling
+	db.setChain(13, 1)
+	assert.NoError(t, u.updateTopBlockInfo())
+	assert.Equal(t, uint64(12), height)
+	assert.Equal(t, []uint64{10, 0}, listener.invalidated)
+
+	// trim notified with exact height
+	db.hashes = db.hashes[:8]
+	assert.NoError(t, u.trimmed(8))
+	assert.Equal(t, uint64(7), height)
+	assert.Equal(t, []uint64{10, 0, 8}, listener.invalidated)
+
+	db.setChain(9, 1)
+	assert.NoError(t, u.updateTopBlockInfo())
+	assert.Equal(t, []uint64{10, 0, 8, 8}, listener.invalidated)
+}
+
+func TestInvalidateJobs(t *testing.T) {
+	q := makeTestQueue(100, 1, 0)
+	q.jobs[0].setBlocks(0, make([]*WalletBlock, 20))
+
+	q.InvalidateFrom(15)
+	assert.Equal(t, 15, q.jobs[0].BlocksAvailable(0))
+}
+
+func TestQueueInvalidateUnknownSplit(t *testing.T) {
+	q := makeTestQueue(100, 1, 10, 30)
+	q.jobs[0].setBlocks(10, make([]*WalletBlock, 20))
+	q.jobs[1].setBlocks(30, make([]*WalletBlock, 5))
+
+	// polling doesn't know where the chain has split, jobs are scanned again from their start
+	q.InvalidateFrom(0)
+	for i, start := range []uint64{10, 30} {
+		assert.Equal(t, 0, q.jobs[i].BlocksAvailable(start))
+
+		next, count := q.jobs[i].FindMissingBlocks()
+		assert.Equal(t, start, next)
+		assert.Equal(t, 0, count)
+	}
+
+	// the request start is kept even if the split is below it
+	q.jobs[1].setBlocks(30, make([]*WalletBlock, 5))
+	q.InvalidateFrom(20)
+	next, _ := q.jobs[1].FindMissingBlocks()
+	assert.Equal(t, uint64(30), next)
+}
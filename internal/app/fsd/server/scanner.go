@@ -2,21 +2,39 @@ package server
 
 import (
 	"bytes"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/exantech/moneroproto"
 	"github.com/exantech/moneroutil"
 
 	"github.com/exantech/monero-fastsync/internal/pkg/logging"
 	"github.com/exantech/monero-fastsync/internal/pkg/metrics"
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 )
 
 type Scanner interface {
-	GetBlocks(startHeight uint64, wallet utils.WalletEntry, maxBlocks int) ([]*WalletBlock, error)
+	GetBlocks(tasks []ScanTask) ([][]*WalletBlock, error)
 }
 
+// ScanTask describes blocks requested for one wallet
+type ScanTask struct {
+	Wallet      utils.WalletEntry
+	StartHeight uint64
+	MaxBlocks   int
+}
+
+const (
+	// blocks ranges shorter than this are not split between goroutines
+	minScanChunk = 50
+)
+
 type BlocksScanner struct {
-	db DbWorker
+	db          DbWorker
+	subaddrKeys *subaddressTables
+	threads     int
 }
 
 type WalletBlock struct {
@@ -26,41 +44,102 @@ type WalletBlock struct {
 	OutputIndices [][]uint64
 }
 
-func NewScanner(db DbWorker) *BlocksScanner {
+// Scans blocks of a single pass in the given number of goroutines, zero means number of CPUs
+func NewScanner(db DbWorker, threads int) *BlocksScanner {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
 	return &BlocksScanner{
-		db: db,
+		db:          db,
+		subaddrKeys: newSubaddressTables(maxSubaddressKeys),
+		threads:     threads,
 	}
 }
 
-func (b *BlocksScanner) GetBlocks(startHeight uint64, wallet utils.WalletEntry, maxBlocks int) ([]*WalletBlock, error) {
-	logging.Log.Debugf("Requested blocks from height %d, processed till %d", startHeight, wallet.ScannedHeight)
+// Returns blocks for every task in the same order. Wallets which need scanning and whose ranges overlap
+// are scanned together in one pass over the blocks.
+func (b *BlocksScanner) GetBlocks(tasks []ScanTask) ([][]*WalletBlock, error) {
+	res := make([][]*WalletBlock, len(tasks))
+
+	toScan := make([]int, 0, len(tasks))
+	for i, task := range tasks {
+		logging.Log.Debugf("Requested blocks from height %d, processed till %d", task.StartHeight, task.Wallet.ScannedHeight)
 
-	if wallet.ScannedHeight >= startHeight {
-		knownCount := wallet.ScannedHeight - startHeight + 1
+		if task.Wallet.ScannedHeight < task.StartHeight {
+			toScan = append(toScan, i)
+			continue
+		}
+
+		knownCount := task.Wallet.ScannedHeight - task.StartHeight + 1
 		//inclusive from start height
-		blocks, err := b.getProcessedBlocks(wallet.Id, startHeight, utils.MinInt(maxBlocks, int(knownCount)))
+		blocks, err := b.getProcessedBlocks(task.Wallet.Id, task.StartHeight, utils.MinInt(task.MaxBlocks, int(knownCount)))
 		if err != nil {
 			logging.Log.Errorf("Failed to process job. Error on getting wallet's blocks: %s", err.Error())
 			return nil, err
 		}
 
 		metrics.BlocksCached.Mark(int64(len(blocks)))
-		return blocks, nil
+		res[i] = blocks
 	}
 
-	// the result must include start height block
-	sr, err := b.scanWalletBlocks(wallet, startHeight, maxBlocks)
-	if err != nil {
-		logging.Log.Errorf("Failed to process job. Error on scanning wallet's blocks: %s", err.Error())
-		return nil, err
+	for _, batch := range groupOverlappingTasks(tasks, toScan) {
+		batchTasks := make([]ScanTask, 0, len(batch))
+		for _, i := range batch {
+			batchTasks = append(batchTasks, tasks[i])
+		}
+
+		// the result must include start height block
+		srs, err := b.scanWalletsBlocks(batchTasks)
+		if err != nil {
+			logging.Log.Errorf("Failed to process job. Error on scanning wallet's blocks: %s", err.Error())
+			return nil, err
+		}
+
+		for j, i := range batch {
+			if err = b.db.SaveWalletProgress(tasks[i].Wallet.Id, srs[j].lastCheckedBlock); err != nil {
+				logging.Log.Warningf("Failed save wallets progress: %s. Probably chain split happened, reverting progress", err.Error())
+			}
+
+			metrics.BlocksScanned.Mark(int64(len(srs[j].blocks)))
+			res[i] = srs[j].blocks
+		}
 	}
 
-	if err = b.db.SaveWalletProgress(wallet.Id, sr.lastCheckedBlock); err != nil {
-		logging.Log.Warningf("Failed save wallets progress: %s. Probably chain split happened, reverting progress", err.Error())
+	return res, nil
+}
+
+// groups indices of tasks with overlapping scan ranges, so each group can be scanned with one pass over the blocks
+func groupOverlappingTasks(tasks []ScanTask, indices []int) [][]int {
+	sorted := make([]int, len(indices))
+	copy(sorted, indices)
+	sort.Slice(sorted, func(a, b int) bool {
+		return scanFromHeight(tasks[sorted[a]]) < scanFromHeight(tasks[sorted[b]])
+	})
+
+	groups := make([][]int, 0, len(sorted))
+	var groupEnd uint64
+	for _, i := range sorted {
+		from := scanFromHeight(tasks[i])
+		end := from + uint64(tasks[i].MaxBlocks)
+
+		if len(groups) == 0 || from >= groupEnd {
+			groups = append(groups, []int{i})
+			groupEnd = end
+			continue
+		}
+
+		groups[len(groups)-1] = append(groups[len(groups)-1], i)
+		if end > groupEnd {
+			groupEnd = end
+		}
 	}
 
-	metrics.BlocksScanned.Mark(int64(len(sr.blocks)))
-	return sr.blocks, nil
+	return groups
+}
+
+func scanFromHeight(task ScanTask) uint64 {
+	return utils.MinUint64(task.Wallet.ScannedHeight+1, task.StartHeight)
 }
 
 //include from start height
@@ -93,125 +172,354 @@ type scanResult struct {
 	lastCheckedBlock moneroutil.Hash
 }
 
-func (b *BlocksScanner) scanWalletBlocks(wallet utils.WalletEntry, startHeight uint64, maxCount int) (*scanResult, error) {
-	scanFrom := utils.MinUint64(wallet.ScannedHeight+1, startHeight)
+// walletScan keeps the state of a single wallet while scanning shared blocks range
+type walletScan struct {
+	task         ScanTask
+	scanFrom     uint64
+	scanTo       uint64 // exclusive
+	scanner      *txScanner
+	walletBlocks []moneroutil.Hash
+	result       scanResult
+}
 
-	outs, err := b.db.GetWalletOutputs(wallet.Id)
-	if err != nil {
-		logging.Log.Errorf("Failed to get outputs from DB from height: %s", maxCount)
-		return nil, err
+func (w *walletScan) covers(height uint64) bool {
+	return height >= w.scanFrom && height < w.scanTo
+}
+
+// Returns indices of the scans covering the height
+func activeScans(scans []*walletScan, height uint64) []int {
+	active := make([]int, 0, len(scans))
+	for i, ws := range scans {
+		if ws.covers(height) {
+			active = append(active, i)
+		}
 	}
 
-	logging.Log.Debugf("Wallet has %d outputs in db", len(outs))
+	return active
+}
+
+// foundOutputs are wallet's outputs found in a block's transaction
+type foundOutputs struct {
+	tx   int // index in block
+	scan int // index of wallet's scan
+	outs []OutputHeight
+}
 
-	logging.Log.Debugf("Requesting blocks %d to process from height %d", maxCount, scanFrom)
+// scans blocks range covering all the tasks once, checking every transaction against each wallet
+func (b *BlocksScanner) scanWalletsBlocks(tasks []ScanTask) ([]*scanResult, error) {
+	scans := make([]*walletScan, 0, len(tasks))
+	var scanFrom, scanTo uint64
+	for i, task := range tasks {
+		outs, err := b.db.GetWalletOutputs(task.Wallet.Id)
+		if err != nil {
+			logging.Log.Errorf("Failed to get outputs of wallet %d from DB: %s", task.Wallet.Id, err.Error())
+			return nil, err
+		}
+
+		logging.Log.Debugf("Wallet %d has %d outputs in db", task.Wallet.Id, len(outs))
+
+		ws := &walletScan{
+			task:         task,
+			scanFrom:     scanFromHeight(task),
+			scanner:      newTxScanner(task.Wallet.Id, task.Wallet.Keys, b.subaddrKeys.get(task.Wallet), outs),
+			walletBlocks: make([]moneroutil.Hash, 0, task.MaxBlocks),
+			result: scanResult{
+				blocks: make([]*WalletBlock, 0, task.MaxBlocks),
+			},
+		}
+		ws.scanTo = ws.scanFrom + uint64(task.MaxBlocks)
+
+		if i == 0 || ws.scanFrom < scanFrom {
+			scanFrom = ws.scanFrom
+		}
+
+		if ws.scanTo > scanTo {
+			scanTo = ws.scanTo
+		}
+
+		scans = append(scans, ws)
+	}
+
+	maxCount := int(scanTo - scanFrom)
+	logging.Log.Debugf("Requesting blocks %d to process from height %d for %d wallets", maxCount, scanFrom, len(scans))
 	blocks, err := b.db.GetBlocksAbove(scanFrom, maxCount)
 	if err != nil {
-		logging.Log.Errorf("Failed to get blocks from DB from height %d: %s", scanFrom, maxCount)
+		logging.Log.Errorf("Failed to get %d blocks from DB from height %d: %s", maxCount, scanFrom, err.Error())
 		return nil, err
 	}
 
 	logging.Log.Debugf("Retrieved %d blocks", len(blocks))
 
-	scanner := newTxScanner(wallet.Id, wallet.Keys, outs)
+	// deriving is the most expensive part, so outputs are searched in parallel, while the results
+	// are merged in height order, since found outputs affect mixins detection in the following blocks
+	found, err := b.findOutputsParallel(blocks, scans)
+	if err != nil {
+		return nil, err
+	}
 
-	var lastBlockHash moneroutil.Hash
-	walletBlocks := make([]moneroutil.Hash, 0, maxCount)
-	foundBlocks := make([]*WalletBlock, 0, maxCount)
-	for _, block := range blocks {
-		found := false
-		for _, tx := range block.Txs {
-			prefix, err := moneroutil.ParseTransactionPrefixBytes(tx.Blob)
-			if err != nil {
-				logging.Log.Errorf("Failed to parse transaction prefix for %s: %s", tx.Hash.String(), err.Error())
-				return nil, err
+	for bi, block := range blocks {
+		if len(activeScans(scans, block.Height)) == 0 {
+			continue
+		}
+
+		blockFound := found[bi]
+		foundInBlock := make([]bool, len(scans))
+		for ti, tx := range block.Txs {
+			for len(blockFound) != 0 && blockFound[0].tx == ti {
+				scans[blockFound[0].scan].scanner.addOutputs(blockFound[0].outs)
+				foundInBlock[blockFound[0].scan] = true
+				blockFound = blockFound[1:]
 			}
 
-			r := bytes.NewReader(prefix.Extra)
-			extra, err := moneroutil.ParseTransactionExtra(r)
-			if err != nil {
-				// this is not critical
-				logging.Log.Warningf("Failed to parse transaction extra for %s: %s", tx.Hash.String(), err.Error())
+			for i, ws := range scans {
+				if ws.covers(block.Height) && ws.scanner.searchWalletMixins(tx.UsedInputs) {
+					foundInBlock[i] = true
+				}
+			}
+		}
+
+		// converted once and shared between wallets
+		var converted *WalletBlock
+		for i, ws := range scans {
+			if !ws.covers(block.Height) {
 				continue
 			}
 
-			if scanner.searchWalletOutputs(block.Height, extra.PubKeys, tx.OutputKeys, tx.OutputIndices) {
-				found = true
+			ws.result.lastCheckedBlock = block.Hash
+
+			if foundInBlock[i] {
+				ws.walletBlocks = append(ws.walletBlocks, block.Hash)
 			}
 
-			if scanner.searchWalletMixins(tx.UsedInputs) {
-				found = true
+			if block.Height < ws.task.StartHeight {
+				continue
+			}
+
+			if !foundInBlock[i] {
+				ws.result.blocks = append(ws.result.blocks, &WalletBlock{Hash: block.Hash})
+				continue
 			}
-		}
 
-		lastBlockHash = block.Hash
+			if converted == nil {
+				converted, err = convertPreparsedToWalletBlock(block)
+				if err != nil {
+					return nil, err
+				}
+			}
 
-		if found {
-			walletBlocks = append(walletBlocks, block.Hash)
+			ws.result.blocks = append(ws.result.blocks, converted)
 		}
+	}
 
-		if found && block.Height >= startHeight {
-			b, err := convertPreparsedToWalletBlock(block)
-			if err != nil {
+	res := make([]*scanResult, 0, len(scans))
+	for _, ws := range scans {
+		if len(ws.walletBlocks) != 0 {
+			if err = b.db.SaveWalletBlocks(ws.task.Wallet.Id, ws.walletBlocks, ws.scanner.newOuts); err != nil {
+				logging.Log.Errorf("Failed to save found outputs: %s", err.Error())
 				return nil, err
 			}
+		}
+
+		res = append(res, &ws.result)
+	}
+
+	return res, nil
+}
+
+// Splits blocks into chunks and searches for outputs of every wallet in each chunk concurrently.
+// Wallets' state is not modified, so found outputs must be added afterwards in height order
+func (b *BlocksScanner) findOutputsParallel(blocks []PreparsedBlock, scans []*walletScan) ([][]foundOutputs, error) {
+	res := make([][]foundOutputs, len(blocks))
+	if len(blocks) == 0 {
+		return res, nil
+	}
+
+	chunkSize := (len(blocks) + b.threads - 1) / b.threads
+	if chunkSize < minScanChunk {
+		chunkSize = minScanChunk
+	}
+
+	errs := make([]error, (len(blocks)+chunkSize-1)/chunkSize)
+
+	var wg sync.WaitGroup
+	for chunk := range errs {
+		start := chunk * chunkSize
+		end := utils.MinInt(start+chunkSize, len(blocks))
+
+		wg.Add(1)
+		go func(chunk int, start int, end int) {
+			defer wg.Done()
+
+			for i := start; i < end; i++ {
+				res[i], errs[chunk] = findBlockOutputs(&blocks[i], scans)
+				if errs[chunk] != nil {
+					return
+				}
+			}
+		}(chunk, start, end)
+	}
+
+	wg.Wait()
 
-			foundBlocks = append(foundBlocks, b)
-		} else if block.Height >= startHeight {
-			foundBlocks = append(foundBlocks, &WalletBlock{Hash: block.Hash})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	if len(walletBlocks) != 0 {
-		if err = b.db.SaveWalletBlocks(wallet.Id, walletBlocks, scanner.newOuts); err != nil {
-			logging.Log.Errorf("Failed to save found outputs: %s", err.Error())
+	return res, nil
+}
+
+// Returns outputs found in the block ordered by transactions
+func findBlockOutputs(block *PreparsedBlock, scans []*walletScan) ([]foundOutputs, error) {
+	active := activeScans(scans, block.Height)
+	if len(active) == 0 {
+		return nil, nil
+	}
+
+	var res []foundOutputs
+	for ti, tx := range block.Txs {
+		pubKeys, additionalPubKeys, err := getTxPubKeys(tx)
+		if err != nil {
 			return nil, err
 		}
+
+		for _, i := range active {
+			outs := scans[i].scanner.findWalletOutputs(block.Height, pubKeys, additionalPubKeys, tx.OutputKeys, tx.ViewTags, tx.OutputIndices)
+			if len(outs) != 0 {
+				res = append(res, foundOutputs{tx: ti, scan: i, outs: outs})
+			}
+		}
 	}
 
-	return &scanResult{
-		blocks:           foundBlocks,
-		lastCheckedBlock: lastBlockHash,
-	}, nil
+	return res, nil
+}
+
+// Returns public keys extracted by syncer. Transactions saved before the keys extraction are parsed
+func getTxPubKeys(tx PreparsedTx) ([]moneroutil.Key, []moneroutil.Key, error) {
+	if tx.PubKeys != nil {
+		return tx.PubKeys, tx.AdditionalPubKeys, nil
+	}
+
+	prefix, err := txparser.ParseTxPrefixBytes(tx.Blob)
+	if err != nil {
+		logging.Log.Errorf("Failed to parse transaction prefix for %s: %s", tx.Hash.String(), err.Error())
+		return nil, nil, err
+	}
+
+	extra, err := txparser.ParseTxExtra(prefix.Extra)
+	if err != nil {
+		// this is not critical, keys parsed before the error are still used
+		logging.Log.Warningf("Failed to parse transaction extra for %s: %s", tx.Hash.String(), err.Error())
+	}
+
+	return extra.PubKeys, extra.AdditionalPubKeys, nil
 }
 
 type txScanner struct {
-	id      uint32
-	wallet  utils.WalletKeys
-	newOuts []OutputHeight
-	outs    map[uint64]bool
+	id          uint32
+	wallet      utils.WalletKeys
+	subaddrKeys *subaddressTable
+	newOuts     []OutputHeight
+	outs        map[uint64]bool
 }
 
-func newTxScanner(walletId uint32, keys utils.WalletKeys, outs []OutputHeight) *txScanner {
+func newTxScanner(walletId uint32, keys utils.WalletKeys, subaddrKeys *subaddressTable, outs []OutputHeight) *txScanner {
 	o := make(map[uint64]bool)
 	for _, out := range outs {
 		o[out.OutputIndex] = true
 	}
 
 	return &txScanner{
-		id:      walletId,
-		wallet:  keys,
-		outs:    o,
-		newOuts: []OutputHeight{},
+		id:          walletId,
+		wallet:      keys,
+		subaddrKeys: subaddrKeys,
+		outs:        o,
+		newOuts:     []OutputHeight{},
 	}
 }
 
-func (t *txScanner) searchWalletOutputs(height uint64, txPubKeys []moneroutil.Key, outputKeys []moneroutil.Key, globalIndices []uint64) bool {
-	found := false
+func (t *txScanner) searchWalletOutputs(height uint64, txPubKeys []moneroutil.Key, additionalPubKeys []moneroutil.Key,
+	outputKeys []moneroutil.Key, viewTags []byte, globalIndices []uint64) bool {
+
+	outs := t.findWalletOutputs(height, txPubKeys, additionalPubKeys, outputKeys, viewTags, globalIndices)
+	t.addOutputs(outs)
+
+	return len(outs) != 0
+}
+
+// Main transaction public keys are checked against every output, while an additional public key
+// is used only for the output with the same index. View tags, if present, are checked before deriving output's key.
+// Doesn't modify the scanner, so it's safe to call concurrently
+func (t *txScanner) findWalletOutputs(height uint64, txPubKeys []moneroutil.Key, additionalPubKeys []moneroutil.Key,
+	outputKeys []moneroutil.Key, viewTags []byte, globalIndices []uint64) []OutputHeight {
+
+	if len(viewTags) != len(outputKeys) {
+		viewTags = nil
+	}
+
+	var res []OutputHeight
+	found := make([]bool, len(outputKeys))
 	for _, pubKey := range txPubKeys {
 		derivation := moneroutil.KeyDerivation(&t.wallet.ViewSecretKey, &pubKey)
 
-		for oi, outKey := range outputKeys {
-			if outKey == calcOutputPubKey(derivation, oi, t.wallet.SpendPublicKey) {
-				t.newOuts = append(t.newOuts, OutputHeight{globalIndices[oi], height})
-				t.outs[globalIndices[oi]] = true
+		for oi := range outputKeys {
+			if viewTags != nil && viewTags[oi] != txparser.DeriveViewTag(derivation, oi) {
+				continue
+			}
 
-				found = true
+			if out, ok := t.checkOutput(height, derivation, oi, outputKeys[oi], globalIndices[oi]); ok {
+				res = append(res, out)
+				found[oi] = true
 			}
 		}
 	}
 
-	return found
+	if len(additionalPubKeys) != len(outputKeys) {
+		return res
+	}
+
+	for oi := range outputKeys {
+		if found[oi] {
+			// already found with the main public key
+			continue
+		}
+
+		derivation := moneroutil.KeyDerivation(&t.wallet.ViewSecretKey, &additionalPubKeys[oi])
+		if viewTags != nil && viewTags[oi] != txparser.DeriveViewTag(derivation, oi) {
+			continue
+		}
+
+		if out, ok := t.checkOutput(height, derivation, oi, outputKeys[oi], globalIndices[oi]); ok {
+			res = append(res, out)
+		}
+	}
+
+	return res
+}
+
+func (t *txScanner) checkOutput(height uint64, derivation moneroutil.Key, oi int, outKey moneroutil.Key, globalIndex uint64) (OutputHeight, bool) {
+	index, ok := t.subaddrKeys.find(calcOutputSpendKey(derivation, oi, outKey))
+	if !ok {
+		return OutputHeight{}, false
+	}
+
+	return OutputHeight{
+		OutputIndex: globalIndex,
+		Height:      height,
+		Subaddress:  index,
+	}, true
+}
+
+func (t *txScanner) addOutputs(outs []OutputHeight) {
+	for _, out := range outs {
+		if t.outs[out.OutputIndex] {
+			continue
+		}
+
+		t.newOuts = append(t.newOuts, out)
+		t.outs[out.OutputIndex] = true
+	}
 }
 
 func (t *txScanner) searchWalletMixins(inputs []uint64) bool {
@@ -225,7 +533,8 @@ func (t *txScanner) searchWalletMixins(inputs []uint64) bool {
 	return false
 }
 
-func calcOutputPubKey(derivation moneroutil.Key, index int, spendPublic moneroutil.Key) moneroutil.Key {
+// Recovers spend public key of the recipient: D = P - Hs(derivation || index)*G
+func calcOutputSpendKey(derivation moneroutil.Key, index int, outputKey moneroutil.Key) moneroutil.Key {
 	buf := make([]byte, moneroutil.KeyLength)
 	copy(buf, derivation[:])
 	buf = append(buf, moneroutil.Uint64ToBytes(uint64(index))...)
@@ -233,9 +542,9 @@ func calcOutputPubKey(derivation moneroutil.Key, index int, spendPublic monerout
 	k := moneroutil.HashToScalar(buf)
 	K := k.PubKey()
 
-	P := moneroutil.Identity
-	moneroutil.AddKeys(&P, K, &spendPublic)
-	return P
+	var D moneroutil.Key
+	moneroutil.SubKeys(&D, &outputKey, K)
+	return D
 }
 
 func convertPreparsedToWalletBlock(block PreparsedBlock) (*WalletBlock, error) {
@@ -248,25 +557,13 @@ func convertPreparsedToWalletBlock(block PreparsedBlock) (*WalletBlock, error) {
 		return res, err
 	}
 
-	miner, err := moneroutil.ParseTransactionBytes(block.Txs[0].Blob)
-	if err != nil {
-		logging.Log.Errorf("Failed to parse transaction (hash: %s): %s", block.Txs[0].Hash.String(), err.Error())
-		return res, err
-	}
-
 	hashes := make([]moneroutil.Hash, 0, len(block.Txs)-1)
 	for i := 1; i < len(block.Txs); i++ {
 		hashes = append(hashes, block.Txs[i].Hash)
 	}
 
-	b := moneroutil.Block{
-		BlockHeader: *header,
-		MinerTx:     *miner,
-		TxHashes:    hashes,
-	}
-
 	bce := moneroproto.BlockCompleteEntry{}
-	bce.Block = serializeBlock(&b, block.Txs[0].Blob)
+	bce.Block = serializeBlock(block.Header, block.Txs[0].Blob, hashes)
 
 	res.OutputIndices = make([][]uint64, 0, len(block.Txs))
 	res.OutputIndices = append(res.OutputIndices, block.Txs[0].OutputIndices)
@@ -298,25 +595,13 @@ func convertPreserializedToWalletBlock(block PreSerializedBlock) (*WalletBlock,
 		return res, err
 	}
 
-	miner, err := moneroutil.ParseTransactionBytes(block.Txs[0].Blob)
-	if err != nil {
-		logging.Log.Errorf("Failed to parse transaction (hash: %s): %s", block.Txs[0].Hash.String(), err.Error())
-		return res, err
-	}
-
 	hashes := make([]moneroutil.Hash, 0, len(block.Txs)-1)
 	for i := 1; i < len(block.Txs); i++ {
 		hashes = append(hashes, block.Txs[i].Hash)
 	}
 
-	b := moneroutil.Block{
-		BlockHeader: *header,
-		MinerTx:     *miner,
-		TxHashes:    hashes,
-	}
-
 	bce := moneroproto.BlockCompleteEntry{}
-	bce.Block = serializeBlock(&b, block.Txs[0].Blob)
+	bce.Block = serializeBlock(block.Header, block.Txs[0].Blob, hashes)
 
 	res.OutputIndices = make([][]uint64, 0, len(block.Txs))
 	res.OutputIndices = append(res.OutputIndices, block.Txs[0].OutputIndices)
@@ -334,14 +619,14 @@ func convertPreserializedToWalletBlock(block PreSerializedBlock) (*WalletBlock,
 	return res, nil
 }
 
-// TODO: make it part of moneroutil package!
-func serializeBlock(b *moneroutil.Block, minerTx []byte) []byte {
-	ser := make([]byte, 0)
-	ser = append(ser, b.SerializeBlockHeader()...)
+// header and miner transaction are stored as is, so there's no need to parse and serialize them again
+func serializeBlock(header []byte, minerTx []byte, txHashes []moneroutil.Hash) []byte {
+	ser := make([]byte, 0, len(header)+len(minerTx)+8+len(txHashes)*moneroutil.HashLength)
+	ser = append(ser, header...)
 	ser = append(ser, minerTx...)
-	ser = append(ser, moneroutil.Uint64ToBytes(uint64(len(b.TxHashes)))...)
-	for _, h := range b.TxHashes {
-		ser = append(ser, h.Serialize()...)
+	ser = append(ser, moneroutil.Uint64ToBytes(uint64(len(txHashes)))...)
+	for _, h := range txHashes {
+		ser = append(ser, h[:]...)
 	}
 	return ser
 }
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+func makeTestTask(scannedHeight uint64, startHeight uint64, maxBlocks int) ScanTask {
+	return ScanTask{
+		Wallet:      utils.WalletEntry{ScannedHeight: scannedHeight},
+		StartHeight: startHeight,
+		MaxBlocks:   maxBlocks,
+	}
+}
+
+func TestGroupOverlappingTasksSingle(t *testing.T) {
+	tasks := []ScanTask{makeTestTask(10, 20, 100)}
+
+	assert.Equal(t, [][]int{{0}}, groupOverlappingTasks(tasks, []int{0}))
+}
+
+func TestGroupOverlappingTasksOverlap(t *testing.T) {
+	tasks := []ScanTask{
+		makeTestTask(100, 150, 100),
+		makeTestTask(10, 20, 100),
+		makeTestTask(50, 70, 100),
+	}
+
+	assert.Equal(t, [][]int{{1, 2, 0}}, groupOverlappingTasks(tasks, []int{0, 1, 2}))
+}
+
+func TestGroupOverlappingTasksDisjoint(t *testing.T) {
+	tasks := []ScanTask{
+		makeTestTask(1000, 1500, 100),
+		makeTestTask(10, 20, 100),
+		makeTestTask(50, 70, 100),
+	}
+
+	assert.Equal(t, [][]int{{1, 2}, {0}}, groupOverlappingTasks(tasks, []int{0, 1, 2}))
+}
+
+func TestGroupOverlappingTasksAdjacent(t *testing.T) {
+	tasks := []ScanTask{
+		makeTestTask(9, 20, 100),
+		makeTestTask(109, 200, 100),
+	}
+
+	assert.Equal(t, [][]int{{0}, {1}}, groupOverlappingTasks(tasks, []int{0, 1}))
+}
+
+func TestGroupOverlappingTasksSubset(t *testing.T) {
+	tasks := []ScanTask{
+		makeTestTask(10, 20, 100),
+		makeTestTask(100, 150, 100),
+		makeTestTask(1000, 1500, 100),
+	}
+
+	assert.Equal(t, [][]int{{2}}, groupOverlappingTasks(tasks, []int{2}))
+}
+
+func scalarMultKey(scalar *moneroutil.Key, point *moneroutil.Key) moneroutil.Key {
+	p := new(moneroutil.ExtendedGroupElement)
+	p.FromBytes(point)
+
+	r := new(moneroutil.ProjectiveGroupElement)
+	moneroutil.GeScalarMult(r, scalar, p)
+
+	var res moneroutil.Key
+	r.ToBytes(&res)
+	return res
+}
+
+// makes output the way sender does: returns tx public key and output key
+func makeTestOutput(viewPublic, spendPublic moneroutil.Key, subaddress bool, index int) (moneroutil.Key, moneroutil.Key) {
+	r := moneroutil.RandomScalar()
+
+	var R moneroutil.Key
+	if subaddress {
+		R = scalarMultKey(r, &spendPublic)
+	} else {
+		R = *r.PubKey()
+	}
+
+	derivation := moneroutil.KeyDerivation(r, &viewPublic)
+	buf := append(derivation[:], moneroutil.Uint64ToBytes(uint64(index))...)
+
+	P := moneroutil.Identity
+	moneroutil.AddKeys(&P, moneroutil.HashToScalar(buf).PubKey(), &spendPublic)
+	return R, P
+}
+
+func makeTestWallet() (utils.WalletKeys, moneroutil.Key) {
+	viewSecret, viewPublic := moneroutil.NewKeyPair()
+	_, spendPublic := moneroutil.NewKeyPair()
+
+	return utils.WalletKeys{ViewSecretKey: *viewSecret, SpendPublicKey: *spendPublic}, *viewPublic
+}
+
+func TestTxScannerMainAddress(t *testing.T) {
+	keys, viewPublic := makeTestWallet()
+	lookahead := utils.SubaddressLookahead{Major: 2, Minor: 3}
+	scanner := newTxScanner(1, keys, newSubaddressTable(keys, lookahead), nil)
+
+	R, P := makeTestOutput(viewPublic, keys.SpendPublicKey, false, 1)
+	_, other := makeTestOutput(viewPublic, keys.SpendPublicKey, false, 0)
+
+	assert.True(t, scanner.searchWalletOutputs(10, []moneroutil.Key{R}, nil, []moneroutil.Key{other, P}, nil, []uint64{100, 101}))
+	assert.Equal(t, []OutputHeight{{OutputIndex: 101, Height: 10}}, scanner.newOuts)
+}
+
+func TestTxScannerSubaddress(t *testing.T) {
+	keys, _ := makeTestWallet()
+	lookahead := utils.SubaddressLookahead{Major: 2, Minor: 3}
+	scanner := newTxScanner(1, keys, newSubaddressTable(keys, lookahead), nil)
+
+	index := utils.SubaddressIndex{Major: 1, Minor: 2}
+	D := calcSubaddressSpendKey(keys, index)
+	C := scalarMultKey(&keys.ViewSecretKey, &D)
+
+	R, P := makeTestOutput(C, D, true, 0)
+
+	assert.True(t, scanner.searchWalletOutputs(10, []moneroutil.Key{R}, nil, []moneroutil.Key{P}, nil, []uint64{100}))
+	assert.Equal(t, []OutputHeight{{OutputIndex: 100, Height: 10, Subaddress: index}}, scanner.newOuts)
+	assert.True(t, scanner.searchWalletMixins([]uint64{5, 100}))
+}
+
+func TestTxScannerSubaddressOutOfLookahead(t *testing.T) {
+	keys, _ := makeTestWallet()
+	lookahead := utils.SubaddressLookahead{Major: 1, Minor: 3}
+	scanner := newTxScanner(1, keys, newSubaddressTable(keys, lookahead), nil)
+
+	D := calcSubaddressSpendKey(keys, utils.SubaddressIndex{Major: 1, Minor: 2})
+	C := scalarMultKey(&keys.ViewSecretKey, &D)
+
+	R, P := makeTestOutput(C, D, true, 0)
+
+	assert.False(t, scanner.searchWalletOutputs(10, []moneroutil.Key{R}, nil, []moneroutil.Key{P}, nil, []uint64{100}))
+	assert.Empty(t, scanner.newOuts)
+}
+
+func TestTxScannerAdditionalPubKeys(t *testing.T) {
+	keys, viewPublic := makeTestWallet()
+	lookahead := utils.SubaddressLookahead{Major: 2, Minor: 3}
+	scanner := newTxScanner(1, keys, newSubaddressTable(keys, lookahead), nil)
+
+	index := utils.SubaddressIndex{Major: 0, Minor: 1}
+	D := calcSubaddressSpendKey(keys, index)
+	C := scalarMultKey(&keys.ViewSecretKey, &D)
+
+	otherKeys, otherView := makeTestWallet()
+	R0, P0 := makeTestOutput(otherView, otherKeys.SpendPublicKey, false, 0)
+	R1, P1 := makeTestOutput(C, D, true, 1)
+	mainKey, _ := makeTestOutput(viewPublic, keys.SpendPublicKey, false, 0)
+
+	assert.True(t, scanner.searchWalletOutputs(10, []moneroutil.Key{mainKey}, []moneroutil.Key{R0, R1},
+		[]moneroutil.Key{P0, P1}, nil, []uint64{100, 101}))
+	assert.Equal(t, []OutputHeight{{OutputIndex: 101, Height: 10, Subaddress: index}}, scanner.newOuts)
+}
+
+func TestTxScannerAdditionalPubKeysCountMismatch(t *testing.T) {
+	keys, _ := makeTestWallet()
+	lookahead := utils.SubaddressLookahead{Major: 2, Minor: 3}
+	scanner := newTxScanner(1, keys, newSubaddressTable(keys, lookahead), nil)
+
+	D := calcSubaddressSpendKey(keys, utils.SubaddressIndex{Major: 0, Minor: 1})
+	C := scalarMultKey(&keys.ViewSecretKey, &D)
+
+	R0, P0 := makeTestOutput(C, D, true, 0)
+
+	assert.False(t, scanner.searchWalletOutputs(10, nil, []moneroutil.Key{R0, R0},
+		[]moneroutil.Key{P0}, nil, []uint64{100}))
+}
+
+func TestTxScannerViewTags(t *testing.T) {
+	keys, viewPublic := makeTestWallet()
+	scanner := newTxScanner(1, keys, newSubaddressTable(keys, utils.SubaddressLookahead{}), nil)
+
+	R, P := makeTestOutput(viewPublic, keys.SpendPublicKey, false, 0)
+	tag := txparser.DeriveViewTag(moneroutil.KeyDerivation(&keys.ViewSecretKey, &R), 0)
+
+	// output is skipped on view tag mismatch without deriving its key
+	assert.False(t, scanner.searchWalletOutputs(10, []moneroutil.Key{R}, nil, []moneroutil.Key{P}, []byte{tag + 1}, []uint64{100}))
+	assert.True(t, scanner.searchWalletOutputs(10, []moneroutil.Key{R}, nil, []moneroutil.Key{P}, []byte{tag}, []uint64{100}))
+	assert.Equal(t, []OutputHeight{{OutputIndex: 100, Height: 10}}, scanner.newOuts)
+}
+
+func TestSubaddressTablesEviction(t *testing.T) {
+	tables := newSubaddressTables(20)
+	wallet := func(id uint32, minor uint32) utils.WalletEntry {
+		keys, _ := makeTestWallet()
+		return utils.WalletEntry{Id: id, Keys: keys, Lookahead: utils.SubaddressLookahead{Major: 1, Minor: minor}}
+	}
+
+	w1 := wallet(1, 10)
+	t1 := tables.get(w1)
+	assert.Len(t, t1.keys, 10)
+	assert.True(t, t1 == tables.get(w1))
+
+	// the least recently used table is evicted to keep total number of keys
+	tables.get(wallet(2, 5))
+	tables.get(w1)
+	tables.get(wallet(3, 8))
+	assert.Equal(t, 18, tables.keys)
+	assert.Len(t, tables.tables, 2)
+	assert.Nil(t, tables.tables[2])
+
+	// rebuilt table replaces the old one
+	w1.Lookahead.Minor = 12
+	assert.Len(t, tables.get(w1).keys, 12)
+	assert.Equal(t, 20, tables.keys)
+}
+
+type testScanDb struct {
+	DbWorker
+	blocks      []PreparsedBlock
+	savedBlocks []moneroutil.Hash
+	savedOuts   []OutputHeight
+}
+
+func (d *testScanDb) GetWalletOutputs(walletId uint32) ([]OutputHeight, error) {
+	return nil, nil
+}
+
+func (d *testScanDb) GetBlocksAbove(startHeight uint64, maxCount int) ([]PreparsedBlock, error) {
+	res := make([]PreparsedBlock, 0, maxCount)
+	for _, b := range d.blocks {
+		if b.Height >= startHeight && b.Height < startHeight+uint64(maxCount) {
+			res = append(res, b)
+		}
+	}
+
+	return res, nil
+}
+
+func (d *testScanDb) SaveWalletBlocks(walletId uint32, blocks []moneroutil.Hash, outputs []OutputHeight) error {
+	d.savedBlocks = append(d.savedBlocks, blocks...)
+	d.savedOuts = append(d.savedOuts, outputs...)
+	return nil
+}
+
+func makeTestScanBlock(height uint64, tx PreparsedTx) PreparsedBlock {
+	var hash moneroutil.Hash
+	copy(hash[:], moneroutil.Uint64ToBytes(height))
+
+	return PreparsedBlock{
+		BlockEntry: BlockEntry{Height: height, Hash: hash},
+		Txs:        []PreparsedTx{tx},
+	}
+}
+
+func TestScanWalletsBlocksAcrossChunks(t *testing.T) {
+	keys, viewPublic := makeTestWallet()
+
+	db := &testScanDb{}
+	for h := uint64(1); h <= 200; h++ {
+		_, pub := moneroutil.NewKeyPair()
+		_, out := moneroutil.NewKeyPair()
+		tx := PreparsedTx{
+			PubKeys:       []moneroutil.Key{*pub},
+			OutputKeys:    []moneroutil.Key{*out},
+			OutputIndices: []uint64{h},
+			UsedInputs:    []uint64{},
+		}
+
+		switch h {
+		case 10:
+			R, P := makeTestOutput(viewPublic, keys.SpendPublicKey, false, 0)
+			tx.PubKeys = []moneroutil.Key{R}
+			tx.OutputKeys = []moneroutil.Key{P}
+		case 180:
+			// spends the output found in the first chunk
+			tx.UsedInputs = []uint64{10}
+		}
+
+		db.blocks = append(db.blocks, makeTestScanBlock(h, tx))
+	}
+
+	scanner := NewScanner(db, 4)
+
+	// blocks below start height aren't converted, so headers may be omitted
+	task := ScanTask{
+		Wallet:      utils.WalletEntry{Id: 1, Keys: keys},
+		StartHeight: 1000,
+		MaxBlocks:   200,
+	}
+
+	res, err := scanner.scanWalletsBlocks([]ScanTask{task})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(res))
+	assert.Equal(t, db.blocks[199].Hash, res[0].lastCheckedBlock)
+
+	assert.Equal(t, []moneroutil.Hash{db.blocks[9].Hash, db.blocks[179].Hash}, db.savedBlocks)
+	assert.Equal(t, []OutputHeight{{OutputIndex: 10, Height: 10}}, db.savedOuts)
+}
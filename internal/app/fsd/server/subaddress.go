@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/exantech/moneroutil"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+const (
+	// total subaddresses in cached tables, an entry takes about 100 bytes
+	maxSubaddressKeys = 2000000
+	// tables built at once, building takes a scalar multiplication per subaddress
+	maxSubaddressBuilds = 2
+)
+
+var (
+	subaddressPrefix = []byte("SubAddr\x00")
+)
+
+// subaddressTable maps subaddresses' spend public keys to their indices
+type subaddressTable struct {
+	lookahead utils.SubaddressLookahead
+	keys      map[moneroutil.Key]utils.SubaddressIndex
+	lastUsed  uint64
+}
+
+func newSubaddressTable(keys utils.WalletKeys, lookahead utils.SubaddressLookahead) *subaddressTable {
+	t := &subaddressTable{
+		lookahead: lookahead,
+		keys:      make(map[moneroutil.Key]utils.SubaddressIndex, int(lookahead.Major)*int(lookahead.Minor)+1),
+	}
+
+	// main address is always there even with zero lookahead
+	t.keys[keys.SpendPublicKey] = utils.SubaddressIndex{}
+
+	for major := uint32(0); major < lookahead.Major; major++ {
+		for minor := uint32(0); minor < lookahead.Minor; minor++ {
+			if major == 0 && minor == 0 {
+				continue
+			}
+
+			index := utils.SubaddressIndex{Major: major, Minor: minor}
+			t.keys[calcSubaddressSpendKey(keys, index)] = index
+		}
+	}
+
+	return t
+}
+
+func (t *subaddressTable) find(spendKey moneroutil.Key) (utils.SubaddressIndex, bool) {
+	index, ok := t.keys[spendKey]
+	return index, ok
+}
+
+// D = B + Hs("SubAddr\0" || a || major || minor)*G
+func calcSubaddressSpendKey(keys utils.WalletKeys, index utils.SubaddressIndex) moneroutil.Key {
+	indices := make([]byte, 8)
+	binary.LittleEndian.PutUint32(indices[0:4], index.Major)
+	binary.LittleEndian.PutUint32(indices[4:8], index.Minor)
+
+	m := moneroutil.HashToScalar(subaddressPrefix, keys.ViewSecretKey[:], indices)
+
+	D := moneroutil.Identity
+	moneroutil.AddKeys(&D, &keys.SpendPublicKey, m.PubKey())
+	return D
+}
+
+// subaddressTables caches tables between scans since building large lookahead is expensive
+type subaddressTables struct {
+	lock    *sync.Mutex
+	tables  map[uint32]*subaddressTable
+	keys    int // number of subaddresses in all cached tables
+	maxKeys int
+	counter uint64
+	builds  chan struct{}
+}
+
+func newSubaddressTables(maxKeys int) *subaddressTables {
+	return &subaddressTables{
+		lock:    new(sync.Mutex),
+		tables:  make(map[uint32]*subaddressTable),
+		maxKeys: maxKeys,
+		builds:  make(chan struct{}, maxSubaddressBuilds),
+	}
+}
+
+func (s *subaddressTables) get(wallet utils.WalletEntry) *subaddressTable {
+	s.lock.Lock()
+	t, ok := s.tables[wallet.Id]
+	s.counter++
+	if ok && t.lookahead == wallet.Lookahead {
+		t.lastUsed = s.counter
+		s.lock.Unlock()
+		return t
+	}
+	s.lock.Unlock()
+
+	// built outside of the lock, concurrent scans of the same wallet may build it twice, which is fine
+	s.builds <- struct{}{}
+	t = newSubaddressTable(wallet.Keys, wallet.Lookahead)
+	<-s.builds
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if old, ok := s.tables[wallet.Id]; ok {
+		s.keys -= len(old.keys)
+		delete(s.tables, wallet.Id)
+	}
+
+	for len(s.tables) != 0 && s.keys+len(t.keys) > s.maxKeys {
+		s.evictOldest()
+	}
+
+	t.lastUsed = s.counter
+	s.tables[wallet.Id] = t
+	s.keys += len(t.keys)
+	return t
+}
+
+// must be locked from outside
+func (s *subaddressTables) evictOldest() {
+	var oldestId uint32
+	var oldest *subaddressTable
+	for id, t := range s.tables {
+		if oldest == nil || t.lastUsed < oldest.lastUsed {
+			oldestId = id
+			oldest = t
+		}
+	}
+
+	s.keys -= len(oldest.keys)
+	delete(s.tables, oldestId)
+}
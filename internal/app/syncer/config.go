@@ -3,16 +3,47 @@ package syncer
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 )
 
 type Config struct {
-	LogLevel     string           `yaml:"log_level"`
-	Pprof        string           `yaml:"pprof"`
-	BlockchainDb utils.DbSettings `yaml:"blockchain_db"`
-	NodeAddress  string           `yaml:"node_address"`
-	Network      string           `yaml:"network"`
+	LogLevel      string           `yaml:"log_level"`
+	Pprof         string           `yaml:"pprof"`
+	BlockchainDb  utils.DbSettings `yaml:"blockchain_db"`
+	NodeAddress   string           `yaml:"node_address"` // deprecated, use node_addresses
+	NodeAddresses []string         `yaml:"node_addresses"`
+	ZmqAddress    string           `yaml:"zmq_address"`
+	Network       string           `yaml:"network"`
+
+	CheckpointsFile string `yaml:"checkpoints_file"` // optional, in addition to built-in checkpoints
+	MaxReorgDepth   uint64 `yaml:"max_reorg_depth"`
+
+	NodeRequestTimeout time.Duration `yaml:"node_request_timeout"`
+	NodeReadTimeout    time.Duration `yaml:"node_read_timeout"`
+	NodeMaxResponseMb  int64         `yaml:"node_max_response_mb"`
+	NodeRetries        int           `yaml:"node_retries"`
+}
+
+func MakeDefaultConfig() Config {
+	return Config{
+		LogLevel:           "info",
+		NodeRequestTimeout: 30 * time.Second,
+		NodeReadTimeout:    5 * time.Minute,
+		NodeMaxResponseMb:  256,
+		NodeRetries:        3,
+		MaxReorgDepth:      100,
+	}
+}
+
+// Returns addresses of all configured nodes
+func (c *Config) GetNodeAddresses() []string {
+	if len(c.NodeAddresses) == 0 && len(c.NodeAddress) != 0 {
+		return []string{c.NodeAddress}
+	}
+
+	return c.NodeAddresses
 }
 
 func (c *Config) Validate() error {
@@ -22,5 +53,29 @@ func (c *Config) Validate() error {
 		return errors.New(fmt.Sprintf("unknown network: %s", c.Network))
 	}
 
+	if err := c.BlockchainDb.Validate(); err != nil {
+		return errors.New(fmt.Sprintf("blockchain_db: %s", err.Error()))
+	}
+
+	if len(c.GetNodeAddresses()) == 0 {
+		return errors.New("at least one node address is required")
+	}
+
+	if c.NodeRequestTimeout <= 0 || c.NodeReadTimeout <= 0 {
+		return errors.New("node timeouts must be positive")
+	}
+
+	if c.NodeMaxResponseMb <= 0 {
+		return errors.New("node_max_response_mb must be positive")
+	}
+
+	if c.NodeRetries < 0 {
+		return errors.New("node_retries must not be negative")
+	}
+
+	if c.MaxReorgDepth == 0 {
+		return errors.New("max_reorg_depth must be positive")
+	}
+
 	return nil
 }
@@ -0,0 +1,63 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/exantech/monero-fastsync/internal/app/syncer/worker"
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
+	"github.com/exantech/monero-fastsync/pkg/genesis"
+)
+
+// Makes the sync worker of the config on the DB. The node's new blocks are subscribed to only if subscribe is set
+// and zmq address is configured, the returned function releases the subscription
+func MakeWorker(conf Config, db worker.DbOperator, subscribe bool) (*worker.Worker, func(), error) {
+	node, err := worker.NewNodeFetcher(conf.GetNodeAddresses(), worker.FetcherSettings{
+		RequestTimeout:  conf.NodeRequestTimeout,
+		ReadTimeout:     conf.NodeReadTimeout,
+		MaxResponseSize: conf.NodeMaxResponseMb * 1024 * 1024,
+		MaxRetries:      conf.NodeRetries,
+	})
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("couldn't make node fetcher: %s", err.Error()))
+	}
+
+	var notifier worker.NodeNotifier
+	release := func() {}
+	if len(conf.ZmqAddress) != 0 && subscribe {
+		logging.Log.Infof("Subscribing to node's new blocks at %s", conf.ZmqAddress)
+		notifier, err = worker.NewNodeNotifier(conf.ZmqAddress)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("couldn't make node notifier: %s", err.Error()))
+		}
+
+		release = func() { notifier.Close() }
+	}
+
+	logging.Log.Infof("Using %s network", strings.ToUpper(conf.Network))
+
+	checkpointsInfo, err := LoadCheckpoints(conf)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	w := worker.NewWorker(db, node, notifier, genesis.GetGenesisBlockInfo(conf.Network), checkpointsInfo,
+		conf.MaxReorgDepth)
+	return w, release, nil
+}
+
+// Returns the network's built-in checkpoints with ones from the configured file
+func LoadCheckpoints(conf Config) (*checkpoints.Checkpoints, error) {
+	res := checkpoints.GetCheckpoints(conf.Network)
+	if len(conf.CheckpointsFile) != 0 {
+		if err := res.LoadFile(conf.CheckpointsFile); err != nil {
+			return nil, errors.New(fmt.Sprintf("couldn't load checkpoints from %s: %s", conf.CheckpointsFile, err.Error()))
+		}
+	}
+
+	logging.Log.Infof("Using checkpoints up to height %d", res.MaxHeight())
+	return res, nil
+}
@@ -0,0 +1,620 @@
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/exantech/moneroutil"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/boltdb"
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+// Embedded storage for single-node deployments, fsd reads the same file with its BoltWorker.
+// There are no chain events, fsd notices new blocks by polling the top
+type BoltOperator struct {
+	store *boltdb.Store
+}
+
+func NewBoltOperator(path string) (*BoltOperator, error) {
+	store, err := boltdb.NewStore(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltOperator{
+		store: store,
+	}, nil
+}
+
+func (b *BoltOperator) GetLastBlockHeight() (*uint64, error) {
+	var height *uint64
+
+	err := b.store.View(func(tx *bolt.Tx) error {
+		if h, ok := boltdb.TopHeight(tx); ok {
+			height = &h
+		}
+
+		return nil
+	})
+
+	return height, err
+}
+
+func (b *BoltOperator) GetShortChain() ([]utils.HeightInfo, error) {
+	chain := make([]utils.HeightInfo, 0, 30)
+
+	err := b.store.View(func(tx *bolt.Tx) error {
+		height, ok := boltdb.TopHeight(tx)
+		if !ok {
+			return nil
+		}
+
+		for _, h := range calcShortChainHeights(height) {
+			block, err := boltdb.GetBlock(tx, h)
+			if err == boltdb.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			chain = append(chain, utils.HeightInfo{Height: h, Hash: block.Hash})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Couldn't fetch blocks from db: %s", err.Error())
+		return []utils.HeightInfo{}, err
+	}
+
+	return chain, nil
+}
+
+func (b *BoltOperator) SaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error {
+	logging.Log.Debug("Saving parsed blocks")
+
+	if len(blocks) == 0 {
+		logging.Log.Debug("No blocks to insert into db")
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		txs := tx.Bucket(boltdb.TransactionsBucket)
+		outputs := make(OutputCounters)
+		for _, block := range blocks {
+			err := boltdb.PutBlock(tx, block.Height, boltdb.Block{
+				Hash:      block.Hash,
+				Header:    block.Header,
+				Timestamp: uint64(block.Timestamp),
+			})
+
+			if err != nil {
+				logging.Log.Errorf("Couldn't insert blocks into db: %s", err.Error())
+				return err
+			}
+
+			for idx, tr := range block.Transactions {
+				data, err := boltdb.Encode(boltdb.Transaction{
+					Hash:              tr.Hash,
+					Blob:              tr.Blob,
+					OutputKeys:        tr.OutputKeys,
+					ViewTags:          tr.ViewTags,
+					OutputIndices:     tr.OutputIndices,
+					UsedInputs:        tr.UsedInInputs,
+					Timestamp:         tr.Timestamp,
+					HasPubKeys:        tr.PubKeys != nil,
+					PubKeys:           tr.PubKeys,
+					AdditionalPubKeys: tr.AdditionalPubKeys,
+				})
+
+				if err != nil {
+					return err
+				}
+
+				if err = txs.Put(boltdb.TransactionKey(block.Height, uint32(idx)), data); err != nil {
+					logging.Log.Errorf("Couldn't insert transactions into db: %s", err.Error())
+					return err
+				}
+
+				for _, amount := range tr.OutputAmounts {
+					outputs[amount]++
+				}
+			}
+		}
+
+		if err := updateBoltOutputCounters(tx, outputs, 1); err != nil {
+			logging.Log.Errorf("Couldn't update output counters: %s", err.Error())
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	logging.Log.Debugf("Blocks inserted: %d, blocks: %s(%d)...%s(%d)", len(blocks),
+		blocks[0].Hash.String(), blocks[0].Height, blocks[len(blocks)-1].Hash.String(), blocks[len(blocks)-1].Height)
+
+	return nil
+}
+
+// There is no faster way to load large batches into bolt
+func (b *BoltOperator) BulkSaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error {
+	return b.SaveParsedBlocks(ctx, blocks)
+}
+
+// Removes blocks from the height and records the reorganization
+func (b *BoltOperator) TrimBlockchain(ctx context.Context, height uint64, newHashes []moneroutil.Hash) error {
+	logging.Log.Debugf("Trimming blockchain from height %d", height)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return b.store.Update(func(tx *bolt.Tx) error {
+		oldHashes, err := getBoltBlockHashesFrom(tx, height)
+		if err != nil {
+			logging.Log.Errorf("Couldn't get hashes of trimmed blocks: %s", err.Error())
+			return err
+		}
+
+		trimmedOutputs := make(OutputCounters)
+		err = boltdb.ForEachTransaction(tx, height, maxHeight, func(_ uint64, t boltdb.Transaction) error {
+			return countOutputs(trimmedOutputs, t.Hash, t.Blob)
+		})
+
+		if err != nil {
+			logging.Log.Errorf("Couldn't count outputs of trimmed transactions: %s", err.Error())
+			return err
+		}
+
+		if err = updateBoltOutputCounters(tx, trimmedOutputs, -1); err != nil {
+			logging.Log.Errorf("Couldn't update output counters: %s", err.Error())
+			return err
+		}
+
+		if err = boltdb.DeleteFrom(tx.Bucket(boltdb.TransactionsBucket), boltdb.Uint64Key(height)); err != nil {
+			logging.Log.Errorf("Couldn't trim transactions: %s", err.Error())
+			return err
+		}
+
+		if err = trimWalletsData(tx, height); err != nil {
+			logging.Log.Errorf("Couldn't trim wallets' blocks and outputs: %s", err.Error())
+			return err
+		}
+
+		updatedWs, err := resetWalletsProgress(tx, height)
+		if err != nil {
+			logging.Log.Errorf("Couldn't update wallets: %s", err.Error())
+			return err
+		}
+
+		logging.Log.Debugf("Updated %d wallets", updatedWs)
+
+		if err = recordBoltAppliedReorg(tx, height-1, oldHashes, newHashes, updatedWs); err != nil {
+			logging.Log.Errorf("Couldn't record reorganization: %s", err.Error())
+			return err
+		}
+
+		hashes := tx.Bucket(boltdb.BlockHashesBucket)
+		for _, hash := range oldHashes {
+			if err = hashes.Delete(hash[:]); err != nil {
+				return err
+			}
+		}
+
+		if err = boltdb.DeleteFrom(tx.Bucket(boltdb.BlocksBucket), boltdb.Uint64Key(height)); err != nil {
+			logging.Log.Errorf("Couldn't trim blocks: %s", err.Error())
+			return err
+		}
+
+		logging.Log.Debugf("Trimmed %d blocks", len(oldHashes))
+		return nil
+	})
+}
+
+const maxHeight = ^uint64(0)
+
+// Removes wallets' blocks and outputs from the height
+func trimWalletsData(tx *bolt.Tx, height uint64) error {
+	walletBlocks := tx.Bucket(boltdb.WalletBlocksBucket)
+	err := boltdb.ForEachWallet(tx, func(id uint32, _ boltdb.Wallet) error {
+		from := boltdb.WalletBlockKey(id, height)
+
+		c := walletBlocks.Cursor()
+		for k, _ := c.Seek(from); k != nil && binary.BigEndian.Uint32(k) == id; k, _ = c.Seek(from) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	walletOutputs := tx.Bucket(boltdb.WalletOutputsBucket)
+	heights := tx.Bucket(boltdb.OutputsHeightsBucket)
+
+	c := heights.Cursor()
+	for k, _ := c.Seek(boltdb.Uint64Key(height)); k != nil; k, _ = c.Seek(boltdb.Uint64Key(height)) {
+		if err = walletOutputs.Delete(k[8:]); err != nil {
+			return err
+		}
+
+		if err = c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Moves progress of wallets scanned beyond the height back to the block below it, returns number of such wallets
+func resetWalletsProgress(tx *bolt.Tx, height uint64) (int64, error) {
+	// the bucket can't be modified while iterated
+	reset := make(map[uint32]boltdb.Wallet)
+	err := boltdb.ForEachWallet(tx, func(id uint32, wallet boltdb.Wallet) error {
+		if !wallet.HasProgress || wallet.ScannedHeight < height {
+			return nil
+		}
+
+		if height == 0 {
+			wallet.HasProgress = false
+		} else {
+			wallet.ScannedHeight = height - 1
+		}
+
+		reset[id] = wallet
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for id, wallet := range reset {
+		if err = boltdb.PutWallet(tx, id, wallet); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(reset)), nil
+}
+
+// Returns timestamps of the top blocks, oldest first
+func (b *BoltOperator) GetLastTimestamps(count int) ([]uint64, error) {
+	res := make([]uint64, count)
+	n := count
+
+	err := b.store.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltdb.BlocksBucket).Cursor()
+		for k, v := c.Last(); k != nil && n > 0; k, v = c.Prev() {
+			var block boltdb.Block
+			if err := boltdb.Decode(v, &block); err != nil {
+				return err
+			}
+
+			n--
+			res[n] = block.Timestamp
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Couldn't fetch block timestamps: %s", err.Error())
+		return nil, err
+	}
+
+	return res[n:], nil
+}
+
+func (b *BoltOperator) GetOutputCounters() (OutputCounters, error) {
+	res := make(OutputCounters)
+
+	err := b.store.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltdb.CountersBucket).ForEach(func(k, v []byte) error {
+			res[binary.BigEndian.Uint64(k)] = binary.BigEndian.Uint64(v)
+			return nil
+		})
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Couldn't fetch output counters: %s", err.Error())
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Counts outputs of all saved transactions from scratch
+func (b *BoltOperator) RebuildOutputCounters(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return b.store.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltdb.CountersBucket); err != nil {
+			logging.Log.Errorf("Couldn't clear output counters: %s", err.Error())
+			return err
+		}
+
+		if _, err := tx.CreateBucket(boltdb.CountersBucket); err != nil {
+			return err
+		}
+
+		counters := make(OutputCounters)
+		err := boltdb.ForEachTransaction(tx, 0, maxHeight, func(_ uint64, t boltdb.Transaction) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			return countOutputs(counters, t.Hash, t.Blob)
+		})
+
+		if err != nil {
+			logging.Log.Errorf("Couldn't count outputs of saved transactions: %s", err.Error())
+			return err
+		}
+
+		if err = updateBoltOutputCounters(tx, counters, 1); err != nil {
+			logging.Log.Errorf("Couldn't save output counters: %s", err.Error())
+			return err
+		}
+
+		return nil
+	})
+}
+
+// Adds (sign = 1) or subtracts (sign = -1) the counts
+func updateBoltOutputCounters(tx *bolt.Tx, counts OutputCounters, sign int64) error {
+	counters := tx.Bucket(boltdb.CountersBucket)
+	for amount, count := range counts {
+		key := boltdb.Uint64Key(amount)
+
+		var current uint64
+		if v := counters.Get(key); v != nil {
+			current = binary.BigEndian.Uint64(v)
+		}
+
+		if err := counters.Put(key, boltdb.Uint64Key(uint64(int64(current)+sign*int64(count)))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the last not applied reorganization to the blocks, status is empty if there is none
+func (b *BoltOperator) GetReorgStatus(forkHeight, depth uint64, newHashes []moneroutil.Hash) (int64, string, error) {
+	var id int64
+	var status string
+
+	err := b.store.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltdb.ReorgsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r boltdb.Reorg
+			if err := boltdb.Decode(v, &r); err != nil {
+				return err
+			}
+
+			if r.ForkHeight == forkHeight && uint64(len(r.OldHashes)) == depth && equalHashes(r.NewHashes, newHashes) &&
+				r.Status != ReorgApplied {
+				id = int64(binary.BigEndian.Uint64(k))
+				status = r.Status
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return id, status, err
+}
+
+func (b *BoltOperator) AddPendingReorg(ctx context.Context, forkHeight uint64, newHashes []moneroutil.Hash) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		oldHashes, err := getBoltBlockHashesFrom(tx, forkHeight+1)
+		if err != nil {
+			logging.Log.Errorf("Couldn't get hashes of blocks to be trimmed: %s", err.Error())
+			return err
+		}
+
+		var wallets int64
+		err = boltdb.ForEachWallet(tx, func(_ uint32, wallet boltdb.Wallet) error {
+			if wallet.HasProgress && wallet.ScannedHeight > forkHeight {
+				wallets++
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			logging.Log.Errorf("Couldn't count affected wallets: %s", err.Error())
+			return err
+		}
+
+		id, err = putBoltReorg(tx, 0, boltdb.Reorg{
+			CreatedAt:       time.Now().Unix(),
+			ForkHeight:      forkHeight,
+			OldHashes:       oldHashes,
+			NewHashes:       newHashes,
+			AffectedWallets: wallets,
+			Status:          ReorgPending,
+		})
+
+		if err != nil {
+			logging.Log.Errorf("Couldn't record reorganization: %s", err.Error())
+		}
+
+		return err
+	})
+
+	return id, err
+}
+
+func (b *BoltOperator) ConfirmReorg(id int64) error {
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		var r boltdb.Reorg
+
+		data := tx.Bucket(boltdb.ReorgsBucket).Get(boltdb.Uint64Key(uint64(id)))
+		if data != nil {
+			if err := boltdb.Decode(data, &r); err != nil {
+				return err
+			}
+		}
+
+		if data == nil || r.Status != ReorgPending {
+			return errors.New(fmt.Sprintf("there is no pending reorganization with id %d", id))
+		}
+
+		r.Status = ReorgConfirmed
+		_, err := putBoltReorg(tx, id, r)
+		return err
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Couldn't confirm reorganization: %s", err.Error())
+	}
+
+	return err
+}
+
+// Confirmed reorganization to the same blocks becomes applied, otherwise a new record is made
+func recordBoltAppliedReorg(tx *bolt.Tx, forkHeight uint64, oldHashes, newHashes []moneroutil.Hash, wallets int64) error {
+	// the bucket can't be modified while iterated
+	confirmed := make(map[int64]boltdb.Reorg)
+
+	c := tx.Bucket(boltdb.ReorgsBucket).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var r boltdb.Reorg
+		if err := boltdb.Decode(v, &r); err != nil {
+			return err
+		}
+
+		if r.ForkHeight == forkHeight && len(r.OldHashes) == len(oldHashes) && equalHashes(r.NewHashes, newHashes) &&
+			r.Status == ReorgConfirmed {
+			confirmed[int64(binary.BigEndian.Uint64(k))] = r
+		}
+	}
+
+	for id, r := range confirmed {
+		r.Status = ReorgApplied
+		r.AffectedWallets = wallets
+		if _, err := putBoltReorg(tx, id, r); err != nil {
+			return err
+		}
+	}
+
+	if len(confirmed) != 0 {
+		return nil
+	}
+
+	_, err := putBoltReorg(tx, 0, boltdb.Reorg{
+		CreatedAt:       time.Now().Unix(),
+		ForkHeight:      forkHeight,
+		OldHashes:       oldHashes,
+		NewHashes:       newHashes,
+		AffectedWallets: wallets,
+		Status:          ReorgApplied,
+	})
+
+	return err
+}
+
+// gob decodes empty slices as nil, so they are compared by elements only
+func equalHashes(a, b []moneroutil.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Saves the reorganization, zero id makes a new record
+func putBoltReorg(tx *bolt.Tx, id int64, r boltdb.Reorg) (int64, error) {
+	reorgs := tx.Bucket(boltdb.ReorgsBucket)
+	if id == 0 {
+		seq, err := reorgs.NextSequence()
+		if err != nil {
+			return 0, err
+		}
+
+		id = int64(seq)
+	}
+
+	data, err := boltdb.Encode(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, reorgs.Put(boltdb.Uint64Key(uint64(id)), data)
+}
+
+// Returns hashes of blocks from the height up to the top
+func getBoltBlockHashesFrom(tx *bolt.Tx, height uint64) ([]moneroutil.Hash, error) {
+	res := make([]moneroutil.Hash, 0)
+
+	c := tx.Bucket(boltdb.BlocksBucket).Cursor()
+	for k, v := c.Seek(boltdb.Uint64Key(height)); k != nil; k, v = c.Next() {
+		var block boltdb.Block
+		if err := boltdb.Decode(v, &block); err != nil {
+			return nil, err
+		}
+
+		res = append(res, block.Hash)
+	}
+
+	return res, nil
+}
+
+func (b *BoltOperator) GetBlockHash(height uint64) (*moneroutil.Hash, error) {
+	logging.Log.Debugf("Getting block hash on height %d", height)
+
+	var res *moneroutil.Hash
+	err := b.store.View(func(tx *bolt.Tx) error {
+		block, err := boltdb.GetBlock(tx, height)
+		if err == boltdb.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		res = &block.Hash
+		return nil
+	})
+
+	if err != nil {
+		logging.Log.Errorf("Failed to get block hash on height %d: %s", height, err.Error())
+		return nil, err
+	}
+
+	return res, nil
+}
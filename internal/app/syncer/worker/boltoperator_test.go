@@ -0,0 +1,269 @@
+package worker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/boltdb"
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
+)
+
+// Makes the operator on a temporary file with the genesis block of the chain saved
+func newTestBoltOperator(t *testing.T, chain []testBlock) (*BoltOperator, func()) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op, err := NewBoltOperator(filepath.Join(dir, "blockchain.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	saveTestBlocks(t, op, chain, 0, 1)
+	return op, func() {
+		op.store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func saveTestBlocks(t *testing.T, op *BoltOperator, chain []testBlock, from, to uint64) {
+	counters, err := op.GetOutputCounters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := make([]ParsedBlockInfo, 0, to-from)
+	for h := from; h < to; h++ {
+		parsed, err := txparser.ParseBlockBytes(chain[h].blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks = append(blocks, transformBlock(h, parsed, counters))
+	}
+
+	if err = op.SaveParsedBlocks(context.Background(), blocks); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func boltChainHashes(op *BoltOperator) []moneroutil.Hash {
+	res := make([]moneroutil.Hash, 0)
+	for h := uint64(0); ; h++ {
+		hash, err := op.GetBlockHash(h)
+		if err != nil || hash == nil {
+			return res
+		}
+
+		res = append(res, *hash)
+	}
+}
+
+func TestBoltSyncReorg(t *testing.T) {
+	chain := makeTestChain(nil, 60, 1)
+	reorganized := makeTestChain(chain[:30], 70, 2)
+	op, cleanup := newTestBoltOperator(t, chain)
+	defer cleanup()
+
+	node := &testChainNode{chain: chain, batch: 10, switchAfter: 4, next: reorganized}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := NewWorker(op, node, nil, nil, checkpoints.NewCheckpoints(), testMaxReorgDepth).RunSyncLoop(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !assert.ObjectsAreEqual(chainHashes(reorganized), boltChainHashes(op)) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	assert.Equal(t, utils.ErrInterrupted, <-done)
+	assert.Equal(t, chainHashes(reorganized), boltChainHashes(op))
+
+	counters, err := op.GetOutputCounters()
+	assert.NoError(t, err)
+	assert.Equal(t, OutputCounters{1: 70}, counters)
+
+	shortChain, err := op.GetShortChain()
+	assert.NoError(t, err)
+	for _, hi := range shortChain {
+		assert.Equal(t, reorganized[hi.Height].hash, hi.Hash)
+	}
+
+	assert.Equal(t, uint64(69), shortChain[0].Height)
+	assert.Equal(t, uint64(0), shortChain[len(shortChain)-1].Height)
+
+	assert.NoError(t, op.RebuildOutputCounters(context.Background()))
+	counters, err = op.GetOutputCounters()
+	assert.NoError(t, err)
+	assert.Equal(t, OutputCounters{1: 70}, counters)
+}
+
+func TestBoltSaveDuplicate(t *testing.T) {
+	chain := makeTestChain(nil, 5, 1)
+	op, cleanup := newTestBoltOperator(t, chain)
+	defer cleanup()
+
+	saveTestBlocks(t, op, chain, 1, 5)
+
+	parsed, err := txparser.ParseBlockBytes(chain[4].blob)
+	assert.NoError(t, err)
+	assert.Error(t, op.SaveParsedBlocks(context.Background(), []ParsedBlockInfo{transformBlock(5, parsed, OutputCounters{})}))
+
+	height, err := op.GetLastBlockHeight()
+	assert.NoError(t, err)
+	if assert.NotNil(t, height) {
+		assert.Equal(t, uint64(4), *height)
+	}
+
+	timestamps, err := op.GetLastTimestamps(10)
+	assert.NoError(t, err)
+	assert.Len(t, timestamps, 5)
+}
+
+func TestBoltReorgConfirmation(t *testing.T) {
+	chain := makeTestChain(nil, 10, 1)
+	reorganized := makeTestChain(chain[:5], 12, 2)
+	other := makeTestChain(chain[:5], 12, 3)
+	newHashes := chainHashes(reorganized[5:10])
+	op, cleanup := newTestBoltOperator(t, chain)
+	defer cleanup()
+
+	saveTestBlocks(t, op, chain, 1, 10)
+
+	id, status, err := op.GetReorgStatus(4, 5, newHashes)
+	assert.NoError(t, err)
+	assert.Equal(t, "", status)
+
+	id, err = op.AddPendingReorg(context.Background(), 4, newHashes)
+	assert.NoError(t, err)
+
+	pendingId, status, err := op.GetReorgStatus(4, 5, newHashes)
+	assert.NoError(t, err)
+	assert.Equal(t, id, pendingId)
+	assert.Equal(t, ReorgPending, status)
+
+	assert.NoError(t, op.ConfirmReorg(id))
+	assert.Error(t, op.ConfirmReorg(id))
+	assert.Error(t, op.ConfirmReorg(id+1))
+
+	// another branch of the same depth isn't confirmed
+	_, status, err = op.GetReorgStatus(4, 5, chainHashes(other[5:10]))
+	assert.NoError(t, err)
+	assert.Equal(t, "", status)
+
+	assert.NoError(t, op.TrimBlockchain(context.Background(), 5, newHashes))
+	assert.Equal(t, chainHashes(chain[:5]), boltChainHashes(op))
+
+	// the confirmed reorganization became applied
+	_, status, err = op.GetReorgStatus(4, 5, newHashes)
+	assert.NoError(t, err)
+	assert.Equal(t, "", status)
+
+	counters, err := op.GetOutputCounters()
+	assert.NoError(t, err)
+	assert.Equal(t, OutputCounters{1: 5}, counters)
+}
+
+func TestBoltReorgConfirmationOtherBranch(t *testing.T) {
+	chain := makeTestChain(nil, 10, 1)
+	reorganized := makeTestChain(chain[:5], 12, 2)
+	other := makeTestChain(chain[:5], 12, 3)
+	op, cleanup := newTestBoltOperator(t, chain)
+	defer cleanup()
+
+	saveTestBlocks(t, op, chain, 1, 10)
+
+	id, err := op.AddPendingReorg(context.Background(), 4, chainHashes(reorganized[5:10]))
+	assert.NoError(t, err)
+	assert.NoError(t, op.ConfirmReorg(id))
+
+	// trimming to the other branch leaves the confirmation of the first one untouched
+	assert.NoError(t, op.TrimBlockchain(context.Background(), 5, chainHashes(other[5:10])))
+
+	confirmedId, status, err := op.GetReorgStatus(4, 5, chainHashes(reorganized[5:10]))
+	assert.NoError(t, err)
+	assert.Equal(t, id, confirmedId)
+	assert.Equal(t, ReorgConfirmed, status)
+}
+
+func TestBoltTrimWallets(t *testing.T) {
+	chain := makeTestChain(nil, 10, 1)
+	op, cleanup := newTestBoltOperator(t, chain)
+	defer cleanup()
+
+	saveTestBlocks(t, op, chain, 1, 10)
+
+	// wallet 1 is scanned beyond the trimmed height, wallet 2 is below it
+	err := op.store.Update(func(tx *bolt.Tx) error {
+		for id, height := range map[uint32]uint64{1: 8, 2: 3} {
+			if err := boltdb.PutWallet(tx, id, boltdb.Wallet{HasProgress: true, ScannedHeight: height}); err != nil {
+				return err
+			}
+
+			if err := tx.Bucket(boltdb.WalletBlocksBucket).Put(boltdb.WalletBlockKey(id, height), []byte{}); err != nil {
+				return err
+			}
+
+			data, err := boltdb.Encode(boltdb.WalletOutput{Height: height})
+			if err != nil {
+				return err
+			}
+
+			if err = tx.Bucket(boltdb.WalletOutputsBucket).Put(boltdb.WalletOutputKey(id, height), data); err != nil {
+				return err
+			}
+
+			if err = tx.Bucket(boltdb.OutputsHeightsBucket).Put(boltdb.OutputHeightKey(height, id, height), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, op.TrimBlockchain(context.Background(), 6, nil))
+
+	err = op.store.View(func(tx *bolt.Tx) error {
+		w1, err := boltdb.GetWallet(tx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(5), w1.ScannedHeight)
+
+		w2, err := boltdb.GetWallet(tx, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), w2.ScannedHeight)
+
+		assert.Nil(t, tx.Bucket(boltdb.WalletBlocksBucket).Get(boltdb.WalletBlockKey(1, 8)))
+		assert.NotNil(t, tx.Bucket(boltdb.WalletBlocksBucket).Get(boltdb.WalletBlockKey(2, 3)))
+		assert.Nil(t, tx.Bucket(boltdb.WalletOutputsBucket).Get(boltdb.WalletOutputKey(1, 8)))
+		assert.NotNil(t, tx.Bucket(boltdb.WalletOutputsBucket).Get(boltdb.WalletOutputKey(2, 3)))
+		assert.Equal(t, 1, tx.Bucket(boltdb.OutputsHeightsBucket).Stats().KeyN)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// trimmed reorganization is recorded with the number of affected wallets
+	err = op.store.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(boltdb.ReorgsBucket).Cursor().Last()
+
+		var r boltdb.Reorg
+		assert.NoError(t, boltdb.Decode(v, &r))
+		assert.Equal(t, uint64(5), r.ForkHeight)
+		assert.Equal(t, chainHashes(chain[6:]), r.OldHashes)
+		assert.Equal(t, int64(1), r.AffectedWallets)
+		assert.Equal(t, ReorgApplied, r.Status)
+		return nil
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+)
+
+// OutputCounters keeps the next global output index for each amount. Outputs of pre-RingCT transactions
+// are indexed within their amounts, all outputs of RingCT transactions are indexed within zero amount
+type OutputCounters map[uint64]uint64
+
+// Returns amount within which the output is indexed
+func amountBucket(txVersion uint32, amount uint64) uint64 {
+	if txVersion > 1 {
+		// coinbase outputs of RingCT transactions are indexed as zero amount too
+		return 0
+	}
+
+	return amount
+}
+
+// Assigns global indices to the transaction outputs the same way monerod does.
+// Returns the indices and amount buckets of the outputs
+func (c OutputCounters) assign(tx *txparser.TxPrefix) ([]uint64, []uint64) {
+	indices := make([]uint64, 0, len(tx.Vout))
+	amounts := make([]uint64, 0, len(tx.Vout))
+	for _, out := range tx.Vout {
+		amount := amountBucket(tx.Version, out.Amount)
+		indices = append(indices, c[amount])
+		amounts = append(amounts, amount)
+		c[amount]++
+	}
+
+	return indices, amounts
+}
+
+func (c OutputCounters) copy() OutputCounters {
+	res := make(OutputCounters, len(c))
+	for amount, count := range c {
+		res[amount] = count
+	}
+
+	return res
+}
+
+// Checks the indices returned by the node against the locally computed ones
+func checkOutputIndices(txHash fmt.Stringer, local, remote []uint64) error {
+	if len(local) != len(remote) {
+		return errors.New(fmt.Sprintf("node returned %d output indices for transaction %s with %d outputs",
+			len(remote), txHash.String(), len(local)))
+	}
+
+	for i := range local {
+		if local[i] != remote[i] {
+			return errors.New(fmt.Sprintf("output %d of transaction %s has global index %d, but node returned %d",
+				i, txHash.String(), local[i], remote[i]))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+)
+
+func TestOutputCountersAssign(t *testing.T) {
+	counters := OutputCounters{5: 10}
+
+	v1 := &txparser.TxPrefix{Version: 1, Vout: []txparser.TxOut{{Amount: 5}, {Amount: 7}, {Amount: 5}}}
+	indices, amounts := counters.assign(v1)
+	assert.Equal(t, []uint64{10, 0, 11}, indices)
+	assert.Equal(t, []uint64{5, 7, 5}, amounts)
+
+	// coinbase of RingCT transaction has non-zero amount, but is indexed as zero amount
+	v2 := &txparser.TxPrefix{Version: 2, Vout: []txparser.TxOut{{Amount: 5}, {Amount: 0}}}
+	indices, amounts = counters.assign(v2)
+	assert.Equal(t, []uint64{0, 1}, indices)
+	assert.Equal(t, []uint64{0, 0}, amounts)
+
+	assert.Equal(t, OutputCounters{0: 2, 5: 12, 7: 1}, counters)
+}
+
+func TestCheckOutputIndices(t *testing.T) {
+	var hash moneroutil.Hash
+
+	assert.NoError(t, checkOutputIndices(hash, []uint64{1, 2}, []uint64{1, 2}))
+	assert.Error(t, checkOutputIndices(hash, []uint64{1, 2}, []uint64{1}))
+	assert.Error(t, checkOutputIndices(hash, []uint64{1, 2}, []uint64{1, 3}))
+}
@@ -3,30 +3,59 @@ package worker
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 
 	"github.com/exantech/moneroutil"
 
 	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/migrations"
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 )
 
 type DbOperator interface {
 	GetShortChain() ([]utils.HeightInfo, error)
 	SaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error
+	BulkSaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error
 	GetLastBlockHeight() (*uint64, error)
-	TrimBlockchain(ctx context.Context, height uint64) error
+	TrimBlockchain(ctx context.Context, height uint64, newHashes []moneroutil.Hash) error
 	GetBlockHash(height uint64) (*moneroutil.Hash, error)
+	GetLastTimestamps(count int) ([]uint64, error)
+	GetOutputCounters() (OutputCounters, error)
+	RebuildOutputCounters(ctx context.Context) error
+	GetReorgStatus(forkHeight, depth uint64, newHashes []moneroutil.Hash) (int64, string, error)
+	AddPendingReorg(ctx context.Context, forkHeight uint64, newHashes []moneroutil.Hash) (int64, error)
+	ConfirmReorg(id int64) error
 }
 
+// Statuses of reorganizations in 'reorgs' table
+const (
+	ReorgApplied   = "applied"
+	ReorgPending   = "pending" // deeper than allowed, waits for operator's confirmation
+	ReorgConfirmed = "confirmed"
+)
+
 func NewDbOperator(settings utils.DbSettings) (DbOperator, error) {
+	if settings.GetDriver() == utils.DriverBolt {
+		return NewBoltOperator(settings.Path)
+	}
+
 	db, err := utils.NewDb(settings)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = migrations.CheckVersion(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &PgOperator{
 		db: db,
 	}, nil
@@ -42,6 +71,8 @@ type ParsedBlockInfo struct {
 
 type PgOperator struct {
 	db *sql.DB
+	// transactions partitions with lesser numbers are known to exist
+	partitionsUntil uint64
 }
 
 func (p *PgOperator) GetLastBlockHeight() (*uint64, error) {
@@ -61,8 +92,7 @@ func (p *PgOperator) GetShortChain() ([]utils.HeightInfo, error) {
 	}
 
 	heights := calcShortChainHeights(*height)
-	//workaround
-	rows, err := p.db.Query(fmt.Sprintf("SELECT height, hash FROM blocks WHERE height in (%s) ORDER BY 1 DESC", uints64ToString(heights)))
+	rows, err := p.db.Query("SELECT height, hash FROM blocks WHERE height = ANY($1) ORDER BY 1 DESC", pq.Array(heights))
 	if err != nil {
 		return []utils.HeightInfo{}, err
 	}
@@ -71,21 +101,14 @@ func (p *PgOperator) GetShortChain() ([]utils.HeightInfo, error) {
 
 	chain := make([]utils.HeightInfo, 0, 30)
 	for rows.Next() {
-		height := uint64(0)
-		var hashHex string
-
-		err = rows.Scan(&height, &hashHex)
-		if err != nil {
-			return []utils.HeightInfo{}, err
-		}
+		var hi utils.HeightInfo
 
-		h, err := moneroutil.HexToHash(hashHex)
+		err = rows.Scan(&hi.Height, (*utils.DbHash)(&hi.Hash))
 		if err != nil {
-			logging.Log.Warningf("Couldn't parse hex string: %s", hashHex)
 			return []utils.HeightInfo{}, err
 		}
 
-		chain = append(chain, utils.HeightInfo{height, h})
+		chain = append(chain, hi)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -96,7 +119,26 @@ func (p *PgOperator) GetShortChain() ([]utils.HeightInfo, error) {
 	return chain, nil
 }
 
+var (
+	blocksColumns = []string{"height", "hash", "header", "timestamp"}
+	txsColumns    = []string{"hash", "blob", "index_in_block", "output_keys", "output_view_tags",
+		"output_indices", "used_inputs", "timestamp", "block_height", "pub_keys", "additional_pub_keys"}
+)
+
+// Writes rows into the table within the transaction
+type rowsWriter func(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error
+
+// Inserts blocks row by row, which is faster for a few blocks
 func (p *PgOperator) SaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error {
+	return p.saveBlocks(ctx, blocks, insertRows)
+}
+
+// Loads blocks with COPY, which is much faster for large batches during initial sync
+func (p *PgOperator) BulkSaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error {
+	return p.saveBlocks(ctx, blocks, copyRows)
+}
+
+func (p *PgOperator) saveBlocks(ctx context.Context, blocks []ParsedBlockInfo, write rowsWriter) error {
 	logging.Log.Debug("Saving parsed blocks")
 
 	if len(blocks) == 0 {
@@ -104,6 +146,11 @@ func (p *PgOperator) SaveParsedBlocks(ctx context.Context, blocks []ParsedBlockI
 		return nil
 	}
 
+	if err := p.ensureTransactionsPartitions(ctx, blocks[len(blocks)-1].Height); err != nil {
+		logging.Log.Errorf("Couldn't create transactions partitions: %s", err.Error())
+		return err
+	}
+
 	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
 		logging.Log.Errorf("Couldn't begin transaction: %s", err.Error())
@@ -114,44 +161,46 @@ func (p *PgOperator) SaveParsedBlocks(ctx context.Context, blocks []ParsedBlockI
 		tx.Rollback()
 	}()
 
-	logging.Log.Debug("Preparing insert blocks statement")
-	blocksStmt, err := tx.PrepareContext(ctx, "INSERT INTO blocks (height, hash, header, timestamp) VALUES ($1, $2, $3, $4);")
-	if err != nil {
-		logging.Log.Error("Couldn't prepare insert blocks statement: %s", err.Error())
-		return err
-	}
-	defer blocksStmt.Close()
-
+	blockRows := make([][]interface{}, 0, len(blocks))
+	txRows := make([][]interface{}, 0, len(blocks))
+	outputs := make(OutputCounters)
 	for _, block := range blocks {
-		_, err = blocksStmt.Exec(block.Height, block.Hash.String(), block.Header, block.Timestamp)
-		if err != nil {
-			logging.Log.Errorf("Couldn't insert block into db: %s", err.Error())
-			return err
+		blockRows = append(blockRows, []interface{}{block.Height, utils.DbHash(block.Hash), block.Header, block.Timestamp})
+
+		for idx, tr := range block.Transactions {
+			txRows = append(txRows, []interface{}{utils.DbHash(tr.Hash), tr.Blob, idx,
+				utils.DbKeys(tr.OutputKeys), tr.ViewTags, pq.Array(tr.OutputIndices),
+				pq.Array(tr.UsedInInputs), tr.Timestamp, block.Height, utils.DbKeys(tr.PubKeys),
+				utils.DbKeys(tr.AdditionalPubKeys)})
+
+			for _, amount := range tr.OutputAmounts {
+				outputs[amount]++
+			}
 		}
 	}
 
+	if err = write(ctx, tx, "blocks", blocksColumns, blockRows); err != nil {
+		logging.Log.Errorf("Couldn't insert blocks into db: %s", err.Error())
+		return err
+	}
+
 	logging.Log.Debugf("Blocks inserted: %d, blocks: %s(%d)...%s(%d)", len(blocks),
 		blocks[0].Hash.String(), blocks[0].Height, blocks[len(blocks)-1].Hash.String(), blocks[len(blocks)-1].Height)
 
-	logging.Log.Debug("Preparing insert transactions statement")
-	txsStmt, err := tx.PrepareContext(ctx, "INSERT INTO transactions (hash, blob, index_in_block, output_keys, output_indices, used_inputs, timestamp, block_height)"+
-		" VALUES($1, $2, $3, $4, $5, $6, $7, $8)")
+	if err = write(ctx, tx, "transactions", txsColumns, txRows); err != nil {
+		logging.Log.Errorf("Couldn't insert transactions into db: %s", err.Error())
+		return err
+	}
 
-	if err != nil {
-		logging.Log.Errorf("Couldn't prepare insert transactions statement: %s", err.Error())
+	if err = updateOutputCounters(ctx, tx, outputs, 1); err != nil {
+		logging.Log.Errorf("Couldn't update output counters: %s", err.Error())
 		return err
 	}
-	defer txsStmt.Close()
 
-	for _, block := range blocks {
-		for idx, tr := range block.Transactions {
-			keys := convertKeysToStringArray(tr.OutputKeys)
-			_, err = txsStmt.Exec(tr.Hash.String(), tr.Blob, idx, pq.Array(keys), pq.Array(tr.OutputIndices), pq.Array(tr.UsedInInputs), tr.Timestamp, block.Height)
-			if err != nil {
-				logging.Log.Errorf("Couldn't insert transactions into db: %s", err.Error())
-				return err
-			}
-		}
+	err = utils.NotifyChainEvent(tx, utils.ChainEvent{Type: utils.ChainEventTop, Height: blocks[len(blocks)-1].Height})
+	if err != nil {
+		logging.Log.Errorf("Couldn't notify about new top: %s", err.Error())
+		return err
 	}
 
 	err = tx.Commit()
@@ -163,7 +212,70 @@ func (p *PgOperator) SaveParsedBlocks(ctx context.Context, blocks []ParsedBlockI
 	return nil
 }
 
-func (p *PgOperator) TrimBlockchain(ctx context.Context, height uint64) error {
+// Creates missing partitions of 'transactions' up to the one holding the height. Partitions are created
+// outside of the saving transaction, so they are known to exist even if the saving fails
+func (p *PgOperator) ensureTransactionsPartitions(ctx context.Context, height uint64) error {
+	last := utils.TransactionsPartition(height)
+	for ; p.partitionsUntil <= last; p.partitionsUntil++ {
+		if _, err := p.db.ExecContext(ctx, utils.CreateTransactionsPartitionQuery(p.partitionsUntil)); err != nil {
+			return err
+		}
+
+		logging.Log.Debugf("Transactions partition %s is ready", utils.TransactionsPartitionName(p.partitionsUntil))
+	}
+
+	return nil
+}
+
+func insertRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, makeInsertQuery(table, columns))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	// empty exec flushes buffered rows
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
+}
+
+func makeInsertQuery(table string, columns []string) string {
+	params := make([]string, 0, len(columns))
+	for i := range columns {
+		params = append(params, fmt.Sprintf("$%d", i+1))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(params, ", "))
+}
+
+// Removes blocks from the height and records the reorganization
+func (p *PgOperator) TrimBlockchain(ctx context.Context, height uint64, newHashes []moneroutil.Hash) error {
 	logging.Log.Debugf("Trimming blockchain from height %d", height)
 
 	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
@@ -174,6 +286,23 @@ func (p *PgOperator) TrimBlockchain(ctx context.Context, height uint64) error {
 
 	defer tx.Rollback()
 
+	oldHashes, err := getBlockHashesFrom(ctx, tx, height)
+	if err != nil {
+		logging.Log.Errorf("Couldn't get hashes of trimmed blocks: %s", err.Error())
+		return err
+	}
+
+	trimmedOutputs, err := countTransactionsOutputs(ctx, tx, height)
+	if err != nil {
+		logging.Log.Errorf("Couldn't count outputs of trimmed transactions: %s", err.Error())
+		return err
+	}
+
+	if err = updateOutputCounters(ctx, tx, trimmedOutputs, -1); err != nil {
+		logging.Log.Errorf("Couldn't update output counters: %s", err.Error())
+		return err
+	}
+
 	res, err := tx.Exec("DELETE FROM transactions WHERE block_height >= $1", height)
 	if err != nil {
 		logging.Log.Errorf("Couldn't trim 'transactions' table: %s", err.Error())
@@ -214,6 +343,11 @@ func (p *PgOperator) TrimBlockchain(ctx context.Context, height uint64) error {
 	updatedWs, _ := res.RowsAffected()
 	logging.Log.Debugf("Updated %d wallets", updatedWs)
 
+	if err = recordAppliedReorg(ctx, tx, height-1, oldHashes, newHashes, updatedWs); err != nil {
+		logging.Log.Errorf("Couldn't record reorganization: %s", err.Error())
+		return err
+	}
+
 	res, err = tx.Exec("DELETE FROM blocks WHERE height >= $1", height)
 	if err != nil {
 		logging.Log.Errorf("Couldn't trim 'blocks' table: %s", err.Error())
@@ -223,6 +357,12 @@ func (p *PgOperator) TrimBlockchain(ctx context.Context, height uint64) error {
 	trimmedBlocks, _ := res.RowsAffected()
 	logging.Log.Debugf("Trimmed %d blocks", trimmedBlocks)
 
+	err = utils.NotifyChainEvent(tx, utils.ChainEvent{Type: utils.ChainEventTrim, Height: height})
+	if err != nil {
+		logging.Log.Errorf("Couldn't notify about blockchain trim: %s", err.Error())
+		return err
+	}
+
 	if err = tx.Commit(); err != nil {
 		logging.Log.Errorf("Failed to commit transaction: %s", err.Error())
 	}
@@ -231,37 +371,276 @@ func (p *PgOperator) TrimBlockchain(ctx context.Context, height uint64) error {
 	return nil
 }
 
-func (p *PgOperator) GetBlockHash(height uint64) (*moneroutil.Hash, error) {
-	logging.Log.Debugf("Getting block hash on height %d", height)
+// Returns timestamps of the top blocks, oldest first
+func (p *PgOperator) GetLastTimestamps(count int) ([]uint64, error) {
+	rows, err := p.db.Query("SELECT timestamp FROM blocks ORDER BY height DESC LIMIT $1", count)
+	if err != nil {
+		logging.Log.Errorf("Couldn't fetch block timestamps: %s", err.Error())
+		return nil, err
+	}
 
-	row := p.db.QueryRow("SELECT hash FROM blocks WHERE height = $1", height)
+	defer rows.Close()
 
-	var hashHex string
-	err := row.Scan(&hashHex)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		logging.Log.Errorf("Failed to get block hash on height %d: %s", height, err.Error())
+	res := make([]uint64, count)
+	n := count
+	for rows.Next() {
+		n--
+		if err = rows.Scan(&res[n]); err != nil {
+			return nil, err
+		}
+	}
+
+	return res[n:], rows.Err()
+}
+
+// Amounts are stored as numeric, since they don't fit into bigint
+func (p *PgOperator) GetOutputCounters() (OutputCounters, error) {
+	rows, err := p.db.Query("SELECT amount, count FROM output_counters")
+	if err != nil {
+		logging.Log.Errorf("Couldn't fetch output counters: %s", err.Error())
 		return nil, err
 	}
 
-	res, err := moneroutil.HexToHash(hashHex)
+	defer rows.Close()
+
+	res := make(OutputCounters)
+	for rows.Next() {
+		var amountStr string
+		var count uint64
+		if err = rows.Scan(&amountStr, &count); err != nil {
+			return nil, err
+		}
+
+		amount, err := strconv.ParseUint(amountStr, 10, 64)
+		if err != nil {
+			logging.Log.Errorf("Couldn't parse output amount (%s) from db: %s", amountStr, err.Error())
+			return nil, err
+		}
+
+		res[amount] = count
+	}
+
+	return res, rows.Err()
+}
+
+// Counts outputs of all saved transactions from scratch
+func (p *PgOperator) RebuildOutputCounters(ctx context.Context) error {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		logging.Log.Errorf("Couldn't begin transaction: %s", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM output_counters"); err != nil {
+		logging.Log.Errorf("Couldn't clear output counters: %s", err.Error())
+		return err
+	}
+
+	counters, err := countTransactionsOutputs(ctx, tx, 0)
+	if err != nil {
+		logging.Log.Errorf("Couldn't count outputs of saved transactions: %s", err.Error())
+		return err
+	}
+
+	if err = updateOutputCounters(ctx, tx, counters, 1); err != nil {
+		logging.Log.Errorf("Couldn't save output counters: %s", err.Error())
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Counts outputs of transactions saved from the height by amount buckets
+func countTransactionsOutputs(ctx context.Context, tx *sql.Tx, height uint64) (OutputCounters, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT hash, blob FROM transactions WHERE block_height >= $1", height)
 	if err != nil {
-		logging.Log.Errorf("Failed to parse hash hex (%s) from db: %s", hashHex, err.Error())
 		return nil, err
 	}
 
-	return &res, nil
+	defer rows.Close()
+
+	res := make(OutputCounters)
+	for rows.Next() {
+		var hash moneroutil.Hash
+		var blob []byte
+		if err = rows.Scan((*utils.DbHash)(&hash), &blob); err != nil {
+			return nil, err
+		}
+
+		if err = countOutputs(res, hash, blob); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, rows.Err()
+}
+
+// Adds outputs of the saved transaction to the counters
+func countOutputs(counters OutputCounters, hash moneroutil.Hash, blob []byte) error {
+	prefix, err := txparser.ParseTxPrefixBytes(blob)
+	if err != nil {
+		logging.Log.Errorf("Couldn't parse saved transaction %s: %s", hash.String(), err.Error())
+		return err
+	}
+
+	for _, out := range prefix.Vout {
+		counters[amountBucket(prefix.Version, out.Amount)]++
+	}
+
+	return nil
 }
 
-func convertKeysToStringArray(keys []moneroutil.Key) []string {
-	res := make([]string, 0, len(keys))
+// Adds (sign = 1) or subtracts (sign = -1) the counts
+func updateOutputCounters(ctx context.Context, tx *sql.Tx, counts OutputCounters, sign int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO output_counters (amount, count) VALUES ($1, $2)
+		ON CONFLICT (amount) DO UPDATE SET count = output_counters.count + EXCLUDED.count`)
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for amount, count := range counts {
+		if _, err = stmt.ExecContext(ctx, strconv.FormatUint(amount, 10), sign*int64(count)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	for _, key := range keys {
-		res = append(res, key.String())
+// Returns the last not applied reorganization with the fork height and depth, status is empty if there is none
+func (p *PgOperator) GetReorgStatus(forkHeight, depth uint64, newHashes []moneroutil.Hash) (int64, string, error) {
+	var id int64
+	var status string
+	err := p.db.QueryRow(`SELECT id, status FROM reorgs WHERE fork_height = $1 AND depth = $2 AND new_hashes = $3
+		AND status != $4 ORDER BY id DESC LIMIT 1`, forkHeight, depth, utils.DbHashes(newHashes), ReorgApplied).Scan(&id, &status)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
 	}
 
-	return res
+	return id, status, err
+}
+
+func (p *PgOperator) AddPendingReorg(ctx context.Context, forkHeight uint64, newHashes []moneroutil.Hash) (int64, error) {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		logging.Log.Errorf("Couldn't begin transaction: %s", err.Error())
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	oldHashes, err := getBlockHashesFrom(ctx, tx, forkHeight+1)
+	if err != nil {
+		logging.Log.Errorf("Couldn't get hashes of blocks to be trimmed: %s", err.Error())
+		return 0, err
+	}
+
+	var wallets int64
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM wallets w, blocks b
+		WHERE b.height = $1 AND w.last_checked_block_id > b.id`, forkHeight).Scan(&wallets)
+	if err != nil {
+		logging.Log.Errorf("Couldn't count affected wallets: %s", err.Error())
+		return 0, err
+	}
+
+	id, err := insertReorg(ctx, tx, forkHeight, oldHashes, newHashes, wallets, ReorgPending)
+	if err != nil {
+		logging.Log.Errorf("Couldn't record reorganization: %s", err.Error())
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (p *PgOperator) ConfirmReorg(id int64) error {
+	res, err := p.db.Exec("UPDATE reorgs SET status = $1 WHERE id = $2 AND status = $3", ReorgConfirmed, id, ReorgPending)
+	if err != nil {
+		logging.Log.Errorf("Couldn't confirm reorganization: %s", err.Error())
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(fmt.Sprintf("there is no pending reorganization with id %d", id))
+	}
+
+	return nil
+}
+
+// Confirmed reorganization to the same blocks becomes applied, otherwise a new record is made
+func recordAppliedReorg(ctx context.Context, tx *sql.Tx, forkHeight uint64, oldHashes []moneroutil.Hash,
+	newHashes []moneroutil.Hash, wallets int64) error {
+
+	res, err := tx.ExecContext(ctx, `UPDATE reorgs SET status = $1, affected_wallets = $2
+		WHERE fork_height = $3 AND depth = $4 AND new_hashes = $5 AND status = $6`, ReorgApplied,
+		wallets, forkHeight, len(oldHashes), utils.DbHashes(newHashes), ReorgConfirmed)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n != 0 {
+		return nil
+	}
+
+	_, err = insertReorg(ctx, tx, forkHeight, oldHashes, newHashes, wallets, ReorgApplied)
+	return err
+}
+
+func insertReorg(ctx context.Context, tx *sql.Tx, forkHeight uint64, oldHashes, newHashes []moneroutil.Hash,
+	wallets int64, status string) (int64, error) {
+
+	var id int64
+	err := tx.QueryRowContext(ctx, `INSERT INTO reorgs (created_at, fork_height, depth, old_hashes, new_hashes,
+		affected_wallets, status) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`, time.Now().Unix(), forkHeight,
+		len(oldHashes), utils.DbHashes(oldHashes), utils.DbHashes(newHashes), wallets, status).Scan(&id)
+
+	return id, err
+}
+
+// Returns hashes of blocks from the height up to the top
+func getBlockHashesFrom(ctx context.Context, tx *sql.Tx, height uint64) ([]moneroutil.Hash, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT hash FROM blocks WHERE height >= $1 ORDER BY height", height)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := make([]moneroutil.Hash, 0)
+	for rows.Next() {
+		var hash moneroutil.Hash
+		if err = rows.Scan((*utils.DbHash)(&hash)); err != nil {
+			return nil, err
+		}
+
+		res = append(res, hash)
+	}
+
+	return res, rows.Err()
+}
+
+func (p *PgOperator) GetBlockHash(height uint64) (*moneroutil.Hash, error) {
+	logging.Log.Debugf("Getting block hash on height %d", height)
+
+	row := p.db.QueryRow("SELECT hash FROM blocks WHERE height = $1", height)
+
+	var res moneroutil.Hash
+	err := row.Scan((*utils.DbHash)(&res))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		logging.Log.Errorf("Failed to get block hash on height %d: %s", height, err.Error())
+		return nil, err
+	}
+
+	return &res, nil
 }
 
 func calcShortChainHeights(height uint64) []uint64 {
@@ -287,17 +666,3 @@ func calcShortChainHeights(height uint64) []uint64 {
 
 	return chain
 }
-
-func uints64ToString(ns []uint64) string {
-	var r string
-	for i, n := range ns {
-		if i == 0 {
-			r = fmt.Sprintf("%d", n)
-			continue
-		}
-
-		r = fmt.Sprintf("%s, %d", r, n)
-	}
-
-	return r
-}
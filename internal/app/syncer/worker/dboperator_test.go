@@ -0,0 +1,12 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeInsertQuery(t *testing.T) {
+	assert.Equal(t, "INSERT INTO blocks (height, hash, header, timestamp) VALUES ($1, $2, $3, $4)",
+		makeInsertQuery("blocks", blocksColumns))
+}
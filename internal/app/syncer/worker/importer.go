@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/exantech/moneroutil"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/bootstrap"
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
+	"github.com/exantech/monero-fastsync/pkg/genesis"
+)
+
+const (
+	importBatchSize = 1000
+)
+
+// Importer loads blocks from a blockchain.raw file made by monero-blockchain-export. Blocks already saved
+// in the DB are skipped, so an interrupted import may be resumed with the same file
+type Importer struct {
+	db          DbOperator
+	genesis     *genesis.GenesisBlockInfo
+	checkpoints *checkpoints.Checkpoints
+	counters    OutputCounters
+	verifier    *chainVerifier
+}
+
+func NewImporter(db DbOperator, genesisInfo *genesis.GenesisBlockInfo, checkpointsInfo *checkpoints.Checkpoints) *Importer {
+	return &Importer{
+		db:          db,
+		genesis:     genesisInfo,
+		checkpoints: checkpointsInfo,
+		counters:    make(OutputCounters),
+	}
+}
+
+func (i *Importer) ImportFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	return i.Import(ctx, f)
+}
+
+// Global output indices aren't stored in the file, so they are counted from the genesis block
+func (i *Importer) Import(ctx context.Context, r io.Reader) error {
+	reader, err := bootstrap.NewReader(r)
+	if err != nil {
+		logging.Log.Errorf("Failed to read file header: %s", err.Error())
+		return err
+	}
+
+	lastHeight, err := i.db.GetLastBlockHeight()
+	if err != nil {
+		logging.Log.Errorf("Failed to get last block height: %s", err.Error())
+		return err
+	}
+
+	if lastHeight == nil {
+		return errors.New("DB is empty. Try running with '-init' option")
+	}
+
+	logging.Log.Infof("Importing blocks above height %d", *lastHeight)
+
+	var imported uint64
+	batch := make([]ParsedBlockInfo, 0, importBatchSize)
+
+	for height := uint64(0); ; height++ {
+		if cancelled(ctx) {
+			logging.Log.Info("Interrupting import")
+			return utils.ErrInterrupted
+		}
+
+		pkg, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			logging.Log.Errorf("Failed to read block %d: %s", height, err.Error())
+			return err
+		}
+
+		hash := pkg.Block.GetHash()
+		if err = i.verify(height, hash, pkg); err != nil {
+			logging.Log.Errorf("Inconsistent chain in file: %s", err.Error())
+			return err
+		}
+
+		if height <= *lastHeight {
+			// saved blocks are only counted
+			i.counters.assign(pkg.Block.MinerTx.TxPrefix)
+			for _, tx := range pkg.Txs {
+				i.counters.assign(tx)
+			}
+
+			if height == *lastHeight {
+				if err = i.checkSavedTop(ctx, height, hash); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		blockInfo := transformBlock(height, pkg.Block, i.counters)
+		for idx, tx := range pkg.Txs {
+			blockInfo.Transactions = append(blockInfo.Transactions,
+				transformTx(pkg.Block.TxHashes[idx], pkg.TxBlobs[idx], tx, i.counters))
+		}
+
+		batch = append(batch, blockInfo)
+		if len(batch) < importBatchSize {
+			continue
+		}
+
+		if err = i.db.BulkSaveParsedBlocks(ctx, batch); err != nil {
+			logging.Log.Errorf("Failed to save imported blocks: %s", err.Error())
+			return err
+		}
+
+		imported += uint64(len(batch))
+		logging.Log.Infof("Imported blocks up to height %d", height)
+		batch = make([]ParsedBlockInfo, 0, importBatchSize)
+	}
+
+	if len(batch) != 0 {
+		if err = i.db.BulkSaveParsedBlocks(ctx, batch); err != nil {
+			logging.Log.Errorf("Failed to save imported blocks: %s", err.Error())
+			return err
+		}
+
+		imported += uint64(len(batch))
+	}
+
+	logging.Log.Infof("Import finished, %d blocks imported", imported)
+	return nil
+}
+
+func (i *Importer) verify(height uint64, hash moneroutil.Hash, pkg *bootstrap.BlockPackage) error {
+	if height != 0 {
+		return i.verifier.verify(pkg.Block, pkg.TxBlobs)
+	}
+
+	if hash != i.genesis.Hash {
+		logging.Log.Errorf("Genesis in file: %s, but we expect: %s", hash.String(), i.genesis.Hash.String())
+		return errors.New("genesis block mismatch, the file may be exported from another network")
+	}
+
+	i.verifier = newChainVerifier(utils.HeightInfo{Height: 0, Hash: hash}, []uint64{pkg.Block.TimeStamp}, i.checkpoints)
+	return nil
+}
+
+// The top saved block and output counters must be the same as in the file, otherwise output indices
+// counted so far are wrong
+func (i *Importer) checkSavedTop(ctx context.Context, height uint64, hash moneroutil.Hash) error {
+	saved, err := i.db.GetBlockHash(height)
+	if err != nil {
+		return err
+	}
+
+	if saved == nil || *saved != hash {
+		logging.Log.Errorf("Block at height %d in file: %s, in DB: %v", height, hash.String(), saved)
+		return errors.New("DB blockchain differs from the file")
+	}
+
+	counters, err := i.db.GetOutputCounters()
+	if err != nil {
+		return err
+	}
+
+	if len(counters) == 0 {
+		logging.Log.Warning("Output counters are empty, rebuilding them from saved transactions. It may take a while")
+		if err = i.db.RebuildOutputCounters(ctx); err != nil {
+			return err
+		}
+
+		if counters, err = i.db.GetOutputCounters(); err != nil {
+			return err
+		}
+	}
+
+	if len(counters) != len(i.counters) {
+		logging.Log.Errorf("DB has output counters for %d amounts, counted from file: %d", len(counters), len(i.counters))
+		return errors.New("DB output counters differ from the file")
+	}
+
+	for amount, count := range i.counters {
+		if counters[amount] != count {
+			logging.Log.Errorf("Output counter of amount %d in DB: %d, counted from file: %d", amount, counters[amount], count)
+			return errors.New("DB output counters differ from the file")
+		}
+	}
+
+	logging.Log.Infof("Skipped %d blocks already saved", height+1)
+	return nil
+}
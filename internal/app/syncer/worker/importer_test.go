@@ -0,0 +1,254 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
+	"github.com/exantech/monero-fastsync/pkg/genesis"
+)
+
+type importBlock struct {
+	hash    moneroutil.Hash
+	blob    []byte
+	txs     [][]byte
+	txsHash []moneroutil.Hash
+}
+
+// Makes block with RingCT miner transaction if minerV2 is set, otherwise with amount 1 output
+func makeImportBlock(height uint64, prev moneroutil.Hash, minerV2 bool, txs ...[]byte) importBlock {
+	key := moneroutil.Key{byte(height), 1}
+
+	blob := []byte{1, 0, 0}
+	blob = append(blob, prev[:]...)
+	blob = append(blob, 0, 0, 0, 0)
+
+	version := byte(1)
+	if minerV2 {
+		version = 2
+	}
+
+	blob = append(blob, version, 60, 1, 0xff)
+	blob = append(blob, moneroutil.Uint64ToBytes(height)...)
+	blob = append(blob, 1, 1, 2)
+	blob = append(blob, key[:]...)
+	blob = append(blob, 0x21, 1)
+	blob = append(blob, key[:]...)
+	if minerV2 {
+		blob = append(blob, 0)
+	}
+
+	b := importBlock{txs: txs}
+	blob = append(blob, byte(len(txs)))
+	for _, tx := range txs {
+		hash, err := txparser.TransactionHash(tx)
+		if err != nil {
+			panic(err)
+		}
+
+		blob = append(blob, hash[:]...)
+		b.txsHash = append(b.txsHash, hash)
+	}
+
+	block, err := txparser.ParseBlockBytes(blob)
+	if err != nil {
+		panic(err)
+	}
+
+	b.hash = block.GetHash()
+	b.blob = blob
+	return b
+}
+
+// Version 1 transaction with outputs of the amounts
+func makeImportTxV1(amounts ...byte) []byte {
+	tx := []byte{1, 0, 1, 0x02, 5, 2, 1, 1}
+	tx = append(tx, make([]byte, 32)...)
+	tx = append(tx, byte(len(amounts)))
+	for _, a := range amounts {
+		tx = append(tx, a, 0x02)
+		tx = append(tx, make([]byte, 32)...)
+	}
+
+	tx = append(tx, 0)
+	return append(tx, make([]byte, 2*64)...)
+}
+
+// RingCT transaction of null type with the number of outputs
+func makeImportTxV2(outputs int) []byte {
+	tx := []byte{2, 0, 1, 0x02, 0, 1, 1}
+	tx = append(tx, make([]byte, 32)...)
+	tx = append(tx, byte(outputs))
+	for i := 0; i < outputs; i++ {
+		tx = append(tx, 0, 0x02)
+		tx = append(tx, make([]byte, 32)...)
+	}
+
+	return append(tx, 0, 0)
+}
+
+func makeImportFile(blocks []importBlock) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(0x28721586))
+
+	info := append([]byte{0, 1}, moneroutil.Uint64ToBytes(1024)...)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(info)))
+	buf.Write(info)
+	buf.Write(make([]byte, 1028-buf.Len()))
+
+	for _, b := range blocks {
+		pkg := append([]byte{}, b.blob...)
+		pkg = append(pkg, byte(len(b.txs)))
+		for _, tx := range b.txs {
+			pkg = append(pkg, tx...)
+		}
+
+		pkg = append(pkg, 1, 1, 1)
+
+		binary.Write(&buf, binary.LittleEndian, uint32(len(pkg)))
+		buf.Write(pkg)
+	}
+
+	return buf.Bytes()
+}
+
+func makeImportChain() []importBlock {
+	var chain []importBlock
+	var prev moneroutil.Hash
+
+	add := func(minerV2 bool, txs ...[]byte) {
+		b := makeImportBlock(uint64(len(chain)), prev, minerV2, txs...)
+		chain = append(chain, b)
+		prev = b.hash
+	}
+
+	add(false)
+	add(false, makeImportTxV1(5, 7))
+	add(false, makeImportTxV1(5, 1))
+	add(true, makeImportTxV2(2))
+	add(true, makeImportTxV2(3), makeImportTxV1(5))
+	return chain
+}
+
+func makeImportDb(chain []importBlock) *testSyncDb {
+	return &testSyncDb{
+		blocks:   []utils.HeightInfo{{Height: 0, Hash: chain[0].hash}},
+		counters: OutputCounters{1: 1},
+	}
+}
+
+func savedIndices(db *testSyncDb) map[uint64][][]uint64 {
+	res := make(map[uint64][][]uint64)
+	for _, b := range db.saved {
+		for _, tx := range b.Transactions {
+			res[b.Height] = append(res[b.Height], tx.OutputIndices)
+		}
+	}
+
+	return res
+}
+
+func newTestImporter(db *testSyncDb, genesisHash moneroutil.Hash) *Importer {
+	return NewImporter(db, &genesis.GenesisBlockInfo{Hash: genesisHash}, checkpoints.NewCheckpoints())
+}
+
+var expectedImportIndices = map[uint64][][]uint64{
+	1: {{1}, {0, 0}},
+	2: {{2}, {1, 3}},
+	3: {{0}, {1, 2}},
+	4: {{3}, {4, 5, 6}, {2}},
+}
+
+func TestImport(t *testing.T) {
+	chain := makeImportChain()
+	db := makeImportDb(chain)
+
+	i := newTestImporter(db, chain[0].hash)
+	err := i.Import(context.Background(), bytes.NewReader(makeImportFile(chain)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, len(chain), len(db.blocks))
+	for h, b := range chain {
+		assert.Equal(t, b.hash, db.blocks[h].Hash)
+	}
+
+	assert.Equal(t, expectedImportIndices, savedIndices(db))
+	assert.Equal(t, OutputCounters{0: 7, 1: 4, 5: 3, 7: 1}, db.counters)
+	assert.Equal(t, chain[4].txsHash[1], db.saved[3].Transactions[2].Hash)
+	assert.Equal(t, chain[4].txs[1], db.saved[3].Transactions[2].Blob)
+	assert.Equal(t, 1, db.bulkSaves)
+}
+
+func TestImportResume(t *testing.T) {
+	chain := makeImportChain()
+	db := makeImportDb(chain)
+
+	err := newTestImporter(db, chain[0].hash).
+		Import(context.Background(), bytes.NewReader(makeImportFile(chain[:3])))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 3, len(db.blocks))
+
+	err = newTestImporter(db, chain[0].hash).
+		Import(context.Background(), bytes.NewReader(makeImportFile(chain)))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, len(chain), len(db.blocks))
+	assert.Equal(t, expectedImportIndices, savedIndices(db))
+}
+
+func TestImportWrongGenesis(t *testing.T) {
+	chain := makeImportChain()
+	db := makeImportDb(chain)
+
+	err := newTestImporter(db, chain[1].hash).
+		Import(context.Background(), bytes.NewReader(makeImportFile(chain)))
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(db.blocks))
+}
+
+func TestImportBrokenLinkage(t *testing.T) {
+	chain := makeImportChain()
+	chain[3] = makeImportBlock(3, chain[1].hash, true)
+	db := makeImportDb(chain)
+
+	err := newTestImporter(db, chain[0].hash).
+		Import(context.Background(), bytes.NewReader(makeImportFile(chain)))
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(db.blocks))
+}
+
+func TestImportDifferentSavedChain(t *testing.T) {
+	chain := makeImportChain()
+	db := makeImportDb(chain)
+	db.blocks = append(db.blocks, utils.HeightInfo{Height: 1, Hash: chain[2].hash})
+
+	err := newTestImporter(db, chain[0].hash).
+		Import(context.Background(), bytes.NewReader(makeImportFile(chain)))
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(db.blocks))
+}
+
+func TestImportDifferentCounters(t *testing.T) {
+	chain := makeImportChain()
+	db := makeImportDb(chain)
+	db.counters[5] = 1
+
+	err := newTestImporter(db, chain[0].hash).
+		Import(context.Background(), bytes.NewReader(makeImportFile(chain)))
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(db.blocks))
+}
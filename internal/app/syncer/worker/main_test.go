@@ -0,0 +1,16 @@
+package worker
+
+import (
+	"os"
+	"testing"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+)
+
+func TestMain(m *testing.M) {
+	if err := logging.InitLogger("syncer-test", "critical"); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
@@ -2,9 +2,15 @@ package worker
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/exantech/moneroproto"
 	"github.com/exantech/moneroutil"
@@ -13,22 +19,134 @@ import (
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
 )
 
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+
+	nodeStatusOk   = "OK"
+	nodeStatusBusy = "BUSY"
+)
+
+var (
+	ErrResponseTooLarge = errors.New("node response exceeds size limit")
+)
+
 type NodeFetcher interface {
-	GetBlocks(shortChain []utils.HeightInfo, lastHeight uint64) (*moneroproto.GetBlocksFastResponse, error)
+	GetBlocks(ctx context.Context, shortChain []utils.HeightInfo, lastHeight uint64) (*moneroproto.GetBlocksFastResponse, error)
 }
 
-func NewNodeFetcher(nodeAddress string) (NodeFetcher, error) {
-	return &RealNodeFetcher{
-		nodeAddress,
-	}, nil
+// BadResponseReporter is implemented by fetchers which can switch away from the node which returned inconsistent blocks.
+// ReportBadResponse returns false if there is no other node to fetch from
+type BadResponseReporter interface {
+	ReportBadResponse(resp *moneroproto.GetBlocksFastResponse) bool
+}
+
+type FetcherSettings struct {
+	RequestTimeout  time.Duration // connecting and waiting for response headers
+	ReadTimeout     time.Duration // reading response body
+	MaxResponseSize int64
+	MaxRetries      int // retries on transient errors
+}
+
+// NetworkError means the node is unreachable or the connection was broken
+type NetworkError struct {
+	Node string
+	Err  error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("network error on request to %s: %s", e.Node, e.Err.Error())
+}
+
+// HTTPStatusError means the node responded with non-200 HTTP code
+type HTTPStatusError struct {
+	Node       string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("node %s responded with HTTP status: %s", e.Node, e.Status)
+}
+
+// NodeStatusError means the node processed the request, but returned status other than OK
+type NodeStatusError struct {
+	Node   string
+	Status string
+}
+
+func (e *NodeStatusError) Error() string {
+	return fmt.Sprintf("node %s responded with status: %s", e.Node, e.Status)
+}
+
+// Makes fetcher which selects one of the nodes on every request
+func NewNodeFetcher(nodeAddresses []string, settings FetcherSettings) (NodeFetcher, error) {
+	if len(nodeAddresses) == 0 {
+		return nil, errors.New("no node addresses")
+	}
+
+	nodes := make([]nodeClient, 0, len(nodeAddresses))
+	for _, address := range nodeAddresses {
+		nodes = append(nodes, newRealNodeFetcher(address, settings))
+	}
+
+	return newMultiNodeFetcher(nodes, nodeHealthCheckInterval), nil
 }
 
 type RealNodeFetcher struct {
-	address string
+	address  string
+	settings FetcherSettings
+	client   *http.Client
 }
 
-//TODO: make cancellation
-func (r *RealNodeFetcher) GetBlocks(shortChain []utils.HeightInfo, lastHeight uint64) (*moneroproto.GetBlocksFastResponse, error) {
+func newRealNodeFetcher(address string, settings FetcherSettings) *RealNodeFetcher {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   settings.RequestTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ResponseHeaderTimeout: settings.RequestTimeout,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &RealNodeFetcher{
+		address:  address,
+		settings: settings,
+		client:   &http.Client{Transport: transport},
+	}
+}
+
+type getHeightResponse struct {
+	Height uint64 `json:"height"`
+	Status string `json:"status"`
+}
+
+func (r *RealNodeFetcher) Address() string {
+	return r.address
+}
+
+// Returns node's blockchain height, i.e. number of blocks
+func (r *RealNodeFetcher) GetHeight(ctx context.Context) (uint64, error) {
+	var heightResp getHeightResponse
+	err := r.withRetries(ctx, func() error {
+		return r.post(ctx, "/get_height", "application/json", nil, func(body io.Reader) error {
+			if err := json.NewDecoder(body).Decode(&heightResp); err != nil {
+				return err
+			}
+
+			if heightResp.Status != nodeStatusOk {
+				return &NodeStatusError{Node: r.address, Status: heightResp.Status}
+			}
+
+			return nil
+		})
+	})
+
+	return heightResp.Height, err
+}
+
+func (r *RealNodeFetcher) GetBlocks(ctx context.Context, shortChain []utils.HeightInfo, lastHeight uint64) (*moneroproto.GetBlocksFastResponse, error) {
 	req := moneroproto.GetBlocksFastRequest{
 		StartHeight: lastHeight,
 	}
@@ -41,35 +159,157 @@ func (r *RealNodeFetcher) GetBlocks(shortChain []utils.HeightInfo, lastHeight ui
 		return nil, err
 	}
 
-	logging.Log.Debug("Fetching blocks from node")
-	resp, err := http.Post(r.address+"/getblocks.bin", "application/octet-stream", &buffer)
+	var blocksResp *moneroproto.GetBlocksFastResponse
+	err = r.withRetries(ctx, func() error {
+		logging.Log.Debugf("Fetching blocks from node %s", r.address)
+
+		blocksResp = &moneroproto.GetBlocksFastResponse{}
+		return r.post(ctx, "/getblocks.bin", "application/octet-stream", buffer.Bytes(), func(body io.Reader) error {
+			logging.Log.Debug("Parsing blocks...")
+
+			err := moneroproto.Read(body, blocksResp)
+			if err != nil && err != io.EOF {
+				logging.Log.Errorf("Failed to parse blocks: %s", err.Error())
+				return err
+			}
+
+			if string(blocksResp.Status) != nodeStatusOk {
+				return &NodeStatusError{Node: r.address, Status: string(blocksResp.Status)}
+			}
+
+			return nil
+		})
+	})
+
 	if err != nil {
-		logging.Log.Errorf("Failed to fetch blocks from node: %s", err.Error())
 		return nil, err
 	}
 
-	if resp.StatusCode != 200 {
-		logging.Log.Errorf("Node returned error code: %s. This may mean the node is not functional or uses wrong blockchain. ", resp.Status)
-		return nil, errors.New("server error")
+	logging.Log.Debug("Blocks parsed successfully")
+	return blocksResp, nil
+}
+
+// Runs the request again with exponential backoff if the error is transient
+func (r *RealNodeFetcher) withRetries(ctx context.Context, request func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := request()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		if !isTransientError(err) || attempt >= r.settings.MaxRetries {
+			return err
+		}
+
+		delay := backoffDelay(attempt)
+		logging.Log.Warningf("Request to node failed: %s. Retrying in %s", err.Error(), delay)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
 	}
+}
 
-	logging.Log.Debug("Parsing blocks...")
-	//TODO: metrics
-	var blocksResp moneroproto.GetBlocksFastResponse
-	err = moneroproto.Read(resp.Body, &blocksResp)
+// Sends the request and passes response body to decode. Reading the body is limited by read timeout
+// and max response size
+func (r *RealNodeFetcher) post(ctx context.Context, path string, contentType string, reqBody []byte,
+	decode func(body io.Reader) error) error {
 
-	if err != nil && err != io.EOF {
-		logging.Log.Errorf("Failed to parse blocks: %s", err.Error())
-		return nil, err
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	httpReq, err := http.NewRequest("POST", r.address+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
 	}
 
-	if string(blocksResp.Status) != "OK" {
-		logging.Log.Errorf("Server responded with error: %s", blocksResp.Status)
-		return nil, errors.New("server error")
+	httpReq = httpReq.WithContext(reqCtx)
+	httpReq.Header.Set("Content-Type", contentType)
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return &NetworkError{Node: r.address, Err: err}
 	}
 
-	logging.Log.Debug("Blocks parsed successfully")
-	return &blocksResp, nil
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPStatusError{Node: r.address, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	timer := time.AfterFunc(r.settings.ReadTimeout, cancel)
+	defer timer.Stop()
+
+	body := &limitedReader{r: resp.Body, left: r.settings.MaxResponseSize}
+	err = decode(body)
+	if body.exceeded {
+		return ErrResponseTooLarge
+	}
+
+	if err != nil && reqCtx.Err() != nil {
+		// reading was interrupted by timeout or cancellation
+		return &NetworkError{Node: r.address, Err: err}
+	}
+
+	return err
+}
+
+func isTransientError(err error) bool {
+	switch e := err.(type) {
+	case *NetworkError:
+		return true
+	case *HTTPStatusError:
+		return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	case *NodeStatusError:
+		return e.Status == nodeStatusBusy
+	default:
+		return false
+	}
+}
+
+// Returns random delay between half and full exponential delay for the attempt
+func backoffDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = retryBaseDelay << uint(attempt)
+	}
+
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// limitedReader fails when more than allowed is read, unlike io.LimitReader which returns EOF
+type limitedReader struct {
+	r        io.Reader
+	left     int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		// check whether there is anything left
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			l.exceeded = true
+			return 0, ErrResponseTooLarge
+		}
+
+		return 0, err
+	}
+
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	return n, err
 }
 
 func extractHeightHashes(shortChain []utils.HeightInfo) []moneroutil.Hash {
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFetcherSettings = FetcherSettings{
+	RequestTimeout:  time.Second,
+	ReadTimeout:     time.Second,
+	MaxResponseSize: 1024,
+	MaxRetries:      1,
+}
+
+func makeTestServer(handler func(w http.ResponseWriter, calls int32)) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, atomic.AddInt32(&calls, 1))
+	}))
+
+	return server, &calls
+}
+
+func TestRealNodeFetcherGetHeight(t *testing.T) {
+	server, calls := makeTestServer(func(w http.ResponseWriter, calls int32) {
+		w.Write([]byte(`{"height": 1234, "status": "OK"}`))
+	})
+	defer server.Close()
+
+	f := newRealNodeFetcher(server.URL, testFetcherSettings)
+	height, err := f.GetHeight(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1234), height)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestRealNodeFetcherRetriesServerErrors(t *testing.T) {
+	server, calls := makeTestServer(func(w http.ResponseWriter, calls int32) {
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte(`{"height": 10, "status": "OK"}`))
+	})
+	defer server.Close()
+
+	f := newRealNodeFetcher(server.URL, testFetcherSettings)
+	height, err := f.GetHeight(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(10), height)
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+}
+
+func TestRealNodeFetcherHTTPStatusError(t *testing.T) {
+	server, calls := makeTestServer(func(w http.ResponseWriter, calls int32) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	defer server.Close()
+
+	f := newRealNodeFetcher(server.URL, testFetcherSettings)
+	_, err := f.GetHeight(context.Background())
+	statusErr, ok := err.(*HTTPStatusError)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	}
+
+	// client errors are not retried
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestRealNodeFetcherNodeStatusError(t *testing.T) {
+	server, calls := makeTestServer(func(w http.ResponseWriter, calls int32) {
+		w.Write([]byte(`{"height": 0, "status": "BUSY"}`))
+	})
+	defer server.Close()
+
+	f := newRealNodeFetcher(server.URL, testFetcherSettings)
+	_, err := f.GetHeight(context.Background())
+	statusErr, ok := err.(*NodeStatusError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "BUSY", statusErr.Status)
+	}
+
+	// busy node is retried
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+}
+
+func TestRealNodeFetcherResponseTooLarge(t *testing.T) {
+	server, _ := makeTestServer(func(w http.ResponseWriter, calls int32) {
+		w.Write([]byte(`{"height": 10, "status": "OK", "padding": "` + strings.Repeat("a", 2048) + `"}`))
+	})
+	defer server.Close()
+
+	f := newRealNodeFetcher(server.URL, testFetcherSettings)
+	_, err := f.GetHeight(context.Background())
+	assert.Equal(t, ErrResponseTooLarge, err)
+}
+
+func TestRealNodeFetcherCancel(t *testing.T) {
+	release := make(chan struct{})
+	server, _ := makeTestServer(func(w http.ResponseWriter, calls int32) {
+		<-release
+	})
+	defer server.Close()
+	defer close(release)
+
+	settings := testFetcherSettings
+	settings.RequestTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	f := newRealNodeFetcher(server.URL, settings)
+	start := time.Now()
+	_, err := f.GetBlocks(ctx, testShortChain, 0)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 100; attempt++ {
+		delay := backoffDelay(attempt)
+		assert.True(t, delay > 0)
+		assert.True(t, delay <= retryMaxDelay)
+	}
+
+	d := backoffDelay(1)
+	assert.True(t, d >= retryBaseDelay && d <= 2*retryBaseDelay)
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/zmq"
+)
+
+const (
+	chainMainTopic = "json-minimal-chain_main"
+
+	zmqDialTimeout    = 10 * time.Second
+	zmqReconnectDelay = 5 * time.Second
+)
+
+// NodeNotifier announces new blocks as soon as the node gets them
+type NodeNotifier interface {
+	// The channel receives a value when the node announces new blocks. Several announces may be merged into one
+	NewBlocks() <-chan struct{}
+	Close()
+}
+
+// Subscribes to monerod's zmq-pub endpoint, e.g. tcp://127.0.0.1:18083
+func NewNodeNotifier(address string) (NodeNotifier, error) {
+	n := &ZmqNodeNotifier{
+		address:   address,
+		newBlocks: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go n.run()
+	return n, nil
+}
+
+type ZmqNodeNotifier struct {
+	address   string
+	newBlocks chan struct{}
+	stopCh    chan struct{}
+	done      chan struct{}
+}
+
+func (n *ZmqNodeNotifier) NewBlocks() <-chan struct{} {
+	return n.newBlocks
+}
+
+func (n *ZmqNodeNotifier) Close() {
+	close(n.stopCh)
+	<-n.done
+}
+
+func (n *ZmqNodeNotifier) run() {
+	defer close(n.done)
+
+	for {
+		err := n.listen()
+		if n.stopped() {
+			return
+		}
+
+		logging.Log.Warningf("ZMQ subscription to %s failed: %s. Reconnecting in %s", n.address, err.Error(), zmqReconnectDelay)
+
+		select {
+		case <-n.stopCh:
+			return
+		case <-time.After(zmqReconnectDelay):
+		}
+	}
+}
+
+func (n *ZmqNodeNotifier) listen() error {
+	sub, err := zmq.DialSub(n.address, zmqDialTimeout, chainMainTopic)
+	if err != nil {
+		return err
+	}
+
+	// unblocks Recv on stop
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-n.stopCh:
+		case <-closed:
+		}
+
+		sub.Close()
+	}()
+
+	logging.Log.Infof("Subscribed to new blocks at %s", n.address)
+
+	// events which could be missed while disconnected
+	n.notify()
+
+	for {
+		frames, err := sub.Recv()
+		if err != nil {
+			return err
+		}
+
+		// monerod sends topic and json body in one frame separated by a colon
+		if len(frames) == 0 || !bytes.HasPrefix(frames[0], []byte(chainMainTopic+":")) {
+			continue
+		}
+
+		logging.Log.Debugf("Node announced new block: %s", frames[0][len(chainMainTopic)+1:])
+		n.notify()
+	}
+}
+
+func (n *ZmqNodeNotifier) notify() {
+	select {
+	case n.newBlocks <- struct{}{}:
+	default:
+	}
+}
+
+func (n *ZmqNodeNotifier) stopped() bool {
+	select {
+	case <-n.stopCh:
+		return true
+	default:
+		return false
+	}
+}
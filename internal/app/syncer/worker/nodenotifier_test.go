@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher imitates monerod's zmq-pub endpoint for a single subscriber
+type fakePublisher struct {
+	listener net.Listener
+	conn     net.Conn
+}
+
+func newFakePublisher(t *testing.T) *fakePublisher {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	return &fakePublisher{listener: l}
+}
+
+func (p *fakePublisher) address() string {
+	return "tcp://" + p.listener.Addr().String()
+}
+
+func (p *fakePublisher) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(p.conn, header); err != nil {
+		return 0, nil, err
+	}
+
+	body := make([]byte, header[1])
+	_, err := io.ReadFull(p.conn, body)
+	return header[0], body, err
+}
+
+func (p *fakePublisher) writeFrame(flags byte, body []byte) error {
+	_, err := p.conn.Write(append([]byte{flags, byte(len(body))}, body...))
+	return err
+}
+
+// accepts subscriber and returns its subscription
+func (p *fakePublisher) accept(t *testing.T) string {
+	var err error
+	p.conn, err = p.listener.Accept()
+	require.NoError(t, err)
+
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3
+	copy(greeting[12:], "NULL")
+
+	_, err = p.conn.Write(greeting)
+	require.NoError(t, err)
+
+	_, err = io.ReadFull(p.conn, greeting)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0xff), greeting[0])
+	assert.Equal(t, "NULL", string(greeting[12:16]))
+
+	flags, ready, err := p.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x04), flags)
+	assert.Contains(t, string(ready), "READY")
+	assert.Contains(t, string(ready), "SUB")
+
+	ready = []byte("\x05READY\x0bSocket-Type\x00\x00\x00\x03PUB")
+	require.NoError(t, p.writeFrame(0x04, ready))
+
+	flags, subscription, err := p.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, byte(0), flags)
+
+	return string(subscription)
+}
+
+func (p *fakePublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+
+	p.listener.Close()
+}
+
+func waitNewBlocks(n NodeNotifier) bool {
+	select {
+	case <-n.NewBlocks():
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestZmqNodeNotifier(t *testing.T) {
+	pub := newFakePublisher(t)
+	defer pub.close()
+
+	n, err := NewNodeNotifier(pub.address())
+	require.NoError(t, err)
+	defer n.Close()
+
+	assert.Equal(t, "\x01json-minimal-chain_main", pub.accept(t))
+
+	// notified on connect, since blocks could be missed
+	assert.True(t, waitNewBlocks(n))
+
+	// other topics are ignored
+	require.NoError(t, pub.writeFrame(0, []byte(`json-minimal-txpool_add:[]`)))
+
+	msg := []byte(`json-minimal-chain_main:{"first_height":100,"first_prev_id":"00","ids":["01"]}`)
+	require.NoError(t, pub.writeFrame(0, msg))
+	assert.True(t, waitNewBlocks(n))
+
+	select {
+	case <-n.NewBlocks():
+		t.Error("unexpected notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestZmqNodeNotifierLongFrame(t *testing.T) {
+	pub := newFakePublisher(t)
+	defer pub.close()
+
+	n, err := NewNodeNotifier(pub.address())
+	require.NoError(t, err)
+	defer n.Close()
+
+	pub.accept(t)
+	assert.True(t, waitNewBlocks(n))
+
+	body := []byte(`json-minimal-chain_main:{"first_height":100,"first_prev_id":"00","ids":[`)
+	for i := 0; i < 10; i++ {
+		body = append(body, `"0000000000000000000000000000000000000000000000000000000000000000",`...)
+	}
+	body = append(body, `"00"]}`...)
+
+	frame := []byte{0x02, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(frame[1:], uint64(len(body)))
+	_, err = pub.conn.Write(append(frame, body...))
+	require.NoError(t, err)
+
+	assert.True(t, waitNewBlocks(n))
+}
@@ -0,0 +1,314 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/exantech/moneroproto"
+	gometrics "github.com/rcrowley/go-metrics"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/metrics"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+const (
+	nodeHealthCheckInterval = 30 * time.Second
+	// a node which returned inconsistent blocks isn't used for this long
+	nodeBanDuration = 10 * time.Minute
+	// sources of the last responses are kept to find the node to blame, it's more than the pipeline holds
+	trackedResponses = 16
+)
+
+var (
+	ErrAllNodesBanned = errors.New("all nodes returned inconsistent blocks")
+)
+
+// nodeClient is a single node used by MultiNodeFetcher
+type nodeClient interface {
+	NodeFetcher
+	GetHeight(ctx context.Context) (uint64, error)
+	Address() string
+}
+
+type nodeState struct {
+	client   nodeClient
+	healthy  bool
+	height   uint64    // number of blocks in node's blockchain
+	banned   time.Time // the node isn't used until this time
+	errors   gometrics.Meter
+	duration gometrics.Timer
+}
+
+// MultiNodeFetcher requests blocks from the highest healthy node.
+// Other nodes are tried in order of their heights if the node fails
+type MultiNodeFetcher struct {
+	lock          *sync.Mutex
+	nodes         []*nodeState
+	current       *nodeState
+	checkInterval time.Duration
+	lastCheck     time.Time
+	sources       []responseSource
+}
+
+type responseSource struct {
+	resp *moneroproto.GetBlocksFastResponse
+	node *nodeState
+}
+
+func newMultiNodeFetcher(clients []nodeClient, checkInterval time.Duration) *MultiNodeFetcher {
+	nodes := make([]*nodeState, 0, len(clients))
+	for _, c := range clients {
+		nodes = append(nodes, &nodeState{
+			client:   c,
+			healthy:  true,
+			errors:   metrics.NodeErrors(c.Address()),
+			duration: metrics.NodeRequestDuration(c.Address()),
+		})
+	}
+
+	return &MultiNodeFetcher{
+		lock:          new(sync.Mutex),
+		nodes:         nodes,
+		checkInterval: checkInterval,
+	}
+}
+
+// The highest node is followed even if it doesn't have the local top, since monerod switches to the chain
+// with more work as well. Agreement with the local top only breaks ties between nodes of the same height.
+// A node behind the local top can't reorganize it, no new blocks are returned then
+func (m *MultiNodeFetcher) GetBlocks(ctx context.Context, shortChain []utils.HeightInfo, lastHeight uint64) (*moneroproto.GetBlocksFastResponse, error) {
+	m.checkHealth(ctx)
+
+	var localTop uint64
+	if len(shortChain) != 0 {
+		localTop = shortChain[0].Height
+	}
+
+	agrees := func(resp *moneroproto.GetBlocksFastResponse) bool {
+		return len(shortChain) == 0 || resp.StartHeight == localTop
+	}
+
+	var best *moneroproto.GetBlocksFastResponse
+	var bestNode *nodeState
+	var lastErr error
+
+	for _, n := range m.candidates() {
+		if best != nil {
+			healthy, height := m.nodeInfo(n)
+			if !healthy || height < best.CurrentHeight || (height == best.CurrentHeight && agrees(best)) {
+				break
+			}
+		}
+
+		start := time.Now()
+		resp, err := n.client.GetBlocks(ctx, shortChain, lastHeight)
+		n.duration.UpdateSince(start)
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if err != nil {
+			logging.Log.Warningf("Node %s failed to return blocks: %s", n.client.Address(), err.Error())
+			n.errors.Mark(1)
+			m.markFailed(n)
+			lastErr = err
+			continue
+		}
+
+		m.markHealthy(n, resp.CurrentHeight)
+
+		if !agrees(resp) {
+			logging.Log.Infof("Node %s doesn't have local top %d, its split height is %d",
+				n.client.Address(), localTop, resp.StartHeight)
+		}
+
+		if best == nil || resp.CurrentHeight > best.CurrentHeight ||
+			(resp.CurrentHeight == best.CurrentHeight && agrees(resp) && !agrees(best)) {
+			best = resp
+			bestNode = n
+		}
+	}
+
+	if best == nil {
+		if lastErr == nil {
+			lastErr = ErrAllNodesBanned
+		}
+
+		return nil, lastErr
+	}
+
+	m.setCurrent(bestNode)
+	m.addSource(best, bestNode)
+
+	if !agrees(best) && best.CurrentHeight <= localTop {
+		logging.Log.Infof("Node %s is behind local top: node height %d, local top %d",
+			bestNode.client.Address(), best.CurrentHeight, localTop)
+
+		return &moneroproto.GetBlocksFastResponse{
+			StartHeight:   localTop,
+			CurrentHeight: best.CurrentHeight,
+			Status:        best.Status,
+		}, nil
+	}
+
+	return best, nil
+}
+
+// Bans the node which returned the response, it was found to be inconsistent. Returns false if all nodes
+// are banned, so there is no node to fetch consistent blocks from
+func (m *MultiNodeFetcher) ReportBadResponse(resp *moneroproto.GetBlocksFastResponse) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var node *nodeState
+	for _, src := range m.sources {
+		if src.resp == resp {
+			node = src.node
+		}
+	}
+
+	if node == nil {
+		logging.Log.Error("Node returned inconsistent blocks, but it's unknown which one")
+		return false
+	}
+
+	logging.Log.Errorf("Node %s returned inconsistent blocks, it isn't used for %s", node.client.Address(), nodeBanDuration)
+	node.errors.Mark(1)
+	node.healthy = false
+	node.banned = time.Now().Add(nodeBanDuration)
+
+	for _, n := range m.nodes {
+		if !isBanned(n) {
+			return true
+		}
+	}
+
+	logging.Log.Error("All nodes returned inconsistent blocks")
+	return false
+}
+
+func (m *MultiNodeFetcher) addSource(resp *moneroproto.GetBlocksFastResponse, n *nodeState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.sources = append(m.sources, responseSource{resp: resp, node: n})
+	if len(m.sources) > trackedResponses {
+		m.sources = m.sources[1:]
+	}
+}
+
+func isBanned(n *nodeState) bool {
+	return time.Now().Before(n.banned)
+}
+
+// Returns healthy nodes ordered by height, the highest first, followed by unhealthy ones. Banned nodes are skipped
+func (m *MultiNodeFetcher) candidates() []*nodeState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res := make([]*nodeState, 0, len(m.nodes))
+	for _, n := range m.nodes {
+		if !isBanned(n) {
+			res = append(res, n)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].healthy != res[j].healthy {
+			return res[i].healthy
+		}
+
+		return res[i].height > res[j].height
+	})
+
+	return res
+}
+
+// Updates nodes' heights if check interval has passed
+func (m *MultiNodeFetcher) checkHealth(ctx context.Context) {
+	m.lock.Lock()
+	if time.Since(m.lastCheck) < m.checkInterval {
+		m.lock.Unlock()
+		return
+	}
+
+	m.lastCheck = time.Now()
+	nodes := m.nodes
+	m.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *nodeState) {
+			defer wg.Done()
+
+			start := time.Now()
+			height, err := n.client.GetHeight(ctx)
+			n.duration.UpdateSince(start)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				logging.Log.Warningf("Health check of node %s failed: %s", n.client.Address(), err.Error())
+				n.errors.Mark(1)
+				m.markFailed(n)
+				return
+			}
+
+			m.markHealthy(n, height)
+		}(n)
+	}
+
+	wg.Wait()
+}
+
+func (m *MultiNodeFetcher) nodeInfo(n *nodeState) (bool, uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return n.healthy, n.height
+}
+
+func (m *MultiNodeFetcher) markHealthy(n *nodeState, height uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n.height = height
+	if isBanned(n) {
+		return
+	}
+
+	if !n.healthy {
+		logging.Log.Infof("Node %s is healthy again", n.client.Address())
+	}
+
+	n.healthy = true
+}
+
+func (m *MultiNodeFetcher) markFailed(n *nodeState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if n.healthy {
+		logging.Log.Warningf("Node %s is marked as unhealthy", n.client.Address())
+	}
+
+	n.healthy = false
+}
+
+func (m *MultiNodeFetcher) setCurrent(n *nodeState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.current != n {
+		logging.Log.Infof("Using node %s, height %d", n.client.Address(), n.height)
+		m.current = n
+	}
+}
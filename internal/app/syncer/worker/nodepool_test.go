@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/exantech/moneroproto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+type testNode struct {
+	address    string
+	height     uint64
+	splitAt    uint64 // start height returned for any short chain
+	err        error
+	getBlocks  int
+	getHeights int
+}
+
+func (n *testNode) GetBlocks(ctx context.Context, shortChain []utils.HeightInfo, lastHeight uint64) (*moneroproto.GetBlocksFastResponse, error) {
+	n.getBlocks++
+	if n.err != nil {
+		return nil, n.err
+	}
+
+	return &moneroproto.GetBlocksFastResponse{
+		StartHeight:   n.splitAt,
+		CurrentHeight: n.height,
+		Status:        []byte("OK"),
+	}, nil
+}
+
+func (n *testNode) GetHeight(ctx context.Context) (uint64, error) {
+	n.getHeights++
+	return n.height, n.err
+}
+
+func (n *testNode) Address() string {
+	return n.address
+}
+
+func makeTestFetcher(nodes ...nodeClient) *MultiNodeFetcher {
+	return newMultiNodeFetcher(nodes, time.Hour)
+}
+
+var testShortChain = []utils.HeightInfo{{Height: 100}, {Height: 99}}
+
+func TestMultiNodeFetcherPrefersHighest(t *testing.T) {
+	low := &testNode{address: "low", height: 105, splitAt: 100}
+	high := &testNode{address: "high", height: 110, splitAt: 100}
+	m := makeTestFetcher(low, high)
+
+	resp, err := m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(110), resp.CurrentHeight)
+	assert.Equal(t, 0, low.getBlocks)
+	assert.Equal(t, 1, low.getHeights)
+}
+
+func TestMultiNodeFetcherFailover(t *testing.T) {
+	broken := &testNode{address: "broken", height: 110, splitAt: 100}
+	backup := &testNode{address: "backup", height: 105, splitAt: 100}
+	m := makeTestFetcher(broken, backup)
+
+	// health check passes, but request fails
+	m.checkHealth(context.Background())
+	broken.err = errors.New("connection refused")
+
+	resp, err := m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(105), resp.CurrentHeight)
+	assert.Equal(t, 1, broken.getBlocks)
+
+	// unhealthy node goes last
+	resp, err = m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(105), resp.CurrentHeight)
+	assert.Equal(t, 1, broken.getBlocks)
+}
+
+func TestMultiNodeFetcherAllFailed(t *testing.T) {
+	failure := errors.New("connection refused")
+	m := makeTestFetcher(&testNode{address: "a", err: failure}, &testNode{address: "b", err: failure})
+
+	_, err := m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.Equal(t, failure, err)
+}
+
+func TestMultiNodeFetcherFollowsHighest(t *testing.T) {
+	// the highest node has reorganized, the lagging one still agrees with the local top
+	fork := &testNode{address: "fork", height: 120, splitAt: 90}
+	agreeing := &testNode{address: "agreeing", height: 110, splitAt: 100}
+	m := makeTestFetcher(fork, agreeing)
+
+	resp, err := m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(90), resp.StartHeight)
+	assert.Equal(t, 0, agreeing.getBlocks)
+}
+
+func TestMultiNodeFetcherTieBreak(t *testing.T) {
+	fork := &testNode{address: "fork", height: 120, splitAt: 90}
+	agreeing := &testNode{address: "agreeing", height: 120, splitAt: 100}
+	m := makeTestFetcher(fork, agreeing)
+
+	resp, err := m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), resp.StartHeight)
+
+	// the agreeing node of the same height is enough
+	fork.getBlocks = 0
+	m = makeTestFetcher(agreeing, fork)
+	resp, err = m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), resp.StartHeight)
+	assert.Equal(t, 0, fork.getBlocks)
+}
+
+func TestMultiNodeFetcherStaleHeight(t *testing.T) {
+	// the node has fallen behind since the health check, the other node is asked too
+	stale := &testNode{address: "stale", height: 120, splitAt: 100}
+	other := &testNode{address: "other", height: 115, splitAt: 100}
+	m := makeTestFetcher(stale, other)
+
+	m.checkHealth(context.Background())
+	stale.height = 105
+
+	resp, err := m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(115), resp.CurrentHeight)
+	assert.Equal(t, 1, other.getBlocks)
+}
+
+func TestMultiNodeFetcherBehind(t *testing.T) {
+	behind := &testNode{address: "behind", height: 95, splitAt: 94}
+	m := makeTestFetcher(behind)
+
+	// the local top isn't trimmed to a shorter chain, there's just nothing new
+	resp, err := m.GetBlocks(context.Background(), testShortChain, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), resp.StartHeight)
+	assert.Empty(t, resp.Blocks)
+}
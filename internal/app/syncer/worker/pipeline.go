@@ -0,0 +1,369 @@
+package worker
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/exantech/moneroproto"
+	"github.com/exantech/moneroutil"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+const (
+	// batches buffered between pipeline stages
+	pipelineDepth = 2
+	// blocks are saved with COPY if the node has more blocks than this above the saved ones
+	bulkSaveMinBehind = 1000
+)
+
+type pipelineOutcome int
+
+// ordered by priority, the highest one is reported when several stages finish at once
+const (
+	outcomeSynced pipelineOutcome = iota // node has no more blocks
+	outcomeReorg                         // node's blockchain changed under fetched blocks, sync should be restarted
+	outcomeBadNode                       // node returned inconsistent blocks, sync should be restarted with another node
+	outcomeError                         // fetching or saving failed, sync should be retried later
+)
+
+type fetchedBatch struct {
+	generation uint64
+	topHeight  uint64          // height of the first block in response, it's already fetched or saved
+	topHash    moneroutil.Hash // hash of the first block in response
+	resp       *moneroproto.GetBlocksFastResponse
+}
+
+type parsedBatch struct {
+	generation uint64
+	nodeHeight uint64 // number of blocks in node's blockchain
+	blocks     []ParsedBlockInfo
+}
+
+// syncPipeline fetches the next batch of blocks while the previous ones are parsed and saved.
+// Stages are connected with bounded channels, so a slow stage blocks the others instead of piling up batches
+type syncPipeline struct {
+	w      *Worker
+	ctx    context.Context // cancelled when a stage fails, stops other stages
+	cancel context.CancelFunc
+	// incremented when the node has reorganized, batches of previous generations are stale and discarded
+	generation uint64
+
+	lock    *sync.Mutex
+	outcome pipelineOutcome
+	err     error // unrecoverable error, stops the sync loop
+}
+
+// Runs the pipeline starting with the first already fetched batch until the blockchain is synchronized
+// or some stage fails. Returns an error only if the sync loop must be stopped
+func (w *Worker) runPipeline(ctx context.Context, shortChain []utils.HeightInfo,
+	first *moneroproto.GetBlocksFastResponse) (pipelineOutcome, error) {
+
+	// counters of the saved blocks, the ones advanced by discarded batches are dropped with the pipeline
+	counters, err := w.loadOutputCounters(ctx)
+	if err != nil {
+		logging.Log.Errorf("Failed to load output counters: %s", err.Error())
+		return outcomeError, nil
+	}
+
+	timestamps, err := w.db.GetLastTimestamps(timestampCheckWindow)
+	if err != nil {
+		logging.Log.Errorf("Failed to get last block timestamps: %s", err.Error())
+		return outcomeError, nil
+	}
+
+	verifier := newChainVerifier(shortChain[0], timestamps, w.checkpoints)
+
+	pipeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p := &syncPipeline{
+		w:      w,
+		ctx:    pipeCtx,
+		cancel: cancel,
+		lock:   new(sync.Mutex),
+	}
+
+	fetched := make(chan fetchedBatch, pipelineDepth)
+	parsed := make(chan parsedBatch, pipelineDepth)
+
+	go p.fetchLoop(shortChain, fetchedBatch{
+		topHeight: shortChain[0].Height,
+		topHash:   shortChain[0].Hash,
+		resp:      first,
+	}, fetched)
+	go p.parseLoop(counters, verifier, fetched, parsed)
+
+	// the channel is closed after both other stages are finished
+	p.storeLoop(ctx, parsed)
+
+	if cancelled(ctx) {
+		logging.Log.Info("Interrupting sync loop")
+		return outcomeError, utils.ErrInterrupted
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.outcome, p.err
+}
+
+// Requests blocks following the last fetched ones, not waiting until they are saved
+func (p *syncPipeline) fetchLoop(shortChain []utils.HeightInfo, batch fetchedBatch, out chan<- fetchedBatch) {
+	defer close(out)
+
+	for {
+		select {
+		case out <- batch:
+		case <-p.ctx.Done():
+			return
+		}
+
+		blocks := batch.resp.Blocks
+		nextHeight := batch.topHeight + uint64(len(blocks)) - 1
+		lastBlock, err := txparser.ParseBlockBytes(blocks[len(blocks)-1].Block)
+		if err != nil {
+			logging.Log.Errorf("Failed to parse last block of batch: %s", err.Error())
+			p.failNode(batch.resp, err)
+			return
+		}
+
+		nextHash := lastBlock.GetHash()
+
+		// the node looks for the first known hash, so a saved block is found if the fetched ones were reorganized
+		chain := append([]utils.HeightInfo{{Height: nextHeight, Hash: nextHash}}, shortChain...)
+
+		logging.Log.Debugf("Requesting blocks from node after height %d", nextHeight)
+		resp, err := p.w.node.GetBlocks(p.ctx, chain, 0)
+		if err != nil {
+			if cancelled(p.ctx) {
+				return
+			}
+
+			logFetchError(err)
+			p.finish(outcomeError)
+			return
+		}
+
+		logging.Log.Debugf("Fetched %d blocks", len(resp.Blocks))
+		if len(resp.Blocks) == 0 {
+			logging.Log.Debug("Blockchain is synchronized")
+			p.finish(outcomeSynced)
+			return
+		}
+
+		if resp.StartHeight != nextHeight {
+			logging.Log.Infof("Blockchain reorganized while syncing. Last fetched height: %d, daemon start height: %d",
+				nextHeight, resp.StartHeight)
+
+			atomic.AddUint64(&p.generation, 1)
+			p.finish(outcomeReorg)
+			return
+		}
+
+		if len(resp.Blocks) == 1 {
+			logging.Log.Debug("Blockchain is synchronized")
+			p.finish(outcomeSynced)
+			return
+		}
+
+		batch = fetchedBatch{
+			generation: atomic.LoadUint64(&p.generation),
+			topHeight:  nextHeight,
+			topHash:    nextHash,
+			resp:       resp,
+		}
+	}
+}
+
+// Counters and verifier are advanced by parsed batches, so they run ahead of the saved ones
+func (p *syncPipeline) parseLoop(counters OutputCounters, verifier *chainVerifier,
+	in <-chan fetchedBatch, out chan<- parsedBatch) {
+
+	defer close(out)
+
+	// input is drained until closed, so the fetcher is never blocked
+	for batch := range in {
+		if cancelled(p.ctx) {
+			continue
+		}
+
+		if p.stale(batch.generation) {
+			logging.Log.Debugf("Discarding stale fetched blocks above height %d", batch.topHeight)
+			continue
+		}
+
+		blocks, err := parseBatch(p.ctx, batch, counters, verifier)
+		if err != nil {
+			if !cancelled(p.ctx) {
+				p.failNode(batch.resp, err)
+			}
+
+			continue
+		}
+
+		select {
+		case out <- parsedBatch{generation: batch.generation, nodeHeight: batch.resp.CurrentHeight, blocks: blocks}:
+		case <-p.ctx.Done():
+		}
+	}
+}
+
+func (p *syncPipeline) storeLoop(ctx context.Context, in <-chan parsedBatch) {
+	for batch := range in {
+		if cancelled(p.ctx) {
+			continue
+		}
+
+		if p.stale(batch.generation) {
+			logging.Log.Debugf("Discarding stale parsed blocks from height %d", batch.blocks[0].Height)
+			continue
+		}
+
+		save := p.w.db.SaveParsedBlocks
+		if useBulkSave(batch) {
+			save = p.w.db.BulkSaveParsedBlocks
+		}
+
+		// parent context is used, so reorg found meanwhile doesn't roll back blocks which may still be valid
+		if err := save(ctx, batch.blocks); err != nil {
+			logging.Log.Errorf("Failed to save parsed blocks: %s", err.Error())
+			p.finish(outcomeError)
+			p.cancel()
+			continue
+		}
+
+		logging.Log.Debugf("Saved blocks %d-%d", batch.blocks[0].Height, batch.blocks[len(batch.blocks)-1].Height)
+	}
+}
+
+// Bulk load is used only far behind the node's top, where reorganizations are unlikely,
+// single blocks in steady state are inserted row by row
+func useBulkSave(batch parsedBatch) bool {
+	lastHeight := batch.blocks[len(batch.blocks)-1].Height
+	return batch.nodeHeight > lastHeight+1+bulkSaveMinBehind
+}
+
+func (p *syncPipeline) stale(generation uint64) bool {
+	return generation != atomic.LoadUint64(&p.generation)
+}
+
+func (p *syncPipeline) finish(outcome pipelineOutcome) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if outcome > p.outcome {
+		p.outcome = outcome
+	}
+}
+
+func (p *syncPipeline) fail(err error) {
+	p.lock.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.lock.Unlock()
+
+	p.cancel()
+}
+
+// Blames the node which returned inconsistent blocks. The sync is stopped only if there's no other node to use
+func (p *syncPipeline) failNode(resp *moneroproto.GetBlocksFastResponse, err error) {
+	reporter, ok := p.w.node.(BadResponseReporter)
+	if !ok || !reporter.ReportBadResponse(resp) {
+		p.fail(err)
+		return
+	}
+
+	p.finish(outcomeBadNode)
+	p.cancel()
+}
+
+// Parses all blocks of the batch except the first one, which is already known. Blocks are verified to follow
+// each other, output indices are computed from the counters and checked against the ones returned by the node
+func parseBatch(ctx context.Context, batch fetchedBatch, counters OutputCounters,
+	verifier *chainVerifier) ([]ParsedBlockInfo, error) {
+
+	resp := batch.resp
+
+	topBlock, err := txparser.ParseBlockBytes(resp.Blocks[0].Block)
+	if err != nil {
+		logging.Log.Errorf("Failed to parse first block: %s", err.Error())
+		return nil, err
+	}
+
+	// is it possible?
+	if topBlock.GetHash() != batch.topHash {
+		logging.Log.Errorf("daemon response: {first block: %s, start height: %d}, expected: {top hash: %s, top height: %d}",
+			topBlock.GetHash().String(), resp.StartHeight, batch.topHash.String(), batch.topHeight)
+
+		return nil, errors.New("topBlock.GetHash() != shortChain[0].Hash. Shouldn't happen")
+	}
+
+	if len(resp.OutputIndices) != len(resp.Blocks) {
+		return nil, errors.New(fmt.Sprintf("daemon returned output indices for %d blocks, expected %d",
+			len(resp.OutputIndices), len(resp.Blocks)))
+	}
+
+	readyBlocks := make([]ParsedBlockInfo, 0, len(resp.Blocks)-1)
+	for blockIdx, bce := range resp.Blocks {
+		if blockIdx == 0 {
+			continue
+		}
+
+		if cancelled(ctx) {
+			return nil, utils.ErrInterrupted
+		}
+
+		block, err := txparser.ParseBlockBytes(bce.Block)
+		if err != nil {
+			logging.Log.Errorf("Failed to parse block: %s", err.Error())
+			return nil, err
+		}
+
+		if err = verifier.verify(block, bce.Txs); err != nil {
+			logging.Log.Errorf("Node returned inconsistent chain: %s", err.Error())
+			return nil, err
+		}
+
+		nodeIndices := resp.OutputIndices[blockIdx].Indices
+		if len(nodeIndices) != len(bce.Txs)+1 {
+			return nil, errors.New(fmt.Sprintf("daemon returned output indices for %d transactions of block %s, expected %d",
+				len(nodeIndices), block.GetHash().String(), len(bce.Txs)+1))
+		}
+
+		blockInfo := transformBlock(batch.topHeight+uint64(blockIdx), block, counters)
+		if err = checkOutputIndices(block.MinerTx.Hash, blockInfo.Transactions[0].OutputIndices, nodeIndices[0].Indices); err != nil {
+			logging.Log.Errorf("Output indices mismatch in block %d: %s", blockInfo.Height, err.Error())
+			return nil, err
+		}
+
+		for txIdx, txb := range bce.Txs {
+			txPrefix, err := txparser.ParseTxPrefixBytes(txb)
+			if err != nil {
+				logging.Log.Errorf("Failed to parse transaction: %s, "+
+					"block hash: %s, transaction index: %d, transaction blob: %s",
+					err.Error(), block.GetHash().String(), txIdx+1, hex.EncodeToString(txb))
+				return nil, err
+			}
+
+			txInfo := transformTx(block.TxHashes[txIdx], txb, txPrefix, counters)
+			if err = checkOutputIndices(txInfo.Hash, txInfo.OutputIndices, nodeIndices[txIdx+1].Indices); err != nil {
+				logging.Log.Errorf("Output indices mismatch in block %d: %s", blockInfo.Height, err.Error())
+				return nil, err
+			}
+
+			blockInfo.Transactions = append(blockInfo.Transactions, txInfo)
+		}
+
+		readyBlocks = append(readyBlocks, blockInfo)
+	}
+
+	return readyBlocks, nil
+}
@@ -0,0 +1,681 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exantech/moneroproto"
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
+)
+
+type testBlock struct {
+	hash moneroutil.Hash
+	blob []byte
+}
+
+func makeTestBlock(height uint64, prev moneroutil.Hash, seed byte) testBlock {
+	key := moneroutil.Key{seed, byte(height), byte(height >> 8)}
+
+	blob := []byte{1, 0, 0}
+	blob = append(blob, prev[:]...)
+	blob = append(blob, seed, 0, 0, 0)
+
+	// miner transaction with a single output and tx public key in extra
+	blob = append(blob, 1, 60, 1, 0xff)
+	blob = append(blob, moneroutil.Uint64ToBytes(height)...)
+	blob = append(blob, 1, 1, 2)
+	blob = append(blob, key[:]...)
+	blob = append(blob, 0x21, 1)
+	blob = append(blob, key[:]...)
+	blob = append(blob, 0)
+
+	block, err := txparser.ParseBlockBytes(blob)
+	if err != nil {
+		panic(err)
+	}
+
+	return testBlock{hash: block.GetHash(), blob: blob}
+}
+
+// Continues the chain up to the height with blocks made using the seed
+func makeTestChain(prefix []testBlock, height uint64, seed byte) []testBlock {
+	chain := append([]testBlock{}, prefix...)
+	for h := uint64(len(chain)); h < height; h++ {
+		var prev moneroutil.Hash
+		if h != 0 {
+			prev = chain[h-1].hash
+		}
+
+		chain = append(chain, makeTestBlock(h, prev, seed))
+	}
+
+	return chain
+}
+
+type testChainNode struct {
+	address     string
+	lock        sync.Mutex
+	chain       []testBlock
+	batch       int
+	calls       int
+	switchAfter int // number of calls after which next chain is served
+	next        []testBlock
+	indexShift  uint64 // added to returned output indices to imitate a lying node
+}
+
+func (n *testChainNode) GetBlocks(ctx context.Context, shortChain []utils.HeightInfo, lastHeight uint64) (*moneroproto.GetBlocksFastResponse, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.calls++
+	if n.next != nil && n.calls > n.switchAfter {
+		n.chain = n.next
+		n.next = nil
+	}
+
+	var start uint64
+	for _, hi := range shortChain {
+		if hi.Height < uint64(len(n.chain)) && n.chain[hi.Height].hash == hi.Hash {
+			start = hi.Height
+			break
+		}
+	}
+
+	resp := &moneroproto.GetBlocksFastResponse{
+		StartHeight:   start,
+		CurrentHeight: uint64(len(n.chain)),
+		Status:        []byte("OK"),
+	}
+
+	for h := start; h < uint64(len(n.chain)) && h < start+uint64(n.batch); h++ {
+		resp.Blocks = append(resp.Blocks, moneroproto.BlockCompleteEntry{Block: n.chain[h].blob})
+		resp.OutputIndices = append(resp.OutputIndices, moneroproto.BlockOutputIndices{
+			Indices: []moneroproto.TxOutputIndices{{Indices: []uint64{h + n.indexShift}}},
+		})
+	}
+
+	return resp, nil
+}
+
+func (n *testChainNode) GetHeight(ctx context.Context) (uint64, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return uint64(len(n.chain)), nil
+}
+
+func (n *testChainNode) Address() string {
+	return n.address
+}
+
+type testSyncDb struct {
+	lock      sync.Mutex
+	blocks    []utils.HeightInfo
+	saved     []ParsedBlockInfo
+	counters  OutputCounters
+	reorgs    []testReorg
+	saves     int
+	bulkSaves int
+}
+
+func (d *testSyncDb) GetShortChain() ([]utils.HeightInfo, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	res := make([]utils.HeightInfo, 0, len(d.blocks))
+	for i := len(d.blocks) - 1; i >= 0; i-- {
+		res = append(res, d.blocks[i])
+	}
+
+	return res, nil
+}
+
+func (d *testSyncDb) SaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if blocks[0].Height != uint64(len(d.blocks)) {
+		return errors.New(fmt.Sprintf("saving block %d on top of %d blocks", blocks[0].Height, len(d.blocks)))
+	}
+
+	for _, b := range blocks {
+		d.blocks = append(d.blocks, utils.HeightInfo{Height: b.Height, Hash: b.Hash})
+	}
+
+	d.saved = append(d.saved, blocks...)
+	d.countOutputs(blocks, 1)
+
+	d.saves++
+	return nil
+}
+
+func (d *testSyncDb) BulkSaveParsedBlocks(ctx context.Context, blocks []ParsedBlockInfo) error {
+	if err := d.SaveParsedBlocks(ctx, blocks); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	d.bulkSaves++
+	d.lock.Unlock()
+	return nil
+}
+
+func (d *testSyncDb) GetLastBlockHeight() (*uint64, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if len(d.blocks) == 0 {
+		return nil, nil
+	}
+
+	height := uint64(len(d.blocks) - 1)
+	return &height, nil
+}
+
+type testReorg struct {
+	forkHeight uint64
+	depth      uint64
+	newHashes  []moneroutil.Hash
+	status     string
+}
+
+func (d *testSyncDb) TrimBlockchain(ctx context.Context, height uint64, newHashes []moneroutil.Hash) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	depth := uint64(len(d.blocks)) - height
+	recorded := false
+	for i := range d.reorgs {
+		r := &d.reorgs[i]
+		if r.forkHeight == height-1 && r.depth == depth && equalHashes(r.newHashes, newHashes) && r.status == ReorgConfirmed {
+			r.status, recorded = ReorgApplied, true
+		}
+	}
+
+	if !recorded {
+		d.reorgs = append(d.reorgs, testReorg{height - 1, depth, newHashes, ReorgApplied})
+	}
+
+	if height < uint64(len(d.blocks)) {
+		d.blocks = d.blocks[:height]
+	}
+
+	for i, b := range d.saved {
+		if b.Height >= height {
+			d.countOutputs(d.saved[i:], -1)
+			d.saved = d.saved[:i]
+			break
+		}
+	}
+
+	return nil
+}
+
+func (d *testSyncDb) countOutputs(blocks []ParsedBlockInfo, sign int) {
+	if d.counters == nil {
+		d.counters = make(OutputCounters)
+	}
+
+	for _, b := range blocks {
+		for _, tx := range b.Transactions {
+			for _, amount := range tx.OutputAmounts {
+				d.counters[amount] = uint64(int(d.counters[amount]) + sign)
+			}
+		}
+	}
+}
+
+func (d *testSyncDb) GetOutputCounters() (OutputCounters, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.counters.copy(), nil
+}
+
+func (d *testSyncDb) RebuildOutputCounters(ctx context.Context) error {
+	return errors.New("not implemented")
+}
+
+func (d *testSyncDb) GetBlockHash(height uint64) (*moneroutil.Hash, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if height >= uint64(len(d.blocks)) {
+		return nil, nil
+	}
+
+	return &d.blocks[height].Hash, nil
+}
+
+func (d *testSyncDb) GetReorgStatus(forkHeight, depth uint64, newHashes []moneroutil.Hash) (int64, string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i := len(d.reorgs) - 1; i >= 0; i-- {
+		r := d.reorgs[i]
+		if r.forkHeight == forkHeight && r.depth == depth && equalHashes(r.newHashes, newHashes) && r.status != ReorgApplied {
+			return int64(i), r.status, nil
+		}
+	}
+
+	return 0, "", nil
+}
+
+func (d *testSyncDb) AddPendingReorg(ctx context.Context, forkHeight uint64, newHashes []moneroutil.Hash) (int64, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.reorgs = append(d.reorgs, testReorg{forkHeight, uint64(len(d.blocks)) - forkHeight - 1, newHashes, ReorgPending})
+	return int64(len(d.reorgs) - 1), nil
+}
+
+func (d *testSyncDb) ConfirmReorg(id int64) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if id < 0 || id >= int64(len(d.reorgs)) || d.reorgs[id].status != ReorgPending {
+		return errors.New("no pending reorganization")
+	}
+
+	d.reorgs[id].status = ReorgConfirmed
+	return nil
+}
+
+func (d *testSyncDb) reorgsCopy() []testReorg {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]testReorg{}, d.reorgs...)
+}
+
+func (d *testSyncDb) GetLastTimestamps(count int) ([]uint64, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	res := make([]uint64, 0, count)
+	for _, b := range d.saved {
+		res = append(res, uint64(b.Timestamp))
+	}
+
+	if len(res) > count {
+		res = res[len(res)-count:]
+	}
+
+	return res, nil
+}
+
+func (d *testSyncDb) hashes() []moneroutil.Hash {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	res := make([]moneroutil.Hash, 0, len(d.blocks))
+	for _, b := range d.blocks {
+		res = append(res, b.Hash)
+	}
+
+	return res
+}
+
+func chainHashes(chain []testBlock) []moneroutil.Hash {
+	res := make([]moneroutil.Hash, 0, len(chain))
+	for _, b := range chain {
+		res = append(res, b.hash)
+	}
+
+	return res
+}
+
+const testMaxReorgDepth = 1000
+
+func newTestWorker(db *testSyncDb, node NodeFetcher) *Worker {
+	return NewWorker(db, node, nil, nil, checkpoints.NewCheckpoints(), testMaxReorgDepth)
+}
+
+// Runs sync loop until the db contains the expected chain
+func runTestSync(t *testing.T, db *testSyncDb, node NodeFetcher, expected []testBlock) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestWorker(db, node)
+	done := w.RunSyncLoop(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if assert.ObjectsAreEqual(chainHashes(expected), db.hashes()) {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	assert.Equal(t, utils.ErrInterrupted, <-done)
+	assert.Equal(t, chainHashes(expected), db.hashes())
+}
+
+// The saved genesis block has a single output of amount 1
+func makeTestSyncDb(chain []testBlock) *testSyncDb {
+	return &testSyncDb{
+		blocks:   []utils.HeightInfo{{Height: 0, Hash: chain[0].hash}},
+		counters: OutputCounters{1: 1},
+	}
+}
+
+func TestSyncPipeline(t *testing.T) {
+	chain := makeTestChain(nil, 50, 1)
+	db := makeTestSyncDb(chain)
+
+	runTestSync(t, db, &testChainNode{chain: chain, batch: 7}, chain)
+	assert.True(t, db.saves >= 7)
+	assert.Equal(t, 0, db.bulkSaves)
+}
+
+func TestUseBulkSave(t *testing.T) {
+	makeBatch := func(nodeHeight, from, to uint64) parsedBatch {
+		return parsedBatch{
+			nodeHeight: nodeHeight,
+			blocks:     []ParsedBlockInfo{{Height: from}, {Height: to}},
+		}
+	}
+
+	assert.True(t, useBulkSave(makeBatch(2000000, 1000, 1999)))
+	assert.True(t, useBulkSave(makeBatch(2000+bulkSaveMinBehind+1, 1000, 1999)))
+	assert.False(t, useBulkSave(makeBatch(2000+bulkSaveMinBehind, 1000, 1999)))
+	assert.False(t, useBulkSave(makeBatch(2000, 1998, 1999)))
+}
+
+func TestSyncPipelineReorg(t *testing.T) {
+	chain := makeTestChain(nil, 60, 1)
+	reorganized := makeTestChain(chain[:30], 70, 2)
+	db := makeTestSyncDb(chain)
+
+	node := &testChainNode{chain: chain, batch: 10, switchAfter: 4, next: reorganized}
+	runTestSync(t, db, node, reorganized)
+}
+
+func TestSyncPipelineReorgBelowSaved(t *testing.T) {
+	chain := makeTestChain(nil, 60, 1)
+	reorganized := makeTestChain(chain[:5], 40, 3)
+	db := makeTestSyncDb(chain)
+
+	node := &testChainNode{chain: chain, batch: 10, switchAfter: 5, next: reorganized}
+	runTestSync(t, db, node, reorganized)
+}
+
+func TestSyncPipelineWrongIndices(t *testing.T) {
+	chain := makeTestChain(nil, 50, 1)
+	db := makeTestSyncDb(chain)
+
+	w := newTestWorker(db, &testChainNode{chain: chain, batch: 7, indexShift: 1})
+	select {
+	case err := <-w.RunSyncLoop(context.Background()):
+		assert.Error(t, err)
+		assert.NotEqual(t, utils.ErrInterrupted, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync loop didn't stop")
+	}
+
+	assert.Equal(t, 1, len(db.hashes()))
+}
+
+func TestSyncPipelineLyingNodeBanned(t *testing.T) {
+	chain := makeTestChain(nil, 50, 1)
+	db := makeTestSyncDb(chain)
+
+	liar := &testChainNode{address: "liar", chain: chain, batch: 7, indexShift: 1}
+	honest := &testChainNode{address: "honest", chain: chain, batch: 7}
+	m := makeTestFetcher(liar, honest)
+	runTestSync(t, db, m, chain)
+	assert.True(t, isBanned(m.nodes[0]))
+	assert.False(t, isBanned(m.nodes[1]))
+}
+
+func TestSyncPipelineAllNodesLie(t *testing.T) {
+	chain := makeTestChain(nil, 50, 1)
+	db := makeTestSyncDb(chain)
+
+	m := makeTestFetcher(&testChainNode{address: "a", chain: chain, batch: 7, indexShift: 1},
+		&testChainNode{address: "b", chain: chain, batch: 7, indexShift: 2})
+
+	w := newTestWorker(db, m)
+	select {
+	case err := <-w.RunSyncLoop(context.Background()):
+		assert.Error(t, err)
+		assert.NotEqual(t, utils.ErrInterrupted, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync loop didn't stop")
+	}
+
+	assert.Equal(t, 1, len(db.hashes()))
+}
+
+func TestSyncPipelineCounters(t *testing.T) {
+	chain := makeTestChain(nil, 60, 1)
+	reorganized := makeTestChain(chain[:30], 45, 2)
+	db := makeTestSyncDb(chain)
+
+	node := &testChainNode{chain: chain, batch: 10, switchAfter: 4, next: reorganized}
+	runTestSync(t, db, node, reorganized)
+
+	counters, _ := db.GetOutputCounters()
+	assert.Equal(t, OutputCounters{1: 45}, counters)
+}
+
+func TestSyncPipelineBrokenLinkage(t *testing.T) {
+	chain := makeTestChain(nil, 20, 1)
+	chain = append(chain, makeTestBlock(20, chain[18].hash, 1))
+	chain = makeTestChain(chain, 30, 1)
+	db := makeTestSyncDb(chain)
+
+	w := newTestWorker(db, &testChainNode{chain: chain, batch: 7})
+	select {
+	case err := <-w.RunSyncLoop(context.Background()):
+		assert.Error(t, err)
+		assert.NotEqual(t, utils.ErrInterrupted, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync loop didn't stop")
+	}
+
+	assert.True(t, len(db.hashes()) <= 20)
+}
+
+type testNotifier chan struct{}
+
+func (n testNotifier) NewBlocks() <-chan struct{} {
+	return n
+}
+
+func (n testNotifier) Close() {}
+
+func TestSyncPipelineReorgBelowCheckpoint(t *testing.T) {
+	chain := makeTestChain(nil, 40, 1)
+	reorganized := makeTestChain(chain[:10], 50, 2)
+	db := makeTestSyncDb(chain)
+
+	cps := checkpoints.NewCheckpoints()
+	assert.NoError(t, cps.Add(20, chain[20].hash))
+
+	node := &testChainNode{chain: chain, batch: 10}
+	notifier := make(testNotifier, 1)
+	done := NewWorker(db, node, notifier, nil, cps, testMaxReorgDepth).RunSyncLoop(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(db.hashes()) != len(chain) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	node.lock.Lock()
+	node.chain = reorganized
+	node.lock.Unlock()
+	notifier <- struct{}{}
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+		assert.NotEqual(t, utils.ErrInterrupted, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync loop didn't stop")
+	}
+
+	assert.Equal(t, chainHashes(chain), db.hashes())
+}
+
+func TestCheckCheckpoints(t *testing.T) {
+	chain := makeTestChain(nil, 10, 1)
+	db := makeTestSyncDb(chain)
+	db.blocks = append(db.blocks, utils.HeightInfo{Height: 1, Hash: chain[1].hash})
+
+	cps := checkpoints.NewCheckpoints()
+	assert.NoError(t, cps.Add(1, chain[1].hash))
+	assert.NoError(t, cps.Add(5, chain[5].hash))
+	assert.NoError(t, NewWorker(db, nil, nil, nil, cps, testMaxReorgDepth).CheckCheckpoints())
+
+	cps = checkpoints.NewCheckpoints()
+	assert.NoError(t, cps.Add(1, chain[2].hash))
+	assert.Error(t, NewWorker(db, nil, nil, nil, cps, testMaxReorgDepth).CheckCheckpoints())
+}
+
+// Waits until the db contains the expected chain
+func waitTestChain(db *testSyncDb, expected []testBlock) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && !assert.ObjectsAreEqual(chainHashes(expected), db.hashes()) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSyncReorgRecorded(t *testing.T) {
+	chain := makeTestChain(nil, 40, 1)
+	reorganized := makeTestChain(chain[:35], 45, 2)
+	db := makeTestSyncDb(chain)
+
+	node := &testChainNode{chain: chain, batch: 10}
+	notifier := make(testNotifier, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := NewWorker(db, node, notifier, nil, checkpoints.NewCheckpoints(), 10).RunSyncLoop(ctx)
+
+	waitTestChain(db, chain)
+	node.lock.Lock()
+	node.chain = reorganized
+	node.lock.Unlock()
+	notifier <- struct{}{}
+
+	waitTestChain(db, reorganized)
+	cancel()
+	assert.Equal(t, utils.ErrInterrupted, <-done)
+	assert.Equal(t, chainHashes(reorganized), db.hashes())
+
+	reorgs := db.reorgsCopy()
+	if assert.Len(t, reorgs, 1) {
+		assert.Equal(t, testReorg{34, 5, chainHashes(reorganized[35:40]), ReorgApplied}, reorgs[0])
+	}
+}
+
+func TestSyncDeepReorgConfirmation(t *testing.T) {
+	chain := makeTestChain(nil, 40, 1)
+	reorganized := makeTestChain(chain[:10], 50, 2)
+	db := makeTestSyncDb(chain)
+
+	node := &testChainNode{chain: chain, batch: 10}
+	notifier := make(testNotifier, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := NewWorker(db, node, notifier, nil, checkpoints.NewCheckpoints(), 10).RunSyncLoop(ctx)
+
+	waitTestChain(db, chain)
+	node.lock.Lock()
+	node.chain = reorganized
+	node.lock.Unlock()
+	notifier <- struct{}{}
+
+	// the reorganization is deeper than allowed, so it waits for confirmation
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(db.reorgsCopy()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reorgs := db.reorgsCopy()
+	if !assert.Len(t, reorgs, 1) {
+		cancel()
+		return
+	}
+
+	assert.Equal(t, ReorgPending, reorgs[0].status)
+	assert.Equal(t, uint64(9), reorgs[0].forkHeight)
+	assert.Equal(t, uint64(30), reorgs[0].depth)
+	assert.Equal(t, chainHashes(chain), db.hashes())
+
+	// polling again doesn't duplicate the pending reorganization
+	notifier <- struct{}{}
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, db.reorgsCopy(), 1)
+	assert.Equal(t, chainHashes(chain), db.hashes())
+
+	assert.NoError(t, db.ConfirmReorg(0))
+	notifier <- struct{}{}
+
+	waitTestChain(db, reorganized)
+	cancel()
+	assert.Equal(t, utils.ErrInterrupted, <-done)
+	assert.Equal(t, chainHashes(reorganized), db.hashes())
+
+	reorgs = db.reorgsCopy()
+	if assert.Len(t, reorgs, 1) {
+		assert.Equal(t, ReorgApplied, reorgs[0].status)
+		// replacing blocks of the first fetched batch
+		assert.Equal(t, chainHashes(reorganized[10:19]), reorgs[0].newHashes)
+	}
+}
+
+func TestSyncDeepReorgOtherBranch(t *testing.T) {
+	chain := makeTestChain(nil, 40, 1)
+	reorganized := makeTestChain(chain[:10], 50, 2)
+	other := makeTestChain(chain[:10], 50, 3)
+	db := makeTestSyncDb(chain)
+
+	node := &testChainNode{chain: chain, batch: 10}
+	notifier := make(testNotifier, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := NewWorker(db, node, notifier, nil, checkpoints.NewCheckpoints(), 10).RunSyncLoop(ctx)
+
+	waitTestChain(db, chain)
+	node.lock.Lock()
+	node.chain = reorganized
+	node.lock.Unlock()
+	notifier <- struct{}{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(db.reorgsCopy()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the node switches to another branch of the same depth after the first one is confirmed
+	node.lock.Lock()
+	node.chain = other
+	node.lock.Unlock()
+
+	assert.NoError(t, db.ConfirmReorg(0))
+	notifier <- struct{}{}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(db.reorgsCopy()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	assert.Equal(t, utils.ErrInterrupted, <-done)
+
+	// the other branch waits for its own confirmation
+	assert.Equal(t, chainHashes(chain), db.hashes())
+	reorgs := db.reorgsCopy()
+	if assert.Len(t, reorgs, 2) {
+		assert.Equal(t, ReorgConfirmed, reorgs[0].status)
+		assert.Equal(t, ReorgPending, reorgs[1].status)
+		assert.Equal(t, chainHashes(other[10:19]), reorgs[1].newHashes)
+	}
+}
@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/exantech/moneroutil"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
+)
+
+const (
+	// CRYPTONOTE_BLOCK_FUTURE_TIME_LIMIT and BLOCKCHAIN_TIMESTAMP_CHECK_WINDOW of monerod's check_block_timestamp
+	blockFutureTimeLimit = 60 * 60 * 2
+	timestampCheckWindow = 60
+)
+
+// chainVerifier checks that blocks follow each other on top of the known tip before they are saved,
+// so a malicious or buggy node can't write inconsistent data into the DB
+type chainVerifier struct {
+	height      uint64 // height of the next block
+	prevHash    moneroutil.Hash
+	timestamps  []uint64 // of the last blocks, oldest first
+	checkpoints *checkpoints.Checkpoints
+	now         func() time.Time
+}
+
+// Timestamps of the blocks up to the tip, oldest first. Only the last timestampCheckWindow ones are used
+func newChainVerifier(tip utils.HeightInfo, timestamps []uint64, checkpointsInfo *checkpoints.Checkpoints) *chainVerifier {
+	v := &chainVerifier{
+		height:      tip.Height + 1,
+		prevHash:    tip.Hash,
+		checkpoints: checkpointsInfo,
+		now:         time.Now,
+	}
+
+	if len(timestamps) > timestampCheckWindow {
+		timestamps = timestamps[len(timestamps)-timestampCheckWindow:]
+	}
+
+	v.timestamps = append(v.timestamps, timestamps...)
+	return v
+}
+
+// Checks the block follows the previous one, matches checkpoints and its transaction hashes match the blobs,
+// then advances the tip
+func (v *chainVerifier) verify(block *txparser.Block, txBlobs [][]byte) error {
+	hash := block.GetHash()
+
+	if block.PreviousHash != v.prevHash {
+		return errors.New(fmt.Sprintf("block %s at height %d doesn't follow %s", hash.String(), v.height, v.prevHash.String()))
+	}
+
+	if !v.checkpoints.Check(v.height, hash) {
+		return errors.New(fmt.Sprintf("block %s at height %d conflicts with checkpoint", hash.String(), v.height))
+	}
+
+	if block.MinerTx.Height() != v.height {
+		return errors.New(fmt.Sprintf("block %s at height %d has miner transaction of height %d",
+			hash.String(), v.height, block.MinerTx.Height()))
+	}
+
+	if err := v.checkTimestamp(hash, block.TimeStamp); err != nil {
+		return err
+	}
+
+	if len(txBlobs) != len(block.TxHashes) {
+		return errors.New(fmt.Sprintf("block %s has %d transaction hashes, but %d transactions",
+			hash.String(), len(block.TxHashes), len(txBlobs)))
+	}
+
+	for i, blob := range txBlobs {
+		txHash, err := txparser.TransactionHash(blob)
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to hash transaction %d of block %s: %s", i, hash.String(), err.Error()))
+		}
+
+		if txHash != block.TxHashes[i] {
+			return errors.New(fmt.Sprintf("transaction %d of block %s has hash %s, but block lists %s",
+				i, hash.String(), txHash.String(), block.TxHashes[i].String()))
+		}
+	}
+
+	v.height++
+	v.prevHash = hash
+	v.timestamps = append(v.timestamps, block.TimeStamp)
+	if len(v.timestamps) > timestampCheckWindow {
+		v.timestamps = v.timestamps[1:]
+	}
+
+	return nil
+}
+
+// The timestamp must not be far in the future and not less than the median of the last blocks
+func (v *chainVerifier) checkTimestamp(hash moneroutil.Hash, timestamp uint64) error {
+	limit := uint64(v.now().Unix()) + blockFutureTimeLimit
+	if timestamp > limit {
+		return errors.New(fmt.Sprintf("block %s at height %d has timestamp %d too far in the future",
+			hash.String(), v.height, timestamp))
+	}
+
+	// monerod skips the check until there are enough blocks
+	if len(v.timestamps) < timestampCheckWindow {
+		return nil
+	}
+
+	if median := medianTimestamp(v.timestamps); timestamp < median {
+		return errors.New(fmt.Sprintf("block %s at height %d has timestamp %d less than median %d of the last %d blocks",
+			hash.String(), v.height, timestamp, median, timestampCheckWindow))
+	}
+
+	return nil
+}
+
+func medianTimestamp(timestamps []uint64) uint64 {
+	sorted := append([]uint64{}, timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
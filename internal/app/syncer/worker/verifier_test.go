@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
+)
+
+func parseImportBlock(t *testing.T, b importBlock) *txparser.Block {
+	block, err := txparser.ParseBlockBytes(b.blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func TestChainVerifier(t *testing.T) {
+	chain := makeImportChain()
+	v := newChainVerifier(utils.HeightInfo{Height: 0, Hash: chain[0].hash}, []uint64{0}, checkpoints.NewCheckpoints())
+
+	for _, b := range chain[1:] {
+		assert.NoError(t, v.verify(parseImportBlock(t, b), b.txs))
+	}
+
+	assert.Equal(t, uint64(len(chain)), v.height)
+	assert.Equal(t, chain[len(chain)-1].hash, v.prevHash)
+}
+
+func TestChainVerifierWrongPrevious(t *testing.T) {
+	chain := makeImportChain()
+	v := newChainVerifier(utils.HeightInfo{Height: 1, Hash: chain[1].hash}, nil, checkpoints.NewCheckpoints())
+
+	assert.Error(t, v.verify(parseImportBlock(t, chain[3]), chain[3].txs))
+	// a failed block doesn't advance the tip
+	assert.NoError(t, v.verify(parseImportBlock(t, chain[2]), chain[2].txs))
+}
+
+func TestChainVerifierWrongMinerHeight(t *testing.T) {
+	chain := makeImportChain()
+	b := makeImportBlock(5, chain[1].hash, false)
+
+	v := newChainVerifier(utils.HeightInfo{Height: 1, Hash: chain[1].hash}, nil, checkpoints.NewCheckpoints())
+	assert.Error(t, v.verify(parseImportBlock(t, b), nil))
+}
+
+func TestChainVerifierWrongTransactions(t *testing.T) {
+	chain := makeImportChain()
+	b := chain[4]
+	v := newChainVerifier(utils.HeightInfo{Height: 3, Hash: chain[3].hash}, nil, checkpoints.NewCheckpoints())
+
+	// missing transaction
+	assert.Error(t, v.verify(parseImportBlock(t, b), b.txs[:1]))
+	// substituted transaction
+	assert.Error(t, v.verify(parseImportBlock(t, b), [][]byte{b.txs[0], makeImportTxV1(6)}))
+	// swapped transactions
+	assert.Error(t, v.verify(parseImportBlock(t, b), [][]byte{b.txs[1], b.txs[0]}))
+
+	assert.NoError(t, v.verify(parseImportBlock(t, b), b.txs))
+}
+
+func TestChainVerifierTimestamp(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	var hash moneroutil.Hash
+
+	v := newChainVerifier(utils.HeightInfo{}, []uint64{5, 1, 3}, checkpoints.NewCheckpoints())
+	v.now = func() time.Time { return now }
+
+	// not enough blocks for the median check
+	assert.NoError(t, v.checkTimestamp(hash, 0))
+	assert.NoError(t, v.checkTimestamp(hash, 1000000+blockFutureTimeLimit))
+	assert.Error(t, v.checkTimestamp(hash, 1000000+blockFutureTimeLimit+1))
+
+	timestamps := make([]uint64, 0, timestampCheckWindow+10)
+	for i := uint64(0); i < timestampCheckWindow+10; i++ {
+		timestamps = append(timestamps, 1000+i*10)
+	}
+
+	// only the last blocks are used: 1100...1690, median is 1395
+	v = newChainVerifier(utils.HeightInfo{}, timestamps, checkpoints.NewCheckpoints())
+	v.now = func() time.Time { return now }
+
+	assert.Error(t, v.checkTimestamp(hash, 1394))
+	assert.NoError(t, v.checkTimestamp(hash, 1395))
+}
+
+func TestMedianTimestamp(t *testing.T) {
+	assert.Equal(t, uint64(3), medianTimestamp([]uint64{5, 1, 3}))
+	assert.Equal(t, uint64(4), medianTimestamp([]uint64{5, 1, 3, 8}))
+}
+
+func TestChainVerifierCheckpoint(t *testing.T) {
+	chain := makeImportChain()
+	cps := checkpoints.NewCheckpoints()
+	assert.NoError(t, cps.Add(2, chain[2].hash))
+	assert.NoError(t, cps.Add(3, chain[1].hash))
+
+	v := newChainVerifier(utils.HeightInfo{Height: 1, Hash: chain[1].hash}, nil, cps)
+	assert.NoError(t, v.verify(parseImportBlock(t, chain[2]), chain[2].txs))
+	assert.Error(t, v.verify(parseImportBlock(t, chain[3]), chain[3].txs))
+}
@@ -2,14 +2,18 @@ package worker
 
 import (
 	"context"
-	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/exantech/moneroproto"
 	"github.com/exantech/moneroutil"
 
 	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/metrics"
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
 	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+	"github.com/exantech/monero-fastsync/pkg/checkpoints"
 	"github.com/exantech/monero-fastsync/pkg/genesis"
 )
 
@@ -18,16 +22,25 @@ const (
 )
 
 type Worker struct {
-	db      DbOperator
-	node    NodeFetcher
-	genesis *genesis.GenesisBlockInfo
+	db          DbOperator
+	node        NodeFetcher
+	notifier    NodeNotifier // optional, polling only if nil
+	genesis     *genesis.GenesisBlockInfo
+	checkpoints *checkpoints.Checkpoints
+	// deeper reorganizations are applied only after operator's confirmation
+	maxReorgDepth uint64
 }
 
-func NewWorker(db DbOperator, node NodeFetcher, genesisInfo *genesis.GenesisBlockInfo) *Worker {
+func NewWorker(db DbOperator, node NodeFetcher, notifier NodeNotifier, genesisInfo *genesis.GenesisBlockInfo,
+	checkpointsInfo *checkpoints.Checkpoints, maxReorgDepth uint64) *Worker {
+
 	return &Worker{
-		db:      db,
-		node:    node,
-		genesis: genesisInfo,
+		db:            db,
+		node:          node,
+		notifier:      notifier,
+		genesis:       genesisInfo,
+		checkpoints:   checkpointsInfo,
+		maxReorgDepth: maxReorgDepth,
 	}
 }
 
@@ -42,12 +55,16 @@ func (w *Worker) RunSyncLoop(ctx context.Context) <-chan error {
 }
 
 type ParsedTransactionInfo struct {
-	Hash          moneroutil.Hash
-	Blob          []byte
-	OutputKeys    []moneroutil.Key
-	OutputIndices []uint64
-	UsedInInputs  []uint64
-	Timestamp     uint32
+	Hash              moneroutil.Hash
+	Blob              []byte
+	OutputKeys        []moneroutil.Key
+	ViewTags          []byte // nil if outputs have no view tags
+	PubKeys           []moneroutil.Key
+	AdditionalPubKeys []moneroutil.Key
+	OutputIndices     []uint64
+	OutputAmounts     []uint64 // amounts within which the outputs are indexed, zero for RingCT
+	UsedInInputs      []uint64
+	Timestamp         uint32
 }
 
 func (w *Worker) CheckGenesis(ctx context.Context, init bool) error {
@@ -58,19 +75,13 @@ func (w *Worker) CheckGenesis(ctx context.Context, init bool) error {
 
 	if init {
 		if dbGenesis == nil {
-			tx, err := moneroutil.ParseTransactionBytes(w.genesis.TxBlob)
+			tx, err := txparser.ParseMinerTxBytes(w.genesis.TxBlob)
 			if err != nil {
 				logging.Log.Errorf("Failed to parse genesis transaction: %s", err.Error())
 				return err
 			}
 
-			t := ParsedTransactionInfo{
-				Hash:          tx.GetHash(),
-				Blob:          w.genesis.TxBlob,
-				OutputKeys:    extractOutputKeysArray(tx.Vout),
-				OutputIndices: []uint64{0},
-				UsedInInputs:  inflateInputs(extractUsedInputs(tx.Vin)),
-			}
+			t := transformTx(tx.Hash, w.genesis.TxBlob, tx.TxPrefix, OutputCounters{})
 
 			if err = w.db.SaveParsedBlocks(ctx, []ParsedBlockInfo{{
 				0, w.genesis.Hash, w.genesis.Header, w.genesis.Timestamp, []ParsedTransactionInfo{t},
@@ -94,10 +105,37 @@ func (w *Worker) CheckGenesis(ctx context.Context, init bool) error {
 	return nil
 }
 
+// Saved blocks must not conflict with checkpoints, they could be saved before the checkpoints were added
+func (w *Worker) CheckCheckpoints() error {
+	for _, height := range w.checkpoints.Heights() {
+		saved, err := w.db.GetBlockHash(height)
+		if err != nil {
+			return err
+		}
+
+		if saved == nil {
+			break
+		}
+
+		if !w.checkpoints.Check(height, *saved) {
+			logging.Log.Errorf("Block at height %d in DB: %s conflicts with checkpoint", height, saved.String())
+			return errors.New("saved blockchain conflicts with checkpoints")
+		}
+	}
+
+	return nil
+}
+
 func (w *Worker) syncLoop(ctx context.Context) error {
 	synced := false
 	error := false
 
+	// nil channel blocks forever, so only polling works without notifier
+	var newBlocks <-chan struct{}
+	if w.notifier != nil {
+		newBlocks = w.notifier.NewBlocks()
+	}
+
 	for {
 		if cancelled(ctx) {
 			logging.Log.Info("Interrupting sync loop")
@@ -122,6 +160,9 @@ func (w *Worker) syncLoop(ctx context.Context) error {
 				return utils.ErrInterrupted
 			case <-time.After(syncedPollInterval):
 				synced = false
+			case <-newBlocks:
+				logging.Log.Debug("Node announced new blocks")
+				synced = false
 			}
 		}
 
@@ -144,9 +185,14 @@ func (w *Worker) syncLoop(ctx context.Context) error {
 		logging.Log.Debugf("Last known height: %d, hash: %s", lastHeight, shortChain[0].Hash.String())
 
 		logging.Log.Debug("Requesting blocks from node")
-		resp, err := w.node.GetBlocks(shortChain, 0)
+		resp, err := w.node.GetBlocks(ctx, shortChain, 0)
 		if err != nil {
-			logging.Log.Errorf("Failed to fetch blocks from node: %s", err.Error())
+			if cancelled(ctx) {
+				logging.Log.Info("Interrupting sync loop")
+				return utils.ErrInterrupted
+			}
+
+			logFetchError(err)
 			error = true
 			continue
 		}
@@ -162,91 +208,140 @@ func (w *Worker) syncLoop(ctx context.Context) error {
 			logging.Log.Infof("Blockchain reorganize needed. Last known height: %d, daemon start height: %d",
 				lastHeight, resp.StartHeight)
 
-			if err = w.db.TrimBlockchain(ctx, resp.StartHeight+1); err != nil {
+			// monerod never reorganizes checkpointed blocks, so the node is either compromised or broken
+			if resp.StartHeight < w.checkpoints.MaxHeight() {
+				logging.Log.Errorf("Refusing to trim blockchain from height %d below checkpoint at height %d",
+					resp.StartHeight+1, w.checkpoints.MaxHeight())
+				return errors.New("node requested reorganization below checkpoint")
+			}
+
+			newHashes, allowed, err := w.checkReorg(ctx, lastHeight, resp)
+			if err != nil {
+				logging.Log.Errorf("Failed to check reorganization: %s", err.Error())
+				error = true
+				continue
+			}
+
+			if !allowed {
+				// polling until the operator confirms the reorganization
+				synced = true
+				continue
+			}
+
+			if err = w.db.TrimBlockchain(ctx, resp.StartHeight+1, newHashes); err != nil {
 				logging.Log.Errorf("Failed to trim blockchain: %s", err.Error())
 				error = true
 				continue
 			}
 
+			metrics.Reorgs().Mark(1)
+			metrics.ReorgDepth().Update(int64(lastHeight - resp.StartHeight))
+
 			lastHeight = resp.StartHeight
 			shortChain = trimShortChain(shortChain, lastHeight)
 			logging.Log.Infof("Blockchain trimmed. Top block now: %d, hash: %s", lastHeight, shortChain[0].Hash.String())
 		}
 
-		topBlock, err := moneroutil.ParseBlockBytes(resp.Blocks[0].Block)
-		if err != nil {
-			logging.Log.Errorf("Failed to parse first block: %s", err.Error())
-			return err
+		if len(resp.Blocks) == 1 {
+			logging.Log.Debug("Blockchain is synchronized")
+			synced = true
+			continue
 		}
 
-		// is it possible?
-		if topBlock.GetHash() != shortChain[0].Hash {
-			logging.Log.Errorf("daemon response: {first block: %s, start height: %d}, db: {top hash: %s, top height: %d}",
-				topBlock.GetHash().String(), resp.StartHeight, shortChain[0].Hash.String(), shortChain[0].Height)
-
-			return errors.New("topBlock.GetHash() != shortChain[0].Hash. Shouldn't happen")
+		outcome, err := w.runPipeline(ctx, shortChain, resp)
+		if err != nil {
+			return err
 		}
 
-		if len(resp.Blocks) == 1 {
-			logging.Log.Debug("Blockchain is synchronized")
+		switch outcome {
+		case outcomeSynced:
 			synced = true
-			continue
+		case outcomeError:
+			error = true
+		case outcomeReorg:
+			// restarting from the saved top right away, it'll be trimmed if needed
+		case outcomeBadNode:
+			// restarting from the saved top right away with another node
 		}
+	}
+}
 
-		readyBlocks := make([]ParsedBlockInfo, 0, len(resp.Blocks))
-		for blockIdx, bce := range resp.Blocks {
-			if blockIdx == 0 {
-				continue
-			}
+// Counters are rebuilt from saved transactions if the DB was filled before they were introduced
+func (w *Worker) loadOutputCounters(ctx context.Context) (OutputCounters, error) {
+	counters, err := w.db.GetOutputCounters()
+	if err != nil || len(counters) != 0 {
+		return counters, err
+	}
 
-			if cancelled(ctx) {
-				logging.Log.Info("Interrupting sync loop")
-				return utils.ErrInterrupted
-			}
+	height, err := w.db.GetLastBlockHeight()
+	if err != nil || height == nil {
+		return counters, err
+	}
 
-			block, err := moneroutil.ParseBlockBytes(bce.Block)
-			if err != nil {
-				logging.Log.Errorf("Failed to parse block: %s", err.Error())
-				return err
-			}
+	logging.Log.Warning("Output counters are empty, rebuilding them from saved transactions. It may take a while")
+	if err = w.db.RebuildOutputCounters(ctx); err != nil {
+		return nil, err
+	}
 
-			blockInfo := transformBlock(lastHeight+uint64(blockIdx), block, resp.OutputIndices[blockIdx].Indices[0].Indices)
-
-			for txIdx, txb := range bce.Txs {
-				txPrefix, err := moneroutil.ParseTransactionPrefixBytes(txb)
-				if err != nil {
-					logging.Log.Errorf("Failed to parse transaction: %s, "+
-						"block hash: %s, transaction index: %d, transaction blob: %s",
-						err.Error(), block.GetHash().String(), txIdx+1, hex.EncodeToString(txb))
-					return err
-				}
-
-				blockInfo.Transactions = append(blockInfo.Transactions, ParsedTransactionInfo{
-					Hash:          block.TxHashes[txIdx],
-					Blob:          txb,
-					OutputKeys:    extractOutputKeysArray(txPrefix.Vout),
-					OutputIndices: resp.OutputIndices[blockIdx].Indices[txIdx+1].Indices,
-					UsedInInputs:  inflateInputs(extractUsedInputs(txPrefix.Vin)),
-				})
-			}
+	return w.db.GetOutputCounters()
+}
 
-			readyBlocks = append(readyBlocks, blockInfo)
-		}
+// Returns hashes of the blocks replacing the saved ones and whether the reorganization may be applied.
+// Reorganizations deeper than allowed are saved as pending and wait until the operator confirms them
+func (w *Worker) checkReorg(ctx context.Context, lastHeight uint64,
+	resp *moneroproto.GetBlocksFastResponse) ([]moneroutil.Hash, bool, error) {
 
-		if cancelled(ctx) {
-			logging.Log.Info("Interrupting sync loop")
-			return utils.ErrInterrupted
-		}
+	if resp.StartHeight > lastHeight {
+		return nil, false, errors.New(fmt.Sprintf("node returned blocks from height %d above our top %d",
+			resp.StartHeight, lastHeight))
+	}
 
-		err = w.db.SaveParsedBlocks(ctx, readyBlocks)
+	depth := lastHeight - resp.StartHeight
+	newHashes := make([]moneroutil.Hash, 0, depth)
+	for i := 1; i < len(resp.Blocks) && uint64(i) <= depth; i++ {
+		block, err := txparser.ParseBlockBytes(resp.Blocks[i].Block)
 		if err != nil {
-			logging.Log.Errorf("Failed to save parsed blocks: %s", err.Error())
-			error = true
-			continue
+			return nil, false, err
 		}
+
+		newHashes = append(newHashes, block.GetHash())
 	}
 
-	return nil
+	if depth <= w.maxReorgDepth {
+		return newHashes, true, nil
+	}
+
+	// the confirmation is valid only for the same new blocks, another branch of the same depth needs its own
+	id, status, err := w.db.GetReorgStatus(resp.StartHeight, depth, newHashes)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch status {
+	case ReorgConfirmed:
+		logging.Log.Warningf("Applying confirmed reorganization %d of depth %d at height %d", id, depth, resp.StartHeight)
+		return newHashes, true, nil
+	case ReorgPending:
+	default:
+		if id, err = w.db.AddPendingReorg(ctx, resp.StartHeight, newHashes); err != nil {
+			return nil, false, err
+		}
+	}
+
+	logging.Log.Errorf("Reorganization %d of depth %d at height %d exceeds max_reorg_depth %d. Syncing is halted "+
+		"until it's confirmed with 'syncer confirm-reorg %d'", id, depth, resp.StartHeight, w.maxReorgDepth, id)
+	return nil, false, nil
+}
+
+func logFetchError(err error) {
+	switch err.(type) {
+	case *NetworkError:
+		logging.Log.Warningf("Node is unreachable: %s", err.Error())
+	case *HTTPStatusError, *NodeStatusError:
+		logging.Log.Warningf("Node refused request: %s", err.Error())
+	default:
+		logging.Log.Errorf("Failed to fetch blocks from node: %s", err.Error())
+	}
 }
 
 func cancelled(ctx context.Context) bool {
@@ -258,29 +353,42 @@ func cancelled(ctx context.Context) bool {
 	}
 }
 
-func transformBlock(height uint64, block *moneroutil.Block, indices []uint64) ParsedBlockInfo {
+// Global indices are assigned to the outputs from the counters
+func transformBlock(height uint64, block *txparser.Block, counters OutputCounters) ParsedBlockInfo {
+	minerTx := transformTx(block.MinerTx.Hash, block.MinerTx.Blob, block.MinerTx.TxPrefix, counters)
+
 	return ParsedBlockInfo{
-		Height:    height,
-		Hash:      block.GetHash(),
-		Header:    block.SerializeBlockHeader(),
-		Timestamp: uint32(block.TimeStamp),
-		Transactions: []ParsedTransactionInfo{{
-			Hash:          block.MinerTx.GetHash(),
-			Blob:          block.MinerTx.Serialize(),
-			OutputKeys:    extractOutputKeysArray(block.MinerTx.Vout),
-			OutputIndices: indices,
-			UsedInInputs:  inflateInputs(extractUsedInputs(block.MinerTx.Vin)),
-		}},
+		Height:       height,
+		Hash:         block.GetHash(),
+		Header:       block.Header,
+		Timestamp:    uint32(block.TimeStamp),
+		Transactions: []ParsedTransactionInfo{minerTx},
 	}
 }
 
-func extractOutputKeysArray(outs []*moneroutil.TxOut) []moneroutil.Key {
-	res := make([]moneroutil.Key, 0, len(outs))
-	for _, out := range outs {
-		res = append(res, out.Key)
+func transformTx(hash moneroutil.Hash, blob []byte, prefix *txparser.TxPrefix, counters OutputCounters) ParsedTransactionInfo {
+	txInfo := ParsedTransactionInfo{
+		Hash:         hash,
+		Blob:         blob,
+		OutputKeys:   prefix.OutputKeys(),
+		ViewTags:     prefix.ViewTags(),
+		UsedInInputs: inflateInputs(extractUsedInputs(prefix.Vin)),
 	}
+	txInfo.OutputIndices, txInfo.OutputAmounts = counters.assign(prefix)
+	txInfo.PubKeys, txInfo.AdditionalPubKeys = extractTxPubKeys(hash, prefix.Extra)
 
-	return res
+	return txInfo
+}
+
+// Extracts public keys from transaction extra once, so fsd doesn't have to parse transactions for each wallet
+func extractTxPubKeys(txHash moneroutil.Hash, extra []byte) ([]moneroutil.Key, []moneroutil.Key) {
+	parsed, err := txparser.ParseTxExtra(extra)
+	if err != nil {
+		// this is not critical, keys parsed before the error are still usable
+		logging.Log.Warningf("Failed to parse transaction extra for %s: %s", txHash.String(), err.Error())
+	}
+
+	return parsed.PubKeys, parsed.AdditionalPubKeys
 }
 
 func extractUsedInputs(ins []moneroutil.TxInSerializer) []uint64 {
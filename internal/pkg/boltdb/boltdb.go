@@ -0,0 +1,342 @@
+// Package boltdb keeps the blockchain and wallets in a single bbolt file. It holds the layout shared by
+// the syncer's and fsd's implementations of their DB interfaces
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/exantech/moneroutil"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+)
+
+// Buckets and their keys, all integers are big endian, so keys are ordered by them
+var (
+	BlocksBucket         = []byte("blocks")          // height -> Block
+	BlockHashesBucket    = []byte("block_hashes")    // hash -> height
+	TransactionsBucket   = []byte("transactions")    // height, index in block (uint32) -> Transaction
+	CountersBucket       = []byte("output_counters") // amount -> count
+	ReorgsBucket         = []byte("reorgs")          // id -> Reorg
+	WalletsBucket        = []byte("wallets")         // id (uint32) -> Wallet
+	WalletKeysBucket     = []byte("wallet_keys")     // view secret key, spend public key -> id
+	WalletBlocksBucket   = []byte("wallets_blocks")  // wallet id, height -> nothing
+	WalletOutputsBucket  = []byte("wallets_outputs") // wallet id, output index -> WalletOutput
+	OutputsHeightsBucket = []byte("outputs_heights") // height, wallet id, output index -> nothing
+
+	buckets = [][]byte{BlocksBucket, BlockHashesBucket, TransactionsBucket, CountersBucket, ReorgsBucket,
+		WalletsBucket, WalletKeysBucket, WalletBlocksBucket, WalletOutputsBucket, OutputsHeightsBucket}
+)
+
+type Block struct {
+	Hash      moneroutil.Hash
+	Header    []byte
+	Timestamp uint64
+}
+
+type Transaction struct {
+	Hash          moneroutil.Hash
+	Blob          []byte
+	OutputKeys    []moneroutil.Key
+	ViewTags      []byte
+	OutputIndices []uint64
+	UsedInputs    []uint64
+	Timestamp     uint32
+	// gob doesn't keep empty slices, so extracted public keys are marked explicitly
+	HasPubKeys        bool
+	PubKeys           []moneroutil.Key
+	AdditionalPubKeys []moneroutil.Key
+}
+
+type Wallet struct {
+	Keys          utils.WalletKeys
+	CreatedAt     uint64
+	Lookahead     utils.SubaddressLookahead
+	LastRequestAt int64
+	// false if the wallet's progress block was trimmed before it was scanned
+	HasProgress   bool
+	ScannedHeight uint64
+}
+
+type WalletOutput struct {
+	Height     uint64
+	Subaddress utils.SubaddressIndex
+}
+
+type Reorg struct {
+	CreatedAt       int64
+	ForkHeight      uint64
+	OldHashes       []moneroutil.Hash
+	NewHashes       []moneroutil.Hash
+	AffectedWallets int64
+	Status          string
+}
+
+// How long to wait for the file lock held by another process
+const lockTimeout = 30 * time.Second
+
+type openedFile struct {
+	db   *bolt.DB
+	refs int
+}
+
+var (
+	filesLock sync.Mutex
+	files     = make(map[string]*openedFile)
+)
+
+// Store keeps the file open while the process runs, stores of the same path share it.
+// The file is locked exclusively, so only one process may use it at a time
+type Store struct {
+	path string
+	db   *bolt.DB
+}
+
+// Creates the file with all buckets if it doesn't exist
+func NewStore(path string) (*Store, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	filesLock.Lock()
+	defer filesLock.Unlock()
+
+	f, ok := files[abs]
+	if !ok {
+		db, err := bolt.Open(abs, 0600, &bolt.Options{Timeout: lockTimeout})
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to open %s, it may be used by another process: %s",
+				abs, err.Error()))
+		}
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, name := range buckets {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		f = &openedFile{db: db}
+		files[abs] = f
+	}
+
+	f.refs++
+	return &Store{
+		path: abs,
+		db:   f.db,
+	}, nil
+}
+
+func (s *Store) View(fn func(tx *bolt.Tx) error) error {
+	return s.db.View(fn)
+}
+
+func (s *Store) Update(fn func(tx *bolt.Tx) error) error {
+	return s.db.Update(fn)
+}
+
+// Closes the file when no other store uses it
+func (s *Store) Close() error {
+	filesLock.Lock()
+	defer filesLock.Unlock()
+
+	f, ok := files[s.path]
+	if !ok || f.db != s.db {
+		return nil
+	}
+
+	f.refs--
+	if f.refs > 0 {
+		return nil
+	}
+
+	delete(files, s.path)
+	return f.db.Close()
+}
+
+func Uint32Key(n uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, n)
+	return key
+}
+
+func Uint64Key(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
+
+func TransactionKey(height uint64, index uint32) []byte {
+	return append(Uint64Key(height), Uint32Key(index)...)
+}
+
+func WalletBlockKey(walletId uint32, height uint64) []byte {
+	return append(Uint32Key(walletId), Uint64Key(height)...)
+}
+
+func WalletOutputKey(walletId uint32, output uint64) []byte {
+	return append(Uint32Key(walletId), Uint64Key(output)...)
+}
+
+func OutputHeightKey(height uint64, walletId uint32, output uint64) []byte {
+	return append(Uint64Key(height), WalletOutputKey(walletId, output)...)
+}
+
+func WalletKeysKey(keys utils.WalletKeys) []byte {
+	return append(append([]byte{}, keys.ViewSecretKey[:]...), keys.SpendPublicKey[:]...)
+}
+
+func Encode(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Returns height of the top block, false if there are no blocks
+func TopHeight(tx *bolt.Tx) (uint64, bool) {
+	k, _ := tx.Bucket(BlocksBucket).Cursor().Last()
+	if k == nil {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(k), true
+}
+
+// Returns ErrNotFound if there is no block at the height
+func GetBlock(tx *bolt.Tx, height uint64) (Block, error) {
+	var b Block
+
+	data := tx.Bucket(BlocksBucket).Get(Uint64Key(height))
+	if data == nil {
+		return b, ErrNotFound
+	}
+
+	err := Decode(data, &b)
+	return b, err
+}
+
+// Returns height of the block with the hash, false if there is no such block
+func GetBlockHeight(tx *bolt.Tx, hash moneroutil.Hash) (uint64, bool) {
+	v := tx.Bucket(BlockHashesBucket).Get(hash[:])
+	if v == nil {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(v), true
+}
+
+// Fails if there is a block at the height or with the same hash already, like unique indices do
+func PutBlock(tx *bolt.Tx, height uint64, b Block) error {
+	blocks := tx.Bucket(BlocksBucket)
+	if blocks.Get(Uint64Key(height)) != nil {
+		return errors.New(fmt.Sprintf("block at height %d already exists", height))
+	}
+
+	if _, ok := GetBlockHeight(tx, b.Hash); ok {
+		return errors.New(fmt.Sprintf("block %s already exists", b.Hash.String()))
+	}
+
+	data, err := Encode(b)
+	if err != nil {
+		return err
+	}
+
+	if err = blocks.Put(Uint64Key(height), data); err != nil {
+		return err
+	}
+
+	return tx.Bucket(BlockHashesBucket).Put(b.Hash[:], Uint64Key(height))
+}
+
+// Calls the function for transactions of blocks from the height (inclusive) to the height (exclusive) in order
+func ForEachTransaction(tx *bolt.Tx, from, to uint64, fn func(height uint64, t Transaction) error) error {
+	c := tx.Bucket(TransactionsBucket).Cursor()
+	for k, v := c.Seek(Uint64Key(from)); k != nil; k, v = c.Next() {
+		height := binary.BigEndian.Uint64(k)
+		if height >= to {
+			break
+		}
+
+		var t Transaction
+		if err := Decode(v, &t); err != nil {
+			return err
+		}
+
+		if err := fn(height, t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Deletes all keys starting from the prefix
+func DeleteFrom(b *bolt.Bucket, from []byte) error {
+	c := b.Cursor()
+	for k, _ := c.Seek(from); k != nil; k, _ = c.Seek(from) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetWallet(tx *bolt.Tx, id uint32) (Wallet, error) {
+	var w Wallet
+
+	data := tx.Bucket(WalletsBucket).Get(Uint32Key(id))
+	if data == nil {
+		return w, ErrNotFound
+	}
+
+	err := Decode(data, &w)
+	return w, err
+}
+
+func PutWallet(tx *bolt.Tx, id uint32, w Wallet) error {
+	data, err := Encode(w)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(WalletsBucket).Put(Uint32Key(id), data)
+}
+
+func ForEachWallet(tx *bolt.Tx, fn func(id uint32, w Wallet) error) error {
+	return tx.Bucket(WalletsBucket).ForEach(func(k, v []byte) error {
+		var w Wallet
+		if err := Decode(v, &w); err != nil {
+			return err
+		}
+
+		return fn(binary.BigEndian.Uint32(k), w)
+	})
+}
@@ -0,0 +1,58 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStoreSharedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "blockchain.db")
+	first, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the syncer and fsd running in one process use the same opened file
+	second, err := NewStore(filepath.Join(dir, ".", "blockchain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, first.db == second.db)
+
+	err = first.Update(func(tx *bolt.Tx) error {
+		return PutBlock(tx, 0, Block{Header: []byte{1}})
+	})
+	assert.NoError(t, err)
+
+	// the file stays open until the last store is closed
+	assert.NoError(t, first.Close())
+	err = second.View(func(tx *bolt.Tx) error {
+		b, err := GetBlock(tx, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{1}, b.Header)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, second.Close())
+	assert.Empty(t, files)
+
+	third, err := NewStore(path)
+	if assert.NoError(t, err) {
+		assert.True(t, third.db != second.db)
+		assert.NoError(t, third.Close())
+	}
+}
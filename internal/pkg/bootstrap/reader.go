@@ -0,0 +1,182 @@
+// Package bootstrap reads blockchain.raw files produced by monero-blockchain-export
+package bootstrap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/exantech/moneroutil"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/txparser"
+)
+
+const (
+	fileMagic = uint32(0x28721586)
+	// chunks are much smaller in practice, bigger ones mean the file is corrupted
+	maxChunkSize = 100 * 1024 * 1024
+)
+
+var (
+	ErrBadMagic = errors.New("not a blockchain.raw file")
+)
+
+// BlockPackage is a block along with its transactions as stored in the file
+type BlockPackage struct {
+	Block   *txparser.Block
+	Blob    []byte
+	Txs     []*txparser.TxPrefix
+	TxBlobs [][]byte
+}
+
+// Reader reads block packages in order of heights starting with the genesis block
+type Reader struct {
+	r       *bufio.Reader
+	chunk   *bytes.Reader
+	chunkNo int
+}
+
+// Reads file header and makes the reader positioned at the first block
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1024*1024)
+
+	var magic uint32
+	if err := binary.Read(br, binary.LittleEndian, &magic); err != nil {
+		return nil, err
+	}
+
+	if magic != fileMagic {
+		return nil, ErrBadMagic
+	}
+
+	// header consists of sizes of file info and blocks info followed by them and padded with zeroes
+	var infoSize uint32
+	if err := binary.Read(br, binary.LittleEndian, &infoSize); err != nil {
+		return nil, err
+	}
+
+	if infoSize > 1024 {
+		return nil, fmt.Errorf("file info size is too big: %d", infoSize)
+	}
+
+	info := make([]byte, infoSize)
+	if _, err := io.ReadFull(br, info); err != nil {
+		return nil, err
+	}
+
+	// major and minor versions followed by header size
+	if len(info) < 3 {
+		return nil, errors.New("file info is too short")
+	}
+
+	headerSize, err := moneroutil.ReadVarInt(bytes.NewReader(info[2:]))
+	if err != nil {
+		return nil, err
+	}
+
+	read := uint64(4 + infoSize)
+	if headerSize < read {
+		return nil, fmt.Errorf("invalid header size: %d", headerSize)
+	}
+
+	if _, err = io.CopyN(ioutil.Discard, br, int64(headerSize-read)); err != nil {
+		return nil, err
+	}
+
+	return &Reader{r: br}, nil
+}
+
+// Returns the next block package, io.EOF after the last one
+func (r *Reader) Next() (*BlockPackage, error) {
+	if r.chunk == nil || r.chunk.Len() == 0 {
+		if err := r.readChunk(); err != nil {
+			return nil, err
+		}
+	}
+
+	pkg, err := readBlockPackage(r.chunk)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %s", r.chunkNo, err.Error())
+	}
+
+	return pkg, nil
+}
+
+func (r *Reader) readChunk() error {
+	var size uint32
+	if err := binary.Read(r.r, binary.LittleEndian, &size); err != nil {
+		// io.EOF is returned only if the file ends right between chunks
+		return err
+	}
+
+	if size == 0 || size > maxChunkSize {
+		return fmt.Errorf("invalid chunk size: %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	r.chunk = bytes.NewReader(data)
+	r.chunkNo++
+	return nil
+}
+
+func readBlockPackage(r *bytes.Reader) (*BlockPackage, error) {
+	start := int(r.Size()) - r.Len()
+
+	block, err := txparser.ParseBlock(r)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := make([]byte, int(r.Size())-r.Len()-start)
+	if _, err = r.ReadAt(blob, int64(start)); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	count, err := moneroutil.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if count != uint64(len(block.TxHashes)) {
+		return nil, fmt.Errorf("block %s has %d transaction hashes, but %d transactions",
+			block.GetHash().String(), len(block.TxHashes), count)
+	}
+
+	pkg := &BlockPackage{
+		Block:   block,
+		Blob:    blob,
+		Txs:     make([]*txparser.TxPrefix, 0, count),
+		TxBlobs: make([][]byte, 0, count),
+	}
+
+	for i := uint64(0); i < count; i++ {
+		prefix, txBlob, err := txparser.ReadTransaction(r)
+		if err != nil {
+			return nil, err
+		}
+
+		pkg.Txs = append(pkg.Txs, prefix)
+		pkg.TxBlobs = append(pkg.TxBlobs, txBlob)
+	}
+
+	// block weight, cumulative difficulty and coins generated aren't needed
+	for i := 0; i < 3; i++ {
+		if _, err = moneroutil.ReadVarInt(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return pkg, nil
+}
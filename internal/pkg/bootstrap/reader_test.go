@@ -0,0 +1,145 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/pkg/genesis"
+)
+
+const testHeaderSize = 1024
+
+func makeTestHeader() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, fileMagic)
+
+	// file info: version 0.1 and header size
+	info := append([]byte{0, 1}, moneroutil.Uint64ToBytes(testHeaderSize)...)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(info)))
+	buf.Write(info)
+
+	buf.Write(make([]byte, testHeaderSize+4-buf.Len()))
+	return buf.Bytes()
+}
+
+func makeTestChunk(packages ...[]byte) []byte {
+	var buf bytes.Buffer
+	size := 0
+	for _, p := range packages {
+		size += len(p)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, uint32(size))
+	for _, p := range packages {
+		buf.Write(p)
+	}
+
+	return buf.Bytes()
+}
+
+// Genesis block with a transaction hash and the transaction itself
+func makeTestPackage(tx []byte) []byte {
+	g := genesis.GetGenesisBlockInfo("mainnet")
+
+	blob := append([]byte{}, g.Header...)
+	blob = append(blob, g.TxBlob...)
+	if tx == nil {
+		blob = append(blob, 0, 0)
+	} else {
+		hash := moneroutil.Keccak256(tx)
+		blob = append(blob, 1)
+		blob = append(blob, hash[:]...)
+		blob = append(blob, 1)
+		blob = append(blob, tx...)
+	}
+
+	// block weight, cumulative difficulty, coins generated
+	return append(blob, 0x80, 0x01, 1, 0x80, 0x01)
+}
+
+func makeTestTx() []byte {
+	tx := []byte{1, 0, 1, 0x02, 5, 2, 1, 1}
+	tx = append(tx, make([]byte, 32)...)
+	tx = append(tx, 1, 7, 0x02)
+	tx = append(tx, make([]byte, 32)...)
+	tx = append(tx, 0)
+	return append(tx, make([]byte, 2*64)...)
+}
+
+func TestReader(t *testing.T) {
+	tx := makeTestTx()
+
+	file := makeTestHeader()
+	file = append(file, makeTestChunk(makeTestPackage(nil))...)
+	file = append(file, makeTestChunk(makeTestPackage(tx), makeTestPackage(nil))...)
+
+	r, err := NewReader(bytes.NewReader(file))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	g := genesis.GetGenesisBlockInfo("mainnet")
+
+	pkg, err := r.Next()
+	if assert.NoError(t, err) {
+		assert.Equal(t, g.Hash, pkg.Block.GetHash())
+		assert.Empty(t, pkg.Txs)
+	}
+
+	pkg, err = r.Next()
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]byte{tx}, pkg.TxBlobs)
+		assert.Len(t, pkg.Txs, 1)
+		assert.Equal(t, uint64(7), pkg.Txs[0].Vout[0].Amount)
+	}
+
+	pkg, err = r.Next()
+	if assert.NoError(t, err) {
+		assert.Equal(t, g.Hash, pkg.Block.GetHash())
+	}
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReaderBadMagic(t *testing.T) {
+	file := makeTestHeader()
+	file[0] ^= 0xff
+
+	_, err := NewReader(bytes.NewReader(file))
+	assert.Equal(t, ErrBadMagic, err)
+}
+
+func TestReaderTruncatedChunk(t *testing.T) {
+	file := makeTestHeader()
+	chunk := makeTestChunk(makeTestPackage(nil))
+	file = append(file, chunk[:len(chunk)-3]...)
+
+	r, err := NewReader(bytes.NewReader(file))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReaderTransactionsMismatch(t *testing.T) {
+	pkg := makeTestPackage(nil)
+	// the block has no transaction hashes, but the package claims one transaction
+	pkg[len(pkg)-6] = 1
+
+	file := append(makeTestHeader(), makeTestChunk(pkg)...)
+	r, err := NewReader(bytes.NewReader(file))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = r.Next()
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package metrics
 import (
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/cyberdelia/go-metrics-graphite"
@@ -48,15 +49,52 @@ func Init(c *utils.GraphiteSettings, prefix string) error {
 		return err
 	}
 
-	if c != nil {
-		addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", c.Host, c.Port))
-		if err != nil {
-			logging.Log.Errorf("Failed to resolve graphite address: %s", err.Error())
-			return err
-		}
+	return StartReporting(c, prefix)
+}
 
-		go graphite.Graphite(gometrics.DefaultRegistry, time.Second, prefix, addr)
+// Starts sending registered metrics to graphite, does nothing if settings are nil
+func StartReporting(c *utils.GraphiteSettings, prefix string) error {
+	if c == nil {
+		return nil
 	}
 
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", c.Host, c.Port))
+	if err != nil {
+		logging.Log.Errorf("Failed to resolve graphite address: %s", err.Error())
+		return err
+	}
+
+	go graphite.Graphite(gometrics.DefaultRegistry, time.Second, prefix, addr)
 	return nil
 }
+
+// Returns errors meter of the node, registers it on first use
+func NodeErrors(node string) gometrics.Meter {
+	return gometrics.GetOrRegisterMeter(fmt.Sprintf("syncer.nodes.%s.errors", metricName(node)), nil)
+}
+
+// Returns requests duration timer of the node, registers it on first use
+func NodeRequestDuration(node string) gometrics.Timer {
+	return gometrics.GetOrRegisterTimer(fmt.Sprintf("syncer.nodes.%s.duration", metricName(node)), nil)
+}
+
+// Returns meter of applied blockchain reorganizations, registers it on first use
+func Reorgs() gometrics.Meter {
+	return gometrics.GetOrRegisterMeter("syncer.reorgs.count", nil)
+}
+
+// Returns gauge of the last applied reorganization depth, registers it on first use
+func ReorgDepth() gometrics.Gauge {
+	return gometrics.GetOrRegisterGauge("syncer.reorgs.depth", nil)
+}
+
+// graphite uses dots as path separator
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
@@ -0,0 +1,255 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+)
+
+// Hex column converted into bytea
+type byteaColumn struct {
+	name    string
+	array   bool
+	notNull bool
+}
+
+type byteaTable struct {
+	name    string
+	columns []byteaColumn
+	// recreated after the converted columns replace the old ones
+	indices []string
+}
+
+var byteaTables = []byteaTable{
+	{
+		name:    "blocks",
+		columns: []byteaColumn{{"hash", false, true}},
+		indices: []string{"CREATE UNIQUE INDEX blocks_hash_uindex ON public.blocks USING btree (hash)"},
+	},
+	{
+		name: "transactions",
+		columns: []byteaColumn{
+			{"hash", false, true},
+			{"output_keys", true, true},
+			{"pub_keys", true, false},
+			{"additional_pub_keys", true, false},
+		},
+		indices: []string{"CREATE UNIQUE INDEX transactions_hash_uindex ON public.transactions USING btree (hash)"},
+	},
+	{
+		name: "wallets",
+		columns: []byteaColumn{
+			{"secret_view_key", false, true},
+			{"public_spend_key", false, true},
+		},
+		indices: []string{"CREATE UNIQUE INDEX wallets_secret_view_key_public_spend_key_uindex ON public.wallets " +
+			"USING btree (secret_view_key, public_spend_key)"},
+	},
+	{
+		name: "reorgs",
+		columns: []byteaColumn{
+			{"old_hashes", true, true},
+			{"new_hashes", true, true},
+		},
+	},
+}
+
+// Converts hex character(64) columns of hashes and keys into bytea in place. The rows are converted in batches
+// of ids into temporary columns, each batch in its own transaction, so the tables aren't locked for long.
+// Then the temporary columns replace the old ones and the table is rewritten with VACUUM FULL, since updated rows
+// and dropped columns take the space otherwise. The rewrite needs free disk space for a copy of the table.
+// Interrupted conversion resumes from the start of the table.
+// Syncer and fsd must be stopped meanwhile, since rows written during the conversion wouldn't be converted
+func ConvertToBytea(ctx context.Context, db *sql.DB, batchSize int) error {
+	if batchSize <= 0 {
+		return errors.New(fmt.Sprintf("invalid batch size %d", batchSize))
+	}
+
+	for _, table := range byteaTables {
+		if err := convertTable(ctx, db, table, batchSize); err != nil {
+			return errors.New(fmt.Sprintf("failed to convert table '%s': %s", table.name, err.Error()))
+		}
+	}
+
+	return nil
+}
+
+func convertTable(ctx context.Context, db *sql.DB, table byteaTable, batchSize int) error {
+	pending := make([]byteaColumn, 0, len(table.columns))
+	for _, c := range table.columns {
+		typ, err := getColumnType(ctx, db, table.name, c.name)
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case "bytea", "_bytea":
+			logging.Log.Infof("Column %s.%s is already converted", table.name, c.name)
+		case "bpchar", "_bpchar":
+			pending = append(pending, c)
+		default:
+			return errors.New(fmt.Sprintf("unexpected type '%s' of column %s", typ, c.name))
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	for _, c := range pending {
+		typ, err := getColumnType(ctx, db, table.name, tempColumn(c))
+		if err != nil {
+			return err
+		}
+
+		if len(typ) != 0 {
+			// left by interrupted conversion
+			continue
+		}
+
+		colType := "bytea"
+		if c.array {
+			colType = "bytea[]"
+		}
+
+		if _, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.name, tempColumn(c), colType)); err != nil {
+			return err
+		}
+	}
+
+	if err := convertRows(ctx, db, table.name, pending, batchSize); err != nil {
+		return err
+	}
+
+	if err := replaceColumns(ctx, db, table, pending); err != nil {
+		return err
+	}
+
+	logging.Log.Infof("Rewriting %s to reclaim space of the old columns", table.name)
+	_, err := db.ExecContext(ctx, fmt.Sprintf("VACUUM FULL %s", table.name))
+	return err
+}
+
+func convertRows(ctx context.Context, db *sql.DB, table string, columns []byteaColumn, batchSize int) error {
+	var minId, maxId sql.NullInt64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT min(id), max(id) FROM %s", table)).Scan(&minId, &maxId); err != nil {
+		return err
+	}
+
+	if !minId.Valid {
+		return nil
+	}
+
+	sets := make([]string, 0, len(columns))
+	for _, c := range columns {
+		sets = append(sets, fmt.Sprintf("%s = %s", tempColumn(c), decodeExpression(c)))
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE id >= $1 AND id < $2", table, strings.Join(sets, ", "))
+
+	logging.Log.Infof("Converting %s rows with ids %d...%d", table, minId.Int64, maxId.Int64)
+	for id := minId.Int64; id <= maxId.Int64; id += int64(batchSize) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if _, err := db.ExecContext(ctx, query, id, id+int64(batchSize)); err != nil {
+			return err
+		}
+
+		logging.Log.Debugf("Converted %s rows up to id %d", table, id+int64(batchSize)-1)
+	}
+
+	return nil
+}
+
+func replaceColumns(ctx context.Context, db *sql.DB, table byteaTable, columns []byteaColumn) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	queries := make([]string, 0)
+	for _, c := range columns {
+		// indices on the old column are dropped with it
+		queries = append(queries,
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table.name, c.name),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table.name, tempColumn(c), c.name))
+
+		if c.notNull {
+			queries = append(queries, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table.name, c.name))
+		}
+
+		if !c.array {
+			queries = append(queries, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s_%s_length CHECK (octet_length(%s) = 32)",
+				table.name, table.name, c.name, c.name))
+		}
+	}
+
+	queries = append(queries, table.indices...)
+
+	for _, q := range queries {
+		if _, err = tx.ExecContext(ctx, q); err != nil {
+			return errors.New(fmt.Sprintf("'%s' failed: %s", q, err.Error()))
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Returns internal type name of the column (e.g. "bpchar" or "_bytea" for arrays), empty if there is no such column
+func getColumnType(ctx context.Context, db *sql.DB, table, column string) (string, error) {
+	var typ string
+	err := db.QueryRowContext(ctx, `SELECT udt_name FROM information_schema.columns
+		WHERE table_schema = 'public' AND table_name = $1 AND column_name = $2`, table, column).Scan(&typ)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return typ, err
+}
+
+func tempColumn(c byteaColumn) string {
+	return c.name + "_bytea"
+}
+
+func decodeExpression(c byteaColumn) string {
+	if !c.array {
+		return fmt.Sprintf("decode(%s, 'hex')", c.name)
+	}
+
+	// NULL arrays stay NULL, the order of elements is kept
+	return fmt.Sprintf(`CASE WHEN %s IS NULL THEN NULL ELSE
+		ARRAY(SELECT decode(k, 'hex') FROM unnest(%s) WITH ORDINALITY AS u(k, n) ORDER BY n)::bytea[] END`, c.name, c.name)
+}
+
+// Reverts the conversion in one transaction, it's meant for going back to the previous version shortly after upgrade
+func convertToHexQuery() string {
+	// arrays are converted through their text representation, which depends on bytea output format
+	queries := []string{"SET LOCAL bytea_output = 'hex'"}
+	for _, table := range byteaTables {
+		for _, c := range table.columns {
+			if c.array {
+				queries = append(queries, fmt.Sprintf(
+					`ALTER TABLE %s ALTER COLUMN %s TYPE character(64)[] USING replace(%s::text, '"\\x', '"')::character(64)[]`,
+					table.name, c.name, c.name))
+				continue
+			}
+
+			queries = append(queries,
+				fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s_%s_length", table.name, table.name, c.name),
+				fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE character(64) USING encode(%s, 'hex')",
+					table.name, c.name, c.name))
+		}
+	}
+
+	return strings.Join(queries, ";\n")
+}
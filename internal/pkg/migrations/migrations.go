@@ -0,0 +1,376 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+)
+
+// Step either runs the query in one transaction with the version update,
+// or does the work itself when it doesn't fit into a single transaction
+type step struct {
+	query string
+	run   func(ctx context.Context, db *sql.DB) error
+}
+
+// Migration moves the schema from the previous version to its version and back
+type migration struct {
+	version int
+	name    string
+	up      step
+	down    step
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// rows converted in a single transaction by bytea migration
+const byteaBatchSize = 10000
+
+// Migrations are applied in order. DB made before migrations were introduced is adopted by 'migrate up':
+// all the schema changes made before bytea conversion are idempotent
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial",
+		up: step{query: `
+CREATE TABLE IF NOT EXISTS public.blocks (
+    id serial PRIMARY KEY,
+    height integer NOT NULL,
+    hash character(64) NOT NULL,
+    header bytea NOT NULL,
+    "timestamp" integer NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS blocks_hash_uindex ON public.blocks USING btree (hash);
+CREATE UNIQUE INDEX IF NOT EXISTS blocks_height_uindex ON public.blocks USING btree (height);
+CREATE UNIQUE INDEX IF NOT EXISTS blocks_id_uindex ON public.blocks USING btree (id);
+
+CREATE TABLE IF NOT EXISTS public.transactions (
+    id bigserial PRIMARY KEY,
+    hash character(64) NOT NULL,
+    blob bytea NOT NULL,
+    index_in_block integer NOT NULL,
+    output_keys character(64)[] NOT NULL,
+    output_indices bigint[],
+    used_inputs bigint[] NOT NULL,
+    "timestamp" integer NOT NULL,
+    block_height integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS transactions_block_height_index ON public.transactions USING btree (block_height DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_hash_uindex ON public.transactions USING btree (hash);
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_id_uindex ON public.transactions USING btree (id);
+
+CREATE TABLE IF NOT EXISTS public.wallets (
+    id serial PRIMARY KEY,
+    secret_view_key character(64) NOT NULL,
+    public_spend_key character(64) NOT NULL,
+    last_checked_block_id integer CONSTRAINT wallets_blocks_id_fk REFERENCES public.blocks(id),
+    created_at integer NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS wallets_id_uindex ON public.wallets USING btree (id);
+CREATE UNIQUE INDEX IF NOT EXISTS wallets_secret_view_key_public_spend_key_uindex
+    ON public.wallets USING btree (secret_view_key, public_spend_key);
+
+CREATE TABLE IF NOT EXISTS public.wallets_blocks (
+    id serial PRIMARY KEY,
+    wallet_id integer NOT NULL CONSTRAINT wallets_blocks_wallets_id_fk REFERENCES public.wallets(id),
+    block_id integer NOT NULL CONSTRAINT wallets_blocks_blocks_id_fk REFERENCES public.blocks(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS wallets_blocks_id_uindex ON public.wallets_blocks USING btree (id);
+CREATE UNIQUE INDEX IF NOT EXISTS wallets_blocks_wallet_id_block_id_uindex
+    ON public.wallets_blocks USING btree (wallet_id, block_id);
+
+CREATE TABLE IF NOT EXISTS public.wallets_outputs (
+    wallet_id integer NOT NULL CONSTRAINT wallets_outputs_wallets_id_fk REFERENCES public.wallets(id),
+    output integer NOT NULL,
+    block_height integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS wallets_outputs_block_height_index ON public.wallets_outputs USING btree (block_height DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS wallets_outputs_wallet_id_output_uindex
+    ON public.wallets_outputs USING btree (wallet_id, output);`},
+		down: step{query: `DROP TABLE public.wallets_outputs, public.wallets_blocks, public.wallets,
+    public.transactions, public.blocks`},
+	},
+	{
+		version: 2,
+		name:    "subaddresses",
+		up: step{query: `
+ALTER TABLE public.wallets ADD COLUMN IF NOT EXISTS subaddress_major integer DEFAULT 0 NOT NULL;
+ALTER TABLE public.wallets ADD COLUMN IF NOT EXISTS subaddress_minor integer DEFAULT 0 NOT NULL;
+ALTER TABLE public.wallets_outputs ADD COLUMN IF NOT EXISTS subaddress_major integer DEFAULT 0 NOT NULL;
+ALTER TABLE public.wallets_outputs ADD COLUMN IF NOT EXISTS subaddress_minor integer DEFAULT 0 NOT NULL;`},
+		down: step{query: `
+ALTER TABLE public.wallets DROP COLUMN subaddress_major, DROP COLUMN subaddress_minor;
+ALTER TABLE public.wallets_outputs DROP COLUMN subaddress_major, DROP COLUMN subaddress_minor;`},
+	},
+	{
+		version: 3,
+		name:    "view_tags",
+		up:      step{query: `ALTER TABLE public.transactions ADD COLUMN IF NOT EXISTS output_view_tags bytea`},
+		down:    step{query: `ALTER TABLE public.transactions DROP COLUMN output_view_tags`},
+	},
+	{
+		version: 4,
+		name:    "transaction_public_keys",
+		up: step{query: `
+ALTER TABLE public.transactions ADD COLUMN IF NOT EXISTS pub_keys character(64)[];
+ALTER TABLE public.transactions ADD COLUMN IF NOT EXISTS additional_pub_keys character(64)[];`},
+		down: step{query: `ALTER TABLE public.transactions DROP COLUMN pub_keys, DROP COLUMN additional_pub_keys`},
+	},
+	{
+		version: 5,
+		name:    "wallets_request_time",
+		up: step{query: `
+ALTER TABLE public.wallets ADD COLUMN IF NOT EXISTS last_request_at integer DEFAULT 0 NOT NULL;
+CREATE INDEX IF NOT EXISTS wallets_last_request_at_index ON public.wallets USING btree (last_request_at DESC);`},
+		down: step{query: `ALTER TABLE public.wallets DROP COLUMN last_request_at`},
+	},
+	{
+		version: 6,
+		name:    "output_counters",
+		up: step{query: `
+CREATE TABLE IF NOT EXISTS public.output_counters (
+    amount numeric(20,0) CONSTRAINT output_counters_pkey PRIMARY KEY,
+    count bigint NOT NULL
+);`},
+		down: step{query: `DROP TABLE public.output_counters`},
+	},
+	{
+		version: 7,
+		name:    "reorgs",
+		up: step{query: `
+CREATE TABLE IF NOT EXISTS public.reorgs (
+    id serial PRIMARY KEY,
+    created_at integer NOT NULL,
+    fork_height integer NOT NULL,
+    depth integer NOT NULL,
+    old_hashes character(64)[] NOT NULL,
+    new_hashes character(64)[] NOT NULL,
+    affected_wallets integer NOT NULL,
+    status character varying(16) NOT NULL
+);`},
+		down: step{query: `DROP TABLE public.reorgs`},
+	},
+	{
+		version: 8,
+		name:    "bytea",
+		up: step{run: func(ctx context.Context, db *sql.DB) error {
+			return ConvertToBytea(ctx, db, byteaBatchSize)
+		}},
+		down: step{query: convertToHexQuery()},
+	},
+	{
+		version: 9,
+		name:    "transactions_partitions",
+		up:      step{run: partitionTransactions},
+		down:    step{query: unpartitionTransactions},
+	},
+}
+
+// Schema version the binaries work with
+func ExpectedVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Returns 0 if no migrations were applied
+func GetVersion(ctx context.Context, db *sql.DB) (int, error) {
+	exists, err := versionTableExists(ctx, db)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT max(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// Fails unless the DB schema has exactly the expected version
+func CheckVersion(ctx context.Context, db *sql.DB) error {
+	version, err := GetVersion(ctx, db)
+	if err != nil {
+		return errors.New(fmt.Sprintf("couldn't get DB schema version: %s", err.Error()))
+	}
+
+	if version != ExpectedVersion() {
+		return errors.New(fmt.Sprintf("DB schema version is %d, but %d is expected. Run 'syncer migrate up' "+
+			"with the binaries of the expected version", version, ExpectedVersion()))
+	}
+
+	return nil
+}
+
+// Applies all pending migrations
+func Up(ctx context.Context, db *sql.DB) error {
+	if err := createVersionTable(ctx, db); err != nil {
+		return err
+	}
+
+	version, err := GetVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if version > ExpectedVersion() {
+		return errors.New(fmt.Sprintf("DB schema version %d is newer than the latest known %d", version, ExpectedVersion()))
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		logging.Log.Infof("Applying migration %d (%s)", m.version, m.name)
+		err = apply(ctx, db, m.up, func(ctx context.Context, e executor) error {
+			_, err := e.ExecContext(ctx, "INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3)",
+				m.version, m.name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return errors.New(fmt.Sprintf("migration %d (%s) failed: %s", m.version, m.name, err.Error()))
+		}
+	}
+
+	return nil
+}
+
+// Reverts the last applied migration
+func Down(ctx context.Context, db *sql.DB) error {
+	version, err := GetVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		return errors.New("no migrations applied")
+	}
+
+	for _, m := range migrations {
+		if m.version != version {
+			continue
+		}
+
+		logging.Log.Infof("Reverting migration %d (%s)", m.version, m.name)
+		err = apply(ctx, db, m.down, func(ctx context.Context, e executor) error {
+			_, err := e.ExecContext(ctx, "DELETE FROM schema_version WHERE version = $1", m.version)
+			return err
+		})
+		if err != nil {
+			return errors.New(fmt.Sprintf("reverting migration %d (%s) failed: %s", m.version, m.name, err.Error()))
+		}
+
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("unknown DB schema version %d", version))
+}
+
+// Returns all known migrations, marking the applied ones
+func Status(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	applied, err := getAppliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			s.Applied = true
+			s.AppliedAt = time.Unix(appliedAt, 0)
+		}
+
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func apply(ctx context.Context, db *sql.DB, s step, updateVersion func(ctx context.Context, e executor) error) error {
+	if s.run != nil {
+		if err := s.run(ctx, db); err != nil {
+			return err
+		}
+
+		return updateVersion(ctx, db)
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, s.query); err != nil {
+		return err
+	}
+
+	if err = updateVersion(ctx, tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Returns application times of the applied versions
+func getAppliedVersions(ctx context.Context, db *sql.DB) (map[int]int64, error) {
+	applied := make(map[int]int64)
+
+	exists, err := versionTableExists(ctx, db)
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// The table is created only by migrations, so checking the version doesn't require write access
+func versionTableExists(ctx context.Context, db *sql.DB) (bool, error) {
+	return tableExists(ctx, db, "schema_version")
+}
+
+func createVersionTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS public.schema_version (
+    version integer PRIMARY KEY,
+    name character varying(64) NOT NULL,
+    applied_at integer NOT NULL
+)`)
+	return err
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	names := make(map[string]bool)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+		assert.False(t, names[m.name], "duplicate migration name %s", m.name)
+		names[m.name] = true
+
+		assert.True(t, len(m.up.query) != 0 || m.up.run != nil, "migration %d has no up step", m.version)
+		assert.True(t, len(m.down.query) != 0 || m.down.run != nil, "migration %d has no down step", m.version)
+	}
+
+	assert.Equal(t, len(migrations), ExpectedVersion())
+}
+
+func TestConvertToHexQuery(t *testing.T) {
+	q := convertToHexQuery()
+
+	for _, table := range byteaTables {
+		for _, c := range table.columns {
+			assert.Contains(t, q, "ALTER COLUMN "+c.name+" TYPE character(64)")
+
+			if !c.array {
+				assert.Contains(t, q, "DROP CONSTRAINT "+table.name+"_"+c.name+"_length")
+			}
+		}
+	}
+
+	assert.True(t, strings.HasPrefix(q, "SET LOCAL bytea_output = 'hex'"))
+}
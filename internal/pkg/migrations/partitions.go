@@ -0,0 +1,156 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/exantech/monero-fastsync/internal/pkg/logging"
+	"github.com/exantech/monero-fastsync/internal/pkg/utils"
+)
+
+const unpartitionedTable = "transactions_unpartitioned"
+
+var transactionsColumns = []string{"id", "hash", "blob", "index_in_block", "output_keys", "output_view_tags",
+	"output_indices", "used_inputs", "timestamp", "block_height", "pub_keys", "additional_pub_keys"}
+
+// Unique indices on partitioned table must include the partition key, so hash isn't unique anymore
+const createPartitionedTransactions = `
+CREATE TABLE public.transactions (
+    id bigint DEFAULT nextval('public.transactions_id_seq'::regclass) NOT NULL,
+    hash bytea NOT NULL CONSTRAINT transactions_hash_length CHECK (octet_length(hash) = 32),
+    blob bytea NOT NULL,
+    index_in_block integer NOT NULL,
+    output_keys bytea[] NOT NULL,
+    output_view_tags bytea,
+    output_indices bigint[],
+    used_inputs bigint[] NOT NULL,
+    "timestamp" integer NOT NULL,
+    block_height integer NOT NULL,
+    pub_keys bytea[],
+    additional_pub_keys bytea[],
+    CONSTRAINT transactions_pkey PRIMARY KEY (id, block_height)
+) PARTITION BY RANGE (block_height);
+
+ALTER SEQUENCE public.transactions_id_seq OWNED BY public.transactions.id;
+
+CREATE INDEX transactions_block_height_index ON public.transactions USING btree (block_height DESC);
+CREATE INDEX transactions_hash_index ON public.transactions USING btree (hash);`
+
+// Moves transactions into the table partitioned by block height. The old table is renamed and its rows are moved
+// in batches of partition size, each batch in its own transaction, so interrupted migration resumes where it stopped
+func partitionTransactions(ctx context.Context, db *sql.DB) error {
+	exists, err := tableExists(ctx, db, unpartitionedTable)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err = replaceTransactionsTable(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	var maxHeight sql.NullInt64
+	err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT max(block_height) FROM %s", unpartitionedTable)).Scan(&maxHeight)
+	if err != nil {
+		return err
+	}
+
+	columns := strings.Join(transactionsColumns, ", ")
+	for p := uint64(0); maxHeight.Valid && p <= utils.TransactionsPartition(uint64(maxHeight.Int64)); p++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if err = moveTransactionsPartition(ctx, db, p, columns); err != nil {
+			return errors.New(fmt.Sprintf("failed to move transactions of partition %d: %s", p, err.Error()))
+		}
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", unpartitionedTable))
+	return err
+}
+
+func replaceTransactionsTable(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// only height index is needed by the moving, names of the others are taken by the new table
+	queries := []string{
+		fmt.Sprintf("ALTER TABLE public.transactions RENAME TO %s", unpartitionedTable),
+		fmt.Sprintf("ALTER TABLE %s RENAME CONSTRAINT transactions_pkey TO %s_pkey", unpartitionedTable, unpartitionedTable),
+		fmt.Sprintf("ALTER INDEX transactions_block_height_index RENAME TO %s_block_height_index", unpartitionedTable),
+		"DROP INDEX transactions_hash_uindex",
+		"DROP INDEX transactions_id_uindex",
+		createPartitionedTransactions,
+	}
+
+	for _, q := range queries {
+		if _, err = tx.ExecContext(ctx, q); err != nil {
+			return errors.New(fmt.Sprintf("'%s' failed: %s", q, err.Error()))
+		}
+	}
+
+	return tx.Commit()
+}
+
+func moveTransactionsPartition(ctx context.Context, db *sql.DB, partition uint64, columns string) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, utils.CreateTransactionsPartitionQuery(partition)); err != nil {
+		return err
+	}
+
+	from := partition * utils.TransactionsPartitionSize
+	to := from + utils.TransactionsPartitionSize
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO public.transactions (%s)
+		SELECT %s FROM %s WHERE block_height >= $1 AND block_height < $2`, columns, columns, unpartitionedTable), from, to)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE block_height >= $1 AND block_height < $2",
+		unpartitionedTable), from, to)
+	if err != nil {
+		return err
+	}
+
+	moved, _ := res.RowsAffected()
+	logging.Log.Infof("Moved %d transactions of heights %d...%d into %s", moved, from, to-1,
+		utils.TransactionsPartitionName(partition))
+
+	return tx.Commit()
+}
+
+// Reverts partitioning in one transaction
+const unpartitionTransactions = `
+CREATE TABLE public.transactions_plain (LIKE public.transactions INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+INSERT INTO public.transactions_plain SELECT * FROM public.transactions;
+ALTER SEQUENCE public.transactions_id_seq OWNED BY public.transactions_plain.id;
+DROP TABLE public.transactions;
+ALTER TABLE public.transactions_plain RENAME TO transactions;
+ALTER TABLE public.transactions ADD CONSTRAINT transactions_pkey PRIMARY KEY (id);
+CREATE INDEX transactions_block_height_index ON public.transactions USING btree (block_height DESC);
+CREATE UNIQUE INDEX transactions_hash_uindex ON public.transactions USING btree (hash);
+CREATE UNIQUE INDEX transactions_id_uindex ON public.transactions USING btree (id);`
+
+func tableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", "public."+table).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,166 @@
+package txparser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/exantech/moneroutil"
+)
+
+const (
+	rctTypeNull = byte(0)
+)
+
+var (
+	// block 202612 on mainnet has hash which differs from the calculated one
+	correct202612hash  = moneroutil.Hash{0x42, 0x6d, 0x16, 0xcf, 0xf0, 0x4c, 0x71, 0xf8, 0xb1, 0x63, 0x40, 0xb7, 0x22, 0xdc, 0x40, 0x10, 0xa2, 0xdd, 0x38, 0x31, 0xc2, 0x20, 0x41, 0x43, 0x1f, 0x77, 0x25, 0x47, 0xba, 0x6e, 0x33, 0x1a}
+	existing202612hash = moneroutil.Hash{0xbb, 0xd6, 0x04, 0xd2, 0xba, 0x11, 0xba, 0x27, 0x93, 0x5e, 0x00, 0x6e, 0xd3, 0x9c, 0x9b, 0xfd, 0xd9, 0x9b, 0x76, 0xbf, 0x4a, 0x50, 0x65, 0x4b, 0xc1, 0xe1, 0xe6, 0x12, 0x17, 0x96, 0x26, 0x98}
+)
+
+// MinerTx is a parsed coinbase transaction along with its original blob
+type MinerTx struct {
+	*TxPrefix
+	Blob []byte
+	Hash moneroutil.Hash
+}
+
+// Block keeps original header and miner transaction bytes, so the block may be stored
+// and served without re-serialization
+type Block struct {
+	moneroutil.BlockHeader
+	Header   []byte
+	MinerTx  MinerTx
+	TxHashes []moneroutil.Hash
+}
+
+func ParseBlockBytes(blob []byte) (*Block, error) {
+	return ParseBlock(bytes.NewReader(blob))
+}
+
+// Parses block from the current position of the reader, which is left right after the block
+func ParseBlock(r *bytes.Reader) (*Block, error) {
+	start := int(r.Size()) - r.Len()
+
+	header, err := moneroutil.ParseBlockHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	headerBlob := make([]byte, int(r.Size())-r.Len()-start)
+	if _, err = r.ReadAt(headerBlob, int64(start)); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	minerTx, err := ParseMinerTx(r)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := readCount(r, moneroutil.HashLength)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]moneroutil.Hash, 0, count)
+	for i := 0; i < count; i++ {
+		h, err := moneroutil.ParseHash(r)
+		if err != nil {
+			return nil, err
+		}
+
+		hashes = append(hashes, h)
+	}
+
+	return &Block{
+		BlockHeader: *header,
+		Header:      headerBlob,
+		MinerTx:     *minerTx,
+		TxHashes:    hashes,
+	}, nil
+}
+
+func ParseMinerTxBytes(blob []byte) (*MinerTx, error) {
+	return ParseMinerTx(bytes.NewReader(blob))
+}
+
+// Parses coinbase transaction. Coinbase has no signatures, and since RingCT it has only null rct signature type
+func ParseMinerTx(r *bytes.Reader) (*MinerTx, error) {
+	start := int(r.Size()) - r.Len()
+
+	prefix, err := ParseTxPrefix(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(prefix.Vin) != 1 {
+		return nil, fmt.Errorf("miner transaction must have exactly one input, got %d", len(prefix.Vin))
+	}
+
+	if _, ok := prefix.Vin[0].(*moneroutil.TxInGen); !ok {
+		return nil, fmt.Errorf("miner transaction input is not coinbase")
+	}
+
+	prefixEnd := int(r.Size()) - r.Len()
+	if prefix.Version > 1 {
+		rctType, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if rctType != rctTypeNull {
+			return nil, fmt.Errorf("unexpected miner transaction rct type: %d", rctType)
+		}
+	}
+
+	end := int(r.Size()) - r.Len()
+	blob := make([]byte, end-start)
+	if _, err = r.ReadAt(blob, int64(start)); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	tx := &MinerTx{
+		TxPrefix: prefix,
+		Blob:     blob,
+	}
+
+	if prefix.Version == 1 {
+		tx.Hash = moneroutil.Keccak256(blob)
+	} else {
+		prefixHash := moneroutil.Keccak256(blob[:prefixEnd-start])
+		baseHash := moneroutil.Keccak256(blob[prefixEnd-start:])
+		// prunable part of null rct signature is empty and its hash is null hash
+		tx.Hash = moneroutil.Keccak256(prefixHash[:], baseHash[:], make([]byte, moneroutil.HashLength))
+	}
+
+	return tx, nil
+}
+
+// Returns height from the coinbase input
+func (t *MinerTx) Height() uint64 {
+	return t.Vin[0].(*moneroutil.TxInGen).Height
+}
+
+func (b *Block) GetHashingBlob() []byte {
+	hashes := make([]moneroutil.Hash, 0, len(b.TxHashes)+1)
+	hashes = append(hashes, b.MinerTx.Hash)
+	hashes = append(hashes, b.TxHashes...)
+	root := moneroutil.TreeHash(hashes)
+
+	blob := make([]byte, 0, len(b.Header)+moneroutil.HashLength+8)
+	blob = append(blob, b.Header...)
+	blob = append(blob, root[:]...)
+	blob = append(blob, moneroutil.Uint64ToBytes(uint64(len(hashes)))...)
+	return blob
+}
+
+func (b *Block) GetHash() moneroutil.Hash {
+	bhb := b.GetHashingBlob()
+	hash := moneroutil.Keccak256(moneroutil.Uint64ToBytes(uint64(len(bhb))), bhb)
+
+	if hash == correct202612hash {
+		return existing202612hash
+	}
+
+	return hash
+}
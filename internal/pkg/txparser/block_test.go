@@ -0,0 +1,87 @@
+package txparser
+
+import (
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/pkg/genesis"
+)
+
+func makeGenesisBlob(network string) []byte {
+	g := genesis.GetGenesisBlockInfo(network)
+
+	blob := append([]byte{}, g.Header...)
+	blob = append(blob, g.TxBlob...)
+	return append(blob, 0)
+}
+
+func TestParseBlockGenesisHash(t *testing.T) {
+	for _, network := range []string{"mainnet", "stagenet"} {
+		block, err := ParseBlockBytes(makeGenesisBlob(network))
+		assert.NoError(t, err)
+
+		g := genesis.GetGenesisBlockInfo(network)
+		assert.Equal(t, g.Hash, block.GetHash())
+		assert.Equal(t, g.Header, block.Header)
+		assert.Equal(t, g.TxBlob, block.MinerTx.Blob)
+		assert.Equal(t, uint64(0), block.MinerTx.Height())
+		assert.Empty(t, block.TxHashes)
+	}
+}
+
+func makeTaggedMinerTx(height uint64, keys []moneroutil.Key, tags []byte) []byte {
+	blob := []byte{2, 60, 1, txInGenMarker}
+	blob = append(blob, moneroutil.Uint64ToBytes(height)...)
+	blob = append(blob, byte(len(keys)))
+	for i, k := range keys {
+		blob = append(blob, 0x80, 0x01, txOutToTaggedKeyMarker)
+		blob = append(blob, k[:]...)
+		blob = append(blob, tags[i])
+	}
+
+	// empty extra and null rct type
+	return append(blob, 0, rctTypeNull)
+}
+
+func TestParseMinerTxTaggedOutputs(t *testing.T) {
+	keys := []moneroutil.Key{testKey(1), testKey(2)}
+	blob := makeTaggedMinerTx(2700000, keys, []byte{0xab, 0xcd})
+
+	tx, err := ParseMinerTxBytes(blob)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), tx.Version)
+	assert.Equal(t, uint64(2700000), tx.Height())
+	assert.Equal(t, keys, tx.OutputKeys())
+	assert.Equal(t, []byte{0xab, 0xcd}, tx.ViewTags())
+	assert.Equal(t, uint64(128), tx.Vout[0].Amount)
+	assert.Equal(t, blob, tx.Blob)
+
+	prefixHash := moneroutil.Keccak256(blob[:len(blob)-1])
+	baseHash := moneroutil.Keccak256([]byte{rctTypeNull})
+	assert.Equal(t, moneroutil.Keccak256(prefixHash[:], baseHash[:], make([]byte, 32)), tx.Hash)
+}
+
+func TestParseTxPrefixUntaggedHasNoViewTags(t *testing.T) {
+	g := genesis.GetGenesisBlockInfo("mainnet")
+
+	prefix, err := ParseTxPrefixBytes(g.TxBlob)
+	assert.NoError(t, err)
+	assert.Len(t, prefix.Vout, 1)
+	assert.Nil(t, prefix.ViewTags())
+}
+
+func TestParseTxPrefixUnknownOutput(t *testing.T) {
+	blob := []byte{2, 0, 1, txInGenMarker, 1, 1, 0, 0x05}
+
+	_, err := ParseTxPrefixBytes(blob)
+	assert.Error(t, err)
+}
+
+func TestParseTxPrefixTooManyInputs(t *testing.T) {
+	blob := []byte{2, 0, 0xff, 0xff, 0x03, txInGenMarker, 1}
+
+	_, err := ParseTxPrefixBytes(blob)
+	assert.Error(t, err)
+}
@@ -0,0 +1,114 @@
+package txparser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/exantech/moneroutil"
+)
+
+const (
+	extraTagPadding           = byte(0x00)
+	extraTagPubKey            = byte(0x01)
+	extraTagNonce             = byte(0x02)
+	extraTagMergeMining       = byte(0x03)
+	extraTagAdditionalPubKeys = byte(0x04)
+	extraTagMinergate         = byte(0xde)
+
+	maxExtraPadding = 255
+)
+
+var (
+	ErrUnknownExtraTag = errors.New("unknown transaction extra tag")
+)
+
+// TxExtra holds public keys found in transaction extra.
+// Unlike moneroutil.ParseTransactionExtra additional public keys are kept separately
+// since each of them belongs to the output with the same index.
+type TxExtra struct {
+	PubKeys           []moneroutil.Key
+	AdditionalPubKeys []moneroutil.Key
+}
+
+// Parses transaction extra field. On malformed extra returns keys parsed so far along with the error,
+// the same way monero wallet does.
+func ParseTxExtra(extra []byte) (*TxExtra, error) {
+	res := &TxExtra{}
+	r := bytes.NewReader(extra)
+
+	for {
+		tag, err := r.ReadByte()
+		if err == io.EOF {
+			return res, nil
+		}
+
+		switch tag {
+		case extraTagPadding:
+			// padding takes the rest of extra and must consist of zeroes
+			if r.Len() > maxExtraPadding-1 {
+				return res, errors.New("too long extra padding")
+			}
+
+			for r.Len() != 0 {
+				if b, _ := r.ReadByte(); b != 0 {
+					return res, errors.New("non-zero byte in extra padding")
+				}
+			}
+
+		case extraTagPubKey:
+			key, err := moneroutil.ParseKey(r)
+			if err != nil {
+				return res, err
+			}
+
+			res.PubKeys = append(res.PubKeys, key)
+
+		case extraTagNonce, extraTagMergeMining, extraTagMinergate:
+			if err = skipBlob(r); err != nil {
+				return res, err
+			}
+
+		case extraTagAdditionalPubKeys:
+			count, err := moneroutil.ReadVarInt(r)
+			if err != nil {
+				return res, err
+			}
+
+			if count > uint64(r.Len()/moneroutil.KeyLength) {
+				return res, fmt.Errorf("too many additional public keys: %d", count)
+			}
+
+			keys := make([]moneroutil.Key, 0, count)
+			for i := uint64(0); i < count; i++ {
+				key, err := moneroutil.ParseKey(r)
+				if err != nil {
+					return res, err
+				}
+
+				keys = append(keys, key)
+			}
+
+			res.AdditionalPubKeys = append(res.AdditionalPubKeys, keys...)
+
+		default:
+			return res, ErrUnknownExtraTag
+		}
+	}
+}
+
+// skips varint length prefixed field
+func skipBlob(r *bytes.Reader) error {
+	size, err := moneroutil.ReadVarInt(r)
+	if err != nil {
+		return err
+	}
+
+	if size > uint64(r.Len()) {
+		return io.ErrUnexpectedEOF
+	}
+
+	_, err = r.Seek(int64(size), io.SeekCurrent)
+	return err
+}
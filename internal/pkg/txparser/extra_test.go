@@ -0,0 +1,70 @@
+package txparser
+
+import (
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) moneroutil.Key {
+	k := moneroutil.Key{}
+	for i := range k {
+		k[i] = b
+	}
+
+	return k
+}
+
+func TestParseTxExtraPubKey(t *testing.T) {
+	k1 := testKey(1)
+	extra := append([]byte{extraTagPubKey}, k1.Serialize()...)
+
+	res, err := ParseTxExtra(extra)
+	assert.NoError(t, err)
+	assert.Equal(t, []moneroutil.Key{k1}, res.PubKeys)
+	assert.Empty(t, res.AdditionalPubKeys)
+}
+
+func TestParseTxExtraAdditionalKeys(t *testing.T) {
+	k1, k2, k3 := testKey(1), testKey(2), testKey(3)
+	extra := []byte{extraTagNonce, 3, 0xaa, 0xbb, 0xcc, extraTagPubKey}
+	extra = append(extra, k1.Serialize()...)
+	extra = append(extra, extraTagAdditionalPubKeys, 2)
+	extra = append(extra, k2.Serialize()...)
+	extra = append(extra, k3.Serialize()...)
+
+	res, err := ParseTxExtra(extra)
+	assert.NoError(t, err)
+	assert.Equal(t, []moneroutil.Key{k1}, res.PubKeys)
+	assert.Equal(t, []moneroutil.Key{k2, k3}, res.AdditionalPubKeys)
+}
+
+func TestParseTxExtraPadding(t *testing.T) {
+	k1 := testKey(1)
+	extra := append([]byte{extraTagPubKey}, k1.Serialize()...)
+	extra = append(extra, extraTagPadding, 0, 0, 0)
+
+	res, err := ParseTxExtra(extra)
+	assert.NoError(t, err)
+	assert.Equal(t, []moneroutil.Key{k1}, res.PubKeys)
+}
+
+func TestParseTxExtraUnknownTagKeepsParsed(t *testing.T) {
+	k1 := testKey(1)
+	extra := append([]byte{extraTagPubKey}, k1.Serialize()...)
+	extra = append(extra, 0x7f, 1, 2, 3)
+
+	res, err := ParseTxExtra(extra)
+	assert.Equal(t, ErrUnknownExtraTag, err)
+	assert.Equal(t, []moneroutil.Key{k1}, res.PubKeys)
+}
+
+func TestParseTxExtraTruncatedAdditionalKeys(t *testing.T) {
+	k1 := testKey(1)
+	extra := append([]byte{extraTagAdditionalPubKeys, 2}, k1.Serialize()...)
+
+	res, err := ParseTxExtra(extra)
+	assert.Error(t, err)
+	assert.Empty(t, res.AdditionalPubKeys)
+}
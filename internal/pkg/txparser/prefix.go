@@ -0,0 +1,229 @@
+package txparser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/exantech/moneroutil"
+)
+
+const (
+	txInGenMarker          = byte(0xff)
+	txInToKeyMarker        = byte(0x02)
+	txOutToKeyMarker       = byte(0x02)
+	txOutToTaggedKeyMarker = byte(0x03)
+)
+
+var (
+	viewTagSalt = []byte("view_tag")
+)
+
+// TxOut is a transaction output of either txout_to_key or txout_to_tagged_key type
+type TxOut struct {
+	Amount     uint64
+	Key        moneroutil.Key
+	ViewTag    byte
+	HasViewTag bool
+}
+
+// TxPrefix is a transaction prefix supporting current output formats,
+// moneroutil.ParseTransactionPrefix fails on outputs with view tags
+type TxPrefix struct {
+	Version    uint32
+	UnlockTime uint64
+	Vin        []moneroutil.TxInSerializer
+	Vout       []TxOut
+	Extra      []byte
+}
+
+func ParseTxPrefixBytes(blob []byte) (*TxPrefix, error) {
+	return ParseTxPrefix(bytes.NewReader(blob))
+}
+
+func ParseTxPrefix(r *bytes.Reader) (*TxPrefix, error) {
+	t := &TxPrefix{}
+
+	version, err := moneroutil.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Version = uint32(version)
+	t.UnlockTime, err = moneroutil.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	numInputs, err := readCount(r, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Vin = make([]moneroutil.TxInSerializer, 0, numInputs)
+	for i := 0; i < numInputs; i++ {
+		in, err := parseTxIn(r)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Vin = append(t.Vin, in)
+	}
+
+	numOutputs, err := readCount(r, 1+moneroutil.KeyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Vout = make([]TxOut, 0, numOutputs)
+	for i := 0; i < numOutputs; i++ {
+		out, err := parseTxOut(r)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Vout = append(t.Vout, out)
+	}
+
+	extraLen, err := readCount(r, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Extra = make([]byte, extraLen)
+	if _, err = io.ReadFull(r, t.Extra); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Returns output keys of the transaction
+func (t *TxPrefix) OutputKeys() []moneroutil.Key {
+	res := make([]moneroutil.Key, 0, len(t.Vout))
+	for _, out := range t.Vout {
+		res = append(res, out.Key)
+	}
+
+	return res
+}
+
+// Returns view tags of the outputs, nil if outputs don't have them
+func (t *TxPrefix) ViewTags() []byte {
+	if len(t.Vout) == 0 {
+		return nil
+	}
+
+	res := make([]byte, 0, len(t.Vout))
+	for _, out := range t.Vout {
+		if !out.HasViewTag {
+			// outputs are either all tagged or not since view tags hard fork
+			return nil
+		}
+
+		res = append(res, out.ViewTag)
+	}
+
+	return res
+}
+
+// Derives view tag of the output: first byte of H("view_tag" || derivation || index)
+func DeriveViewTag(derivation moneroutil.Key, index int) byte {
+	h := moneroutil.Keccak256(viewTagSalt, derivation[:], moneroutil.Uint64ToBytes(uint64(index)))
+	return h[0]
+}
+
+func parseTxIn(r *bytes.Reader) (moneroutil.TxInSerializer, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case txInGenMarker:
+		height, err := moneroutil.ReadVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return &moneroutil.TxInGen{Height: height}, nil
+
+	case txInToKeyMarker:
+		in := &moneroutil.TxInToKey{}
+		in.Amount, err = moneroutil.ReadVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+
+		count, err := readCount(r, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		in.KeyOffsets = make([]uint64, 0, count)
+		for i := 0; i < count; i++ {
+			offset, err := moneroutil.ReadVarInt(r)
+			if err != nil {
+				return nil, err
+			}
+
+			in.KeyOffsets = append(in.KeyOffsets, offset)
+		}
+
+		in.KeyImage, err = moneroutil.ParseKey(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return in, nil
+	}
+
+	return nil, fmt.Errorf("unsupported input type: 0x%02x", marker)
+}
+
+func parseTxOut(r *bytes.Reader) (TxOut, error) {
+	out := TxOut{}
+
+	var err error
+	out.Amount, err = moneroutil.ReadVarInt(r)
+	if err != nil {
+		return out, err
+	}
+
+	marker, err := r.ReadByte()
+	if err != nil {
+		return out, err
+	}
+
+	switch marker {
+	case txOutToKeyMarker:
+		out.Key, err = moneroutil.ParseKey(r)
+		return out, err
+
+	case txOutToTaggedKeyMarker:
+		out.Key, err = moneroutil.ParseKey(r)
+		if err != nil {
+			return out, err
+		}
+
+		out.ViewTag, err = r.ReadByte()
+		out.HasViewTag = true
+		return out, err
+	}
+
+	return out, fmt.Errorf("unsupported output type: 0x%02x", marker)
+}
+
+// reads elements count making sure the rest of data can hold them
+func readCount(r *bytes.Reader, minElemSize int) (int, error) {
+	count, err := moneroutil.ReadVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if count > uint64(r.Len()/minElemSize) {
+		return 0, fmt.Errorf("elements count %d exceeds data length", count)
+	}
+
+	return int(count), nil
+}
@@ -0,0 +1,272 @@
+package txparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/exantech/moneroutil"
+)
+
+const (
+	rctTypeFull            = byte(1)
+	rctTypeSimple          = byte(2)
+	rctTypeBulletproof     = byte(3)
+	rctTypeBulletproof2    = byte(4)
+	rctTypeCLSAG           = byte(5)
+	rctTypeBulletproofPlus = byte(6)
+
+	signatureSize = 64
+	// asig (s0, s1, ee) and Ci of the borromean range proof
+	rangeSigSize = 64*moneroutil.KeyLength*2 + moneroutil.KeyLength + 64*moneroutil.KeyLength
+)
+
+// Reads the whole transaction from the current position of the reader. Returns parsed prefix and blob
+// of the transaction. Signatures are skipped without validation, only their sizes are checked
+func ReadTransaction(r *bytes.Reader) (*TxPrefix, []byte, error) {
+	start := int(r.Size()) - r.Len()
+
+	prefix, err := ParseTxPrefix(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if prefix.Version == 1 {
+		err = skipV1Signatures(r, prefix)
+	} else {
+		err = skipRctSignatures(r, prefix)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end := int(r.Size()) - r.Len()
+	blob := make([]byte, end-start)
+	if _, err = r.ReadAt(blob, int64(start)); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	return prefix, blob, nil
+}
+
+// Each input has a signature per ring member
+func skipV1Signatures(r *bytes.Reader, prefix *TxPrefix) error {
+	for _, in := range prefix.Vin {
+		if toKey, ok := in.(*moneroutil.TxInToKey); ok {
+			if err := skip(r, len(toKey.KeyOffsets)*signatureSize); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Transaction hash of version 1 is the hash of the whole blob. Since RingCT it's the hash of prefix,
+// rct base and rct prunable part hashes
+func TransactionHash(blob []byte) (moneroutil.Hash, error) {
+	r := bytes.NewReader(blob)
+	prefix, err := ParseTxPrefix(r)
+	if err != nil {
+		return moneroutil.Hash{}, err
+	}
+
+	if prefix.Version == 1 {
+		if err = skipV1Signatures(r, prefix); err != nil {
+			return moneroutil.Hash{}, err
+		}
+
+		if r.Len() != 0 {
+			return moneroutil.Hash{}, fmt.Errorf("%d bytes left after transaction", r.Len())
+		}
+
+		return moneroutil.Keccak256(blob), nil
+	}
+
+	prefixEnd := len(blob) - r.Len()
+	rctType, err := skipRctBase(r, prefix)
+	if err != nil {
+		return moneroutil.Hash{}, err
+	}
+
+	baseEnd := len(blob) - r.Len()
+	if rctType != rctTypeNull {
+		if err = skipRctPrunable(r, prefix, rctType); err != nil {
+			return moneroutil.Hash{}, err
+		}
+	}
+
+	if r.Len() != 0 {
+		return moneroutil.Hash{}, fmt.Errorf("%d bytes left after transaction", r.Len())
+	}
+
+	prefixHash := moneroutil.Keccak256(blob[:prefixEnd])
+	baseHash := moneroutil.Keccak256(blob[prefixEnd:baseEnd])
+	// prunable part of null rct signature is empty and its hash is null hash
+	var prunableHash moneroutil.Hash
+	if rctType != rctTypeNull {
+		prunableHash = moneroutil.Keccak256(blob[baseEnd:])
+	}
+
+	return moneroutil.Keccak256(prefixHash[:], baseHash[:], prunableHash[:]), nil
+}
+
+func skipRctSignatures(r *bytes.Reader, prefix *TxPrefix) error {
+	rctType, err := skipRctBase(r, prefix)
+	if err != nil || rctType == rctTypeNull {
+		return err
+	}
+
+	return skipRctPrunable(r, prefix, rctType)
+}
+
+// Base part: type, fee, pseudo outputs of simple type, ecdh info and output commitments.
+// Returns the rct type
+func skipRctBase(r *bytes.Reader, prefix *TxPrefix) (byte, error) {
+	if len(prefix.Vin) == 0 {
+		return rctTypeNull, nil
+	}
+
+	rctType, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if rctType == rctTypeNull {
+		return rctType, nil
+	}
+
+	if rctType > rctTypeBulletproofPlus {
+		return 0, fmt.Errorf("unsupported rct type: %d", rctType)
+	}
+
+	inputs := len(prefix.Vin)
+	outputs := len(prefix.Vout)
+
+	if _, err = moneroutil.ReadVarInt(r); err != nil {
+		return 0, err
+	}
+
+	if rctType == rctTypeSimple {
+		if err = skip(r, inputs*moneroutil.KeyLength); err != nil {
+			return 0, err
+		}
+	}
+
+	ecdhSize := 2 * moneroutil.KeyLength
+	if rctType == rctTypeBulletproof2 || rctType == rctTypeCLSAG || rctType == rctTypeBulletproofPlus {
+		// only 8 bytes of amount are kept since Bulletproof2
+		ecdhSize = 8
+	}
+
+	return rctType, skip(r, outputs*(ecdhSize+moneroutil.KeyLength))
+}
+
+// Prunable part: range proofs, ring signatures and pseudo outputs of bulletproof types
+func skipRctPrunable(r *bytes.Reader, prefix *TxPrefix, rctType byte) error {
+	inputs := len(prefix.Vin)
+	outputs := len(prefix.Vout)
+
+	var err error
+	mixin := 0
+	if toKey, ok := prefix.Vin[0].(*moneroutil.TxInToKey); ok && len(toKey.KeyOffsets) > 0 {
+		mixin = len(toKey.KeyOffsets) - 1
+	}
+
+	bulletproofs := rctType >= rctTypeBulletproof
+	if bulletproofs {
+		err = skipBulletproofs(r, rctType, outputs)
+	} else {
+		err = skip(r, outputs*rangeSigSize)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if rctType == rctTypeCLSAG || rctType == rctTypeBulletproofPlus {
+		// s vector, c1 and D for each input
+		err = skip(r, inputs*((mixin+1)*moneroutil.KeyLength+2*moneroutil.KeyLength))
+	} else {
+		// MLSAG: ss matrix and cc, one for all inputs of full type
+		mgs, ssColumns := 1, inputs+1
+		if rctType == rctTypeSimple || rctType == rctTypeBulletproof || rctType == rctTypeBulletproof2 {
+			mgs, ssColumns = inputs, 2
+		}
+
+		err = skip(r, mgs*((mixin+1)*ssColumns*moneroutil.KeyLength+moneroutil.KeyLength))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if bulletproofs {
+		return skip(r, inputs*moneroutil.KeyLength)
+	}
+
+	return nil
+}
+
+func skipBulletproofs(r *bytes.Reader, rctType byte, outputs int) error {
+	var count uint64
+	var err error
+	if rctType == rctTypeBulletproof {
+		var buf [4]byte
+		if _, err = io.ReadFull(r, buf[:]); err != nil {
+			return err
+		}
+
+		count = uint64(binary.LittleEndian.Uint32(buf[:]))
+	} else {
+		count, err = moneroutil.ReadVarInt(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	if count > uint64(outputs) {
+		return fmt.Errorf("bulletproofs count %d exceeds outputs count %d", count, outputs)
+	}
+
+	// fixed size fields before and after L and R vectors
+	before, after := 6*moneroutil.KeyLength, 3*moneroutil.KeyLength
+	if rctType == rctTypeBulletproofPlus {
+		before, after = 6*moneroutil.KeyLength, 0
+	}
+
+	for i := uint64(0); i < count; i++ {
+		if err = skip(r, before); err != nil {
+			return err
+		}
+
+		// L and R
+		for j := 0; j < 2; j++ {
+			n, err := readCount(r, moneroutil.KeyLength)
+			if err != nil {
+				return err
+			}
+
+			if err = skip(r, n*moneroutil.KeyLength); err != nil {
+				return err
+			}
+		}
+
+		if err = skip(r, after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func skip(r *bytes.Reader, n int) error {
+	if n < 0 || n > r.Len() {
+		return fmt.Errorf("unexpected end of data: need %d bytes, have %d", n, r.Len())
+	}
+
+	_, err := r.Seek(int64(n), io.SeekCurrent)
+	return err
+}
@@ -0,0 +1,207 @@
+package txparser
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTxPrefix(version byte, inputs, ring, outputs int) []byte {
+	blob := []byte{version, 0, byte(inputs)}
+	for i := 0; i < inputs; i++ {
+		blob = append(blob, txInToKeyMarker, 0, byte(ring))
+		for j := 0; j < ring; j++ {
+			blob = append(blob, 1)
+		}
+
+		blob = append(blob, make([]byte, moneroutil.KeyLength)...)
+	}
+
+	blob = append(blob, byte(outputs))
+	for i := 0; i < outputs; i++ {
+		blob = append(blob, 0, txOutToKeyMarker)
+		blob = append(blob, make([]byte, moneroutil.KeyLength)...)
+	}
+
+	// empty extra
+	return append(blob, 0)
+}
+
+func appendKeys(blob []byte, count int) []byte {
+	return append(blob, make([]byte, count*moneroutil.KeyLength)...)
+}
+
+// Checks the transaction is read exactly, leaving the following data in the reader
+func assertReadTransaction(t *testing.T, tx []byte) {
+	trailer := []byte{0xde, 0xad}
+	r := bytes.NewReader(append(append([]byte{}, tx...), trailer...))
+
+	prefix, blob, err := ReadTransaction(r)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, prefix)
+		assert.Equal(t, tx, blob)
+		assert.Equal(t, len(trailer), r.Len())
+	}
+
+	// truncated transaction
+	_, _, err = ReadTransaction(bytes.NewReader(tx[:len(tx)-1]))
+	assert.Error(t, err)
+}
+
+func TestReadTransactionV1(t *testing.T) {
+	tx := makeTxPrefix(1, 2, 3, 2)
+	tx = append(tx, make([]byte, 2*3*signatureSize)...)
+
+	assertReadTransaction(t, tx)
+}
+
+func TestReadTransactionFull(t *testing.T) {
+	inputs, ring, outputs := 1, 3, 2
+
+	tx := makeTxPrefix(2, inputs, ring, outputs)
+	tx = append(tx, rctTypeFull, 0x80, 0x01)
+	tx = appendKeys(tx, outputs*3)
+	tx = append(tx, make([]byte, outputs*rangeSigSize)...)
+	tx = appendKeys(tx, ring*(inputs+1)+1)
+
+	assertReadTransaction(t, tx)
+}
+
+func TestReadTransactionBulletproof(t *testing.T) {
+	inputs, ring, outputs := 2, 11, 2
+
+	tx := makeTxPrefix(2, inputs, ring, outputs)
+	tx = append(tx, rctTypeBulletproof, 10)
+	tx = appendKeys(tx, outputs*3)
+	// bulletproofs count as uint32
+	tx = append(tx, 1, 0, 0, 0)
+	tx = appendKeys(tx, 6)
+	tx = append(tx, 7)
+	tx = appendKeys(tx, 7)
+	tx = append(tx, 7)
+	tx = appendKeys(tx, 7)
+	tx = appendKeys(tx, 3)
+	// MLSAGs and pseudo outputs
+	tx = appendKeys(tx, inputs*(ring*2+1))
+	tx = appendKeys(tx, inputs)
+
+	assertReadTransaction(t, tx)
+}
+
+func TestReadTransactionCLSAG(t *testing.T) {
+	inputs, ring, outputs := 2, 16, 2
+
+	tx := makeTxPrefix(2, inputs, ring, outputs)
+	tx = append(tx, rctTypeCLSAG, 10)
+	tx = append(tx, make([]byte, outputs*8)...)
+	tx = appendKeys(tx, outputs)
+	tx = append(tx, 1)
+	tx = appendKeys(tx, 6)
+	tx = append(tx, 7)
+	tx = appendKeys(tx, 7)
+	tx = append(tx, 7)
+	tx = appendKeys(tx, 7)
+	tx = appendKeys(tx, 3)
+	tx = appendKeys(tx, inputs*(ring+2))
+	tx = appendKeys(tx, inputs)
+
+	assertReadTransaction(t, tx)
+}
+
+func TestReadTransactionBulletproofPlus(t *testing.T) {
+	inputs, ring, outputs := 1, 16, 2
+
+	tx := makeTxPrefix(2, inputs, ring, outputs)
+	tx = append(tx, rctTypeBulletproofPlus, 10)
+	tx = append(tx, make([]byte, outputs*8)...)
+	tx = appendKeys(tx, outputs)
+	tx = append(tx, 1)
+	tx = appendKeys(tx, 6)
+	tx = append(tx, 7)
+	tx = appendKeys(tx, 7)
+	tx = append(tx, 7)
+	tx = appendKeys(tx, 7)
+	tx = appendKeys(tx, inputs*(ring+2))
+	tx = appendKeys(tx, inputs)
+
+	assertReadTransaction(t, tx)
+}
+
+func TestReadTransactionTooManyBulletproofs(t *testing.T) {
+	tx := makeTxPrefix(2, 1, 16, 2)
+	tx = append(tx, rctTypeCLSAG, 10)
+	tx = append(tx, make([]byte, 2*8)...)
+	tx = appendKeys(tx, 2)
+	tx = append(tx, 3)
+	tx = appendKeys(tx, 100)
+
+	_, _, err := ReadTransaction(bytes.NewReader(tx))
+	assert.Error(t, err)
+}
+
+func TestReadTransactionUnknownRctType(t *testing.T) {
+	tx := makeTxPrefix(2, 1, 16, 2)
+	tx = append(tx, 7, 10)
+	tx = appendKeys(tx, 100)
+
+	_, _, err := ReadTransaction(bytes.NewReader(tx))
+	assert.Error(t, err)
+}
+
+func TestTransactionHashV1(t *testing.T) {
+	tx := makeTxPrefix(1, 1, 2, 1)
+	tx = append(tx, make([]byte, 2*signatureSize)...)
+
+	hash, err := TransactionHash(tx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, moneroutil.Keccak256(tx), hash)
+	}
+
+	_, err = TransactionHash(append(tx, 0))
+	assert.Error(t, err)
+}
+
+func TestTransactionHashCLSAG(t *testing.T) {
+	inputs, ring, outputs := 1, 16, 2
+
+	prefix := makeTxPrefix(2, inputs, ring, outputs)
+	base := append([]byte{rctTypeCLSAG, 10}, make([]byte, outputs*8)...)
+	base = appendKeys(base, outputs)
+	prunable := append([]byte{1}, make([]byte, 6*moneroutil.KeyLength)...)
+	prunable = append(prunable, 7)
+	prunable = appendKeys(prunable, 7)
+	prunable = append(prunable, 7)
+	prunable = appendKeys(prunable, 7)
+	prunable = appendKeys(prunable, 3)
+	prunable = appendKeys(prunable, inputs*(ring+2))
+	prunable = appendKeys(prunable, inputs)
+
+	tx := append(append(append([]byte{}, prefix...), base...), prunable...)
+
+	prefixHash := moneroutil.Keccak256(prefix)
+	baseHash := moneroutil.Keccak256(base)
+	prunableHash := moneroutil.Keccak256(prunable)
+
+	hash, err := TransactionHash(tx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, moneroutil.Keccak256(prefixHash[:], baseHash[:], prunableHash[:]), hash)
+	}
+
+	_, err = TransactionHash(tx[:len(tx)-1])
+	assert.Error(t, err)
+}
+
+func TestTransactionHashNullRct(t *testing.T) {
+	prefix := makeTxPrefix(2, 1, 1, 1)
+	tx := append(append([]byte{}, prefix...), rctTypeNull)
+
+	prefixHash := moneroutil.Keccak256(prefix)
+	baseHash := moneroutil.Keccak256([]byte{rctTypeNull})
+
+	hash, err := TransactionHash(tx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, moneroutil.Keccak256(prefixHash[:], baseHash[:], make([]byte, moneroutil.HashLength)), hash)
+	}
+}
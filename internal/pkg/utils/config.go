@@ -13,12 +13,20 @@ type ConfigValidator interface {
 	Validate() error
 }
 
+// Storage backends of the blockchain DB
+const (
+	DriverPostgres = "postgres"
+	DriverBolt     = "bolt" // embedded file for single-node deployments
+)
+
 type DbSettings struct {
+	Driver   string `yaml:"driver"` // postgres if empty
 	Host     string `yaml:"host"`
 	Port     uint16 `yaml:"port"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	Database string `yaml:"database"`
+	Path     string `yaml:"path"` // file of bolt driver
 }
 
 type GraphiteSettings struct {
@@ -54,6 +62,28 @@ func ReadJsonConfig(filename string, conf ConfigValidator) error {
 	return conf.Validate()
 }
 
+func (s *DbSettings) GetDriver() string {
+	if len(s.Driver) == 0 {
+		return DriverPostgres
+	}
+
+	return s.Driver
+}
+
+func (s *DbSettings) Validate() error {
+	switch s.GetDriver() {
+	case DriverPostgres:
+	case DriverBolt:
+		if len(s.Path) == 0 {
+			return errors.New("path is required by bolt driver")
+		}
+	default:
+		return errors.New(fmt.Sprintf("unknown db driver: %s", s.Driver))
+	}
+
+	return nil
+}
+
 func (g *GraphiteSettings) Validate() error {
 	if g != nil {
 		if g.Host == "" {
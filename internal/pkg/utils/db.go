@@ -2,17 +2,50 @@ package utils
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 )
 
-func NewDb(settings DbSettings) (*sql.DB, error) {
-	connectStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		settings.Host, settings.Port, settings.User, settings.Password, settings.Database)
+const (
+	// postgres channel the syncer notifies about blockchain changes
+	ChainEventsChannel = "chain_events"
 
-	db, err := sql.Open("postgres", connectStr)
+	ChainEventTop  = "top"  // new blocks saved, height is the new top
+	ChainEventTrim = "trim" // blocks starting from the height removed
+)
+
+type ChainEvent struct {
+	Type   string `json:"type"`
+	Height uint64 `json:"height"`
+}
+
+func NewDb(settings DbSettings) (*sql.DB, error) {
+	db, err := sql.Open("postgres", ConnectionString(settings))
 	if err != nil {
 		return nil, err
 	}
 
 	return db, db.Ping()
 }
+
+func ConnectionString(settings DbSettings) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		settings.Host, settings.Port, settings.User, settings.Password, settings.Database)
+}
+
+// Sends the event to chain events channel. The event is delivered when the transaction is committed
+func NotifyChainEvent(tx *sql.Tx, event ChainEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("SELECT pg_notify($1, $2)", ChainEventsChannel, string(payload))
+	return err
+}
+
+func ParseChainEvent(payload string) (ChainEvent, error) {
+	var event ChainEvent
+	err := json.Unmarshal([]byte(payload), &event)
+	return event, err
+}
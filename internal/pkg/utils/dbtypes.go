@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/exantech/moneroutil"
+	"github.com/lib/pq"
+)
+
+// Hashes and keys are stored as bytea, the types below scan them straight into moneroutil types:
+//   rows.Scan((*utils.DbHash)(&block.Hash))
+
+type DbHash moneroutil.Hash
+
+func (h *DbHash) Scan(src interface{}) error {
+	return scanBytes(h[:], src, "hash")
+}
+
+func (h DbHash) Value() (driver.Value, error) {
+	return h[:], nil
+}
+
+type DbKey moneroutil.Key
+
+func (k *DbKey) Scan(src interface{}) error {
+	return scanBytes(k[:], src, "key")
+}
+
+func (k DbKey) Value() (driver.Value, error) {
+	return k[:], nil
+}
+
+// NullHash is a hash which may be NULL, like sql.NullString
+type NullHash struct {
+	Hash  moneroutil.Hash
+	Valid bool
+}
+
+func (n *NullHash) Scan(src interface{}) error {
+	if src == nil {
+		n.Hash, n.Valid = moneroutil.Hash{}, false
+		return nil
+	}
+
+	n.Valid = true
+	return (*DbHash)(&n.Hash).Scan(src)
+}
+
+// DbHashes is stored as bytea[], NULL is scanned as nil, so it's distinguishable from an empty array
+type DbHashes []moneroutil.Hash
+
+func (hs *DbHashes) Scan(src interface{}) error {
+	var arr pq.ByteaArray
+	if err := arr.Scan(src); err != nil {
+		return err
+	}
+
+	if arr == nil {
+		*hs = nil
+		return nil
+	}
+
+	res := make(DbHashes, len(arr))
+	for i, b := range arr {
+		if err := scanBytes(res[i][:], b, "hash"); err != nil {
+			return err
+		}
+	}
+
+	*hs = res
+	return nil
+}
+
+// Nil is saved as an empty array, not NULL
+func (hs DbHashes) Value() (driver.Value, error) {
+	arr := make(pq.ByteaArray, 0, len(hs))
+	for i := range hs {
+		arr = append(arr, hs[i][:])
+	}
+
+	return arr.Value()
+}
+
+type DbKeys []moneroutil.Key
+
+func (ks *DbKeys) Scan(src interface{}) error {
+	var arr pq.ByteaArray
+	if err := arr.Scan(src); err != nil {
+		return err
+	}
+
+	if arr == nil {
+		*ks = nil
+		return nil
+	}
+
+	res := make(DbKeys, len(arr))
+	for i, b := range arr {
+		if err := scanBytes(res[i][:], b, "key"); err != nil {
+			return err
+		}
+	}
+
+	*ks = res
+	return nil
+}
+
+// Nil is saved as an empty array, not NULL
+func (ks DbKeys) Value() (driver.Value, error) {
+	arr := make(pq.ByteaArray, 0, len(ks))
+	for i := range ks {
+		arr = append(arr, ks[i][:])
+	}
+
+	return arr.Value()
+}
+
+func scanBytes(dst []byte, src interface{}, name string) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprintf("can't scan %T into %s", src, name))
+	}
+
+	if len(b) != len(dst) {
+		return errors.New(fmt.Sprintf("unexpected %s length %d", name, len(b)))
+	}
+
+	copy(dst, b)
+	return nil
+}
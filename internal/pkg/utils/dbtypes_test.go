@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestHash(b byte) moneroutil.Hash {
+	h := moneroutil.Hash{}
+	for i := range h {
+		h[i] = b + byte(i)
+	}
+
+	return h
+}
+
+func TestDbHashRoundTrip(t *testing.T) {
+	h := makeTestHash(1)
+
+	v, err := DbHash(h).Value()
+	assert.NoError(t, err)
+
+	var res moneroutil.Hash
+	assert.NoError(t, (*DbHash)(&res).Scan(v))
+	assert.Equal(t, h, res)
+}
+
+func TestDbHashScanErrors(t *testing.T) {
+	var res moneroutil.Hash
+	assert.Error(t, (*DbHash)(&res).Scan(nil))
+	assert.Error(t, (*DbHash)(&res).Scan(make([]byte, 31)))
+	assert.Error(t, (*DbKey)((*moneroutil.Key)(&res)).Scan("00"))
+}
+
+func TestNullHash(t *testing.T) {
+	var n NullHash
+	assert.NoError(t, n.Scan(nil))
+	assert.False(t, n.Valid)
+
+	h := makeTestHash(5)
+	assert.NoError(t, n.Scan(h[:]))
+	assert.True(t, n.Valid)
+	assert.Equal(t, h, n.Hash)
+}
+
+func TestDbHashesRoundTrip(t *testing.T) {
+	hs := DbHashes{makeTestHash(1), makeTestHash(2), makeTestHash(1)}
+
+	v, err := hs.Value()
+	assert.NoError(t, err)
+
+	var res DbHashes
+	assert.NoError(t, res.Scan([]byte(v.(string))))
+	assert.Equal(t, hs, res)
+}
+
+func TestDbKeysNull(t *testing.T) {
+	v, err := DbKeys(nil).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", v)
+
+	var res DbKeys
+	assert.NoError(t, res.Scan([]byte("{}")))
+	assert.NotNil(t, res)
+	assert.Empty(t, res)
+
+	assert.NoError(t, res.Scan(nil))
+	assert.Nil(t, res)
+
+	assert.Error(t, res.Scan([]byte(`{"\\x0102"}`)))
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"fmt"
+)
+
+// 'transactions' table is partitioned by block height ranges of this size
+const TransactionsPartitionSize = 100000
+
+// Returns number of the partition holding transactions of the height
+func TransactionsPartition(height uint64) uint64 {
+	return height / TransactionsPartitionSize
+}
+
+// Partitions are named by their numbers: transactions_p0 holds heights 0...99999 and so on
+func TransactionsPartitionName(partition uint64) string {
+	return fmt.Sprintf("transactions_p%d", partition)
+}
+
+func CreateTransactionsPartitionQuery(partition uint64) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS public.%s PARTITION OF public.transactions FOR VALUES FROM (%d) TO (%d)",
+		TransactionsPartitionName(partition), partition*TransactionsPartitionSize, (partition+1)*TransactionsPartitionSize)
+}
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionsPartition(t *testing.T) {
+	assert.Equal(t, uint64(0), TransactionsPartition(0))
+	assert.Equal(t, uint64(0), TransactionsPartition(TransactionsPartitionSize-1))
+	assert.Equal(t, uint64(1), TransactionsPartition(TransactionsPartitionSize))
+	assert.Equal(t, uint64(17), TransactionsPartition(1799999))
+}
+
+func TestCreateTransactionsPartitionQuery(t *testing.T) {
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS public.transactions_p17 PARTITION OF public.transactions "+
+		"FOR VALUES FROM (1700000) TO (1800000)", CreateTransactionsPartitionQuery(17))
+}
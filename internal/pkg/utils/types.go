@@ -20,15 +20,33 @@ type WalletKeys struct {
 	SpendPublicKey moneroutil.Key
 }
 
+// Number of subaddress accounts and subaddresses per account to look for
+type SubaddressLookahead struct {
+	Major uint32
+	Minor uint32
+}
+
+// Returns true if all subaddresses within the other lookahead are within this one
+func (l SubaddressLookahead) Covers(other SubaddressLookahead) bool {
+	return l.Major >= other.Major && l.Minor >= other.Minor
+}
+
+type SubaddressIndex struct {
+	Major uint32
+	Minor uint32
+}
+
 type AccountInfo struct {
 	Keys      WalletKeys
 	CreatedAt uint64
+	Lookahead SubaddressLookahead
 }
 
 type WalletEntry struct {
 	Id            uint32
 	Keys          WalletKeys
 	ScannedHeight uint64
+	Lookahead     SubaddressLookahead
 }
 
 func MinUint64(a, b uint64) uint64 {
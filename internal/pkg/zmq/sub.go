@@ -0,0 +1,205 @@
+// Package zmq implements a minimal ZMTP 3.0 SUB socket with NULL security mechanism,
+// enough to receive notifications from monerod's zmq-pub endpoint
+package zmq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+
+	greetingSize = 64
+	// messages bigger than this are considered as protocol error
+	maxFrameSize = 16 * 1024 * 1024
+)
+
+var (
+	ErrProtocol = errors.New("zmtp protocol error")
+)
+
+type SubSocket struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Connects to the publisher at tcp://host:port address and subscribes to the topics
+func DialSub(address string, timeout time.Duration, topics ...string) (*SubSocket, error) {
+	if !strings.HasPrefix(address, "tcp://") {
+		return nil, errors.New(fmt.Sprintf("unsupported address: %s", address))
+	}
+
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(address, "tcp://"), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SubSocket{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err = s.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for _, topic := range topics {
+		// ZMTP 3.0 subscription is a message starting with 0x01 byte
+		if err = s.writeFrame(0, append([]byte{0x01}, topic...)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return s, nil
+}
+
+// Returns frames of the next message, commands from the publisher are skipped
+func (s *SubSocket) Recv() ([][]byte, error) {
+	var frames [][]byte
+	for {
+		flags, body, err := s.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if flags&flagCommand != 0 {
+			continue
+		}
+
+		frames = append(frames, body)
+		if flags&flagMore == 0 {
+			return frames, nil
+		}
+	}
+}
+
+func (s *SubSocket) Close() error {
+	return s.conn.Close()
+}
+
+func (s *SubSocket) handshake() error {
+	if _, err := s.conn.Write(makeGreeting()); err != nil {
+		return err
+	}
+
+	greeting := make([]byte, greetingSize)
+	if _, err := io.ReadFull(s.r, greeting); err != nil {
+		return err
+	}
+
+	if greeting[0] != 0xff || greeting[9] != 0x7f || greeting[10] < 3 {
+		return ErrProtocol
+	}
+
+	if mechanism := string(bytes.TrimRight(greeting[12:32], "\x00")); mechanism != "NULL" {
+		return errors.New(fmt.Sprintf("unsupported security mechanism: %s", mechanism))
+	}
+
+	if err := s.writeFrame(flagCommand, makeReadyCommand("SUB")); err != nil {
+		return err
+	}
+
+	flags, body, err := s.readFrame()
+	if err != nil {
+		return err
+	}
+
+	if flags&flagCommand == 0 || !bytes.HasPrefix(body, []byte("\x05READY")) {
+		return ErrProtocol
+	}
+
+	return nil
+}
+
+func (s *SubSocket) readFrame() (byte, []byte, error) {
+	flags, err := s.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var size uint64
+	if flags&flagLong != 0 {
+		var buf [8]byte
+		if _, err = io.ReadFull(s.r, buf[:]); err != nil {
+			return 0, nil, err
+		}
+
+		size = binary.BigEndian.Uint64(buf[:])
+	} else {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		size = uint64(b)
+	}
+
+	if size > maxFrameSize {
+		return 0, nil, ErrProtocol
+	}
+
+	body := make([]byte, size)
+	if _, err = io.ReadFull(s.r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return flags, body, nil
+}
+
+func (s *SubSocket) writeFrame(flags byte, body []byte) error {
+	_, err := s.conn.Write(encodeFrame(flags, body))
+	return err
+}
+
+func encodeFrame(flags byte, body []byte) []byte {
+	var buf bytes.Buffer
+	if len(body) > 255 {
+		buf.WriteByte(flags | flagLong)
+		binary.Write(&buf, binary.BigEndian, uint64(len(body)))
+	} else {
+		buf.WriteByte(flags)
+		buf.WriteByte(byte(len(body)))
+	}
+
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// signature, version 3.0, NULL mechanism, as-server = 0, filler
+func makeGreeting() []byte {
+	g := make([]byte, greetingSize)
+	g[0] = 0xff
+	g[9] = 0x7f
+	g[10] = 3
+	g[11] = 0
+	copy(g[12:32], "NULL")
+	return g
+}
+
+func makeReadyCommand(socketType string) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(5)
+	buf.WriteString("READY")
+
+	name := "Socket-Type"
+	buf.WriteByte(byte(len(name)))
+	buf.WriteString(name)
+	binary.Write(&buf, binary.BigEndian, uint32(len(socketType)))
+	buf.WriteString(socketType)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,130 @@
+package checkpoints
+
+//go:generate go run ./gen -source $MONERO_SRC/src/checkpoints/checkpoints.cpp -out list.go
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/exantech/moneroutil"
+
+	"github.com/exantech/monero-fastsync/pkg/genesis"
+)
+
+// Checkpoints pins block hashes at some heights. A chain conflicting with any of them is rejected
+type Checkpoints struct {
+	points    map[uint64]moneroutil.Hash
+	maxHeight uint64
+}
+
+type hashLine struct {
+	Height uint64 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// the same format monerod uses for checkpoints.json
+type checkpointsFile struct {
+	HashLines []hashLine `json:"hashlines"`
+}
+
+func NewCheckpoints() *Checkpoints {
+	return &Checkpoints{
+		points: make(map[uint64]moneroutil.Hash),
+	}
+}
+
+// Returns built-in checkpoints of the network, genesis block included
+func GetCheckpoints(network string) *Checkpoints {
+	c := NewCheckpoints()
+	if err := c.Add(0, genesis.GetGenesisBlockInfo(network).Hash); err != nil {
+		panic(err)
+	}
+
+	var lines []hashLine
+	switch network {
+	case "mainnet":
+		lines = mainnetCheckpoints
+	case "stagenet":
+		lines = stagenetCheckpoints
+	case "testnet":
+		lines = testnetCheckpoints
+	}
+
+	if err := c.addLines(lines); err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+func (c *Checkpoints) Add(height uint64, hash moneroutil.Hash) error {
+	if existing, ok := c.points[height]; ok {
+		if existing != hash {
+			return errors.New(fmt.Sprintf("checkpoint at height %d conflicts with existing one: %s != %s",
+				height, hash.String(), existing.String()))
+		}
+
+		return nil
+	}
+
+	c.points[height] = hash
+	if height > c.maxHeight {
+		c.maxHeight = height
+	}
+
+	return nil
+}
+
+// Adds checkpoints from JSON file in monerod format: {"hashlines": [{"height": 1, "hash": "..."}]}
+func (c *Checkpoints) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file checkpointsFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	return c.addLines(file.HashLines)
+}
+
+func (c *Checkpoints) addLines(lines []hashLine) error {
+	for _, line := range lines {
+		hash, err := moneroutil.HexToHash(line.Hash)
+		if err != nil {
+			return errors.New(fmt.Sprintf("bad hash of checkpoint at height %d: %s", line.Height, err.Error()))
+		}
+
+		if err = c.Add(line.Height, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns false if there is a checkpoint at the height with another hash
+func (c *Checkpoints) Check(height uint64, hash moneroutil.Hash) bool {
+	expected, ok := c.points[height]
+	return !ok || expected == hash
+}
+
+func (c *Checkpoints) MaxHeight() uint64 {
+	return c.maxHeight
+}
+
+// Returns heights of all checkpoints in ascending order
+func (c *Checkpoints) Heights() []uint64 {
+	res := make([]uint64, 0, len(c.points))
+	for height := range c.points {
+		res = append(res, height)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
@@ -0,0 +1,78 @@
+package checkpoints
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/exantech/moneroutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/exantech/monero-fastsync/pkg/genesis"
+)
+
+func TestGetCheckpoints(t *testing.T) {
+	for _, network := range []string{"mainnet", "stagenet"} {
+		c := GetCheckpoints(network)
+		assert.True(t, c.Check(0, genesis.GetGenesisBlockInfo(network).Hash))
+		assert.False(t, c.Check(0, moneroutil.Hash{1}))
+	}
+
+	assert.Equal(t, uint64(50000), GetCheckpoints("mainnet").MaxHeight())
+	assert.Equal(t, uint64(0), GetCheckpoints("stagenet").MaxHeight())
+}
+
+func TestAdd(t *testing.T) {
+	c := NewCheckpoints()
+	assert.NoError(t, c.Add(10, moneroutil.Hash{1}))
+	assert.NoError(t, c.Add(5, moneroutil.Hash{2}))
+	assert.NoError(t, c.Add(10, moneroutil.Hash{1}))
+	assert.Error(t, c.Add(10, moneroutil.Hash{3}))
+
+	assert.True(t, c.Check(10, moneroutil.Hash{1}))
+	assert.False(t, c.Check(10, moneroutil.Hash{3}))
+	assert.True(t, c.Check(11, moneroutil.Hash{3}))
+	assert.Equal(t, uint64(10), c.MaxHeight())
+	assert.Equal(t, []uint64{5, 10}, c.Heights())
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestLoadFile(t *testing.T) {
+	hash := moneroutil.Hash{0xab, 0xcd}
+	path := writeTempFile(t, `{"hashlines": [{"height": 100, "hash": "`+hash.String()+`"}]}`)
+	defer os.Remove(path)
+
+	c := GetCheckpoints("stagenet")
+	if assert.NoError(t, c.LoadFile(path)) {
+		assert.True(t, c.Check(100, hash))
+		assert.False(t, c.Check(100, moneroutil.Hash{}))
+		assert.Equal(t, uint64(100), c.MaxHeight())
+	}
+}
+
+func TestLoadFileConflict(t *testing.T) {
+	path := writeTempFile(t, `{"hashlines": [{"height": 0, "hash": "`+moneroutil.Hash{1}.String()+`"}]}`)
+	defer os.Remove(path)
+
+	assert.Error(t, GetCheckpoints("stagenet").LoadFile(path))
+}
+
+func TestLoadFileBadHash(t *testing.T) {
+	path := writeTempFile(t, `{"hashlines": [{"height": 10, "hash": "abc"}]}`)
+	defer os.Remove(path)
+
+	assert.Error(t, NewCheckpoints().LoadFile(path))
+}
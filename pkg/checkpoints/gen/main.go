@@ -0,0 +1,133 @@
+// Generates built-in checkpoints from monerod's src/checkpoints/checkpoints.cpp:
+//
+//	go run ./gen -source /path/to/monero/src/checkpoints/checkpoints.cpp -out list.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type checkpoint struct {
+	height uint64
+	hash   string
+}
+
+var (
+	sourcePath = flag.String("source", "", "path to monerod's checkpoints.cpp")
+	outPath    = flag.String("out", "list.go", "path to generated file")
+
+	checkpointRe = regexp.MustCompile(`ADD_CHECKPOINT2?\(\s*(\d+)\s*,\s*"([0-9a-f]{64})"`)
+	networks     = []string{"mainnet", "stagenet", "testnet"}
+)
+
+func main() {
+	flag.Parse()
+	if len(*sourcePath) == 0 {
+		flag.Usage()
+		log.Fatal("Source path is required")
+	}
+
+	f, err := os.Open(*sourcePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+
+	points, err := parseCheckpoints(f)
+	if err != nil {
+		log.Fatalf("Failed to parse %s: %s", *sourcePath, err.Error())
+	}
+
+	data, err := generate(points)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(*outPath, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Reads checkpoints of init_default_checkpoints, testnet and stagenet ones are in their own branches there
+// followed by mainnet ones. Genesis blocks are skipped, they are added from the genesis package
+func parseCheckpoints(r io.Reader) (map[string][]checkpoint, error) {
+	res := make(map[string][]checkpoint)
+
+	inFunction := false
+	network := "mainnet"
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, "init_default_checkpoints("):
+			inFunction = true
+			continue
+		case !inFunction:
+			continue
+		case strings.Contains(line, "nettype == TESTNET"):
+			network = "testnet"
+		case strings.Contains(line, "nettype == STAGENET"):
+			network = "stagenet"
+		case strings.Contains(line, "return true;"):
+			if network == "mainnet" {
+				inFunction = false
+			}
+
+			network = "mainnet"
+		}
+
+		m := checkpointRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		height, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if height != 0 {
+			res[network] = append(res[network], checkpoint{height, m[2]})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(res["mainnet"]) == 0 {
+		return nil, errors.New("no mainnet checkpoints found")
+	}
+
+	return res, nil
+}
+
+func generate(points map[string][]checkpoint) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen from monerod's src/checkpoints/checkpoints.cpp. DO NOT EDIT.\n\n")
+	b.WriteString("package checkpoints\n")
+
+	for _, network := range networks {
+		fmt.Fprintf(&b, "\nvar %sCheckpoints = []hashLine{\n", network)
+		for _, p := range points[network] {
+			fmt.Fprintf(&b, "{%d, %q},\n", p.height, p.hash)
+		}
+
+		b.WriteString("}\n")
+	}
+
+	return format.Source(b.Bytes())
+}
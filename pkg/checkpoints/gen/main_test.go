@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSource = `
+  bool checkpoints::init_default_checkpoints(network_type nettype)
+  {
+    if (nettype == TESTNET)
+    {
+      ADD_CHECKPOINT2(0,     "1111111111111111111111111111111111111111111111111111111111111111", "0x1");
+      ADD_CHECKPOINT2(1000000, "2222222222222222222222222222222222222222222222222222222222222222", "0x2");
+      return true;
+    }
+    if (nettype == STAGENET)
+    {
+      ADD_CHECKPOINT2(0,       "3333333333333333333333333333333333333333333333333333333333333333", "0x1");
+      ADD_CHECKPOINT2(10000,   "4444444444444444444444444444444444444444444444444444444444444444", "0x3");
+      return true;
+    }
+    ADD_CHECKPOINT(1,  "5555555555555555555555555555555555555555555555555555555555555555");
+    ADD_CHECKPOINT2(10, "6666666666666666666666666666666666666666666666666666666666666666", "0x4");
+    return true;
+  }
+
+  void checkpoints::unrelated()
+  {
+    ADD_CHECKPOINT(7, "7777777777777777777777777777777777777777777777777777777777777777");
+  }
+`
+
+func TestParseCheckpoints(t *testing.T) {
+	points, err := parseCheckpoints(strings.NewReader(testSource))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]checkpoint{
+		"testnet":  {{1000000, strings.Repeat("2", 64)}},
+		"stagenet": {{10000, strings.Repeat("4", 64)}},
+		"mainnet":  {{1, strings.Repeat("5", 64)}, {10, strings.Repeat("6", 64)}},
+	}, points)
+
+	data, err := generate(points)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "var stagenetCheckpoints = []hashLine{\n\t{10000, \""+strings.Repeat("4", 64)+"\"},\n}")
+
+	_, err = parseCheckpoints(strings.NewReader("nothing"))
+	assert.Error(t, err)
+}
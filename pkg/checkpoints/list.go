@@ -0,0 +1,18 @@
+// Built-in checkpoints, they are regenerated from monerod's src/checkpoints/checkpoints.cpp with go generate
+
+package checkpoints
+
+var mainnetCheckpoints = []hashLine{
+	{1, "771fbcd656ec1464d3a02ead5e18644030007a0fc664c0a964d30922821a8148"},
+	{10, "c0e3b387e47042f72d8ccdca88071ff96bff1ac7cde09ae113dbb7ad3fe92381"},
+	{100, "ac3e11ca545e57c49fca2b4e8c48c03c23be047c43e471e1394528b1f9f80b2d"},
+	{1000, "5acfc45acffd2b2e7345caf42fa02308c5793f15ec33946e969e829f40b03876"},
+	{10000, "c758b7c81f928be3295d45e230646de8b852ec96a821eac3fea4daf3fcac0ca2"},
+	{22231, "7cb10e29d67e1c069e6e11b17d30b809724255fee2f6868dc14cfc6ed44dfb25"},
+	{29556, "53c484a8ed91e4da621bb2fa88106dbde426fe90d7ef07b9c1e5127fb6f3a7f6"},
+	{50000, "0fe8758ab06a8b9cb35b7328fd4f757af530b8eeb4ede4cdc13e1e4c4e6e9580"},
+}
+
+var stagenetCheckpoints = []hashLine{}
+
+var testnetCheckpoints = []hashLine{}